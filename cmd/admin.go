@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+)
+
+const adminPathPrefix = "/v1/admin"
+
+// registerAdminRoutes adds the endpoints to start, inspect and cancel download runs,
+// query the run history and trigger the cleanup job, all requiring the token as a
+// bearer token
+func registerAdminRoutes(r *gin.Engine, runs *internal.RunManager, history *internal.History, token string) {
+	admin := r.Group(adminPathPrefix, requireToken(token))
+	admin.GET("/runs", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"runs": runs.Runs()})
+	})
+	admin.POST("/runs", func(c *gin.Context) {
+		// The body is optional, and restricts the run as the download command's flags do
+		var req internal.RunFilter
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		req.Overlays = append(req.Overlays, c.QueryArray("overlay")...)
+
+		run, err := runs.StartFiltered(internal.TriggerAdmin, req)
+		if errors.Is(err, internal.ErrNotLeader) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, internal.ErrRunInProgress) || errors.Is(err, internal.ErrCollecting) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Location", adminPathPrefix+"/runs/"+run.ID)
+		c.JSON(http.StatusAccepted, run)
+	})
+	admin.GET("/runs/:id", func(c *gin.Context) {
+		run, err := runs.Get(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, run)
+	})
+	admin.GET("/runs/:id/progress", func(c *gin.Context) {
+		run, err := runs.Get(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if run.Progress == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": internal.ErrRunFinished.Error(), "summary": run.Summary})
+			return
+		}
+		c.JSON(http.StatusOK, run.Progress)
+	})
+	admin.POST("/runs/:id/cancel", func(c *gin.Context) {
+		switch err := runs.Cancel(c.Param("id")); {
+		case errors.Is(err, internal.ErrRunNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, internal.ErrRunFinished):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.Status(http.StatusAccepted)
+		}
+	})
+	admin.GET("/history", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		opts := HistoryOptions{
+			Since:  c.Query("since"),
+			Until:  c.Query("until"),
+			FileId: c.Query("fileId"),
+			Status: c.Query("status"),
+			Limit:  limit,
+		}
+		q, err := opts.query()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		found, err := history.Query(q)
+		if err != nil {
+			log.Printf("Failed to query the run history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"runs": found})
+	})
+	admin.POST("/cleanup", func(c *gin.Context) {
+		dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+		report, err := runs.Cleanup(c.Request.Context(), dryRun)
+		if errors.Is(err, internal.ErrNotLeader) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Cleanup requested through the admin API failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
+
+// requireToken rejects requests without the token in an `Authorization: Bearer`
+// header
+func requireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
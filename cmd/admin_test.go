@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDataHub serves an order of blank images, holding each download until released
+type fakeDataHub struct {
+	release chan struct{}
+}
+
+func (f *fakeDataHub) GetLatest(string, internal.QueryParams) (*metoffice.Response, error) {
+	runTime := time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC)
+	files := []metoffice.File{{FileId: "rain_ts0_2025091500", RunDateTime: runTime, Run: "00"}}
+	return &metoffice.Response{OrderDetails: metoffice.OrderDetails{Files: files}}, nil
+}
+
+func (f *fakeDataHub) GetLatestDataFile(string, string, internal.QueryParams) (io.ReadCloser, error) {
+	<-f.release
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func testAdminServer(t *testing.T) (*gin.Engine, *internal.RunManager, *fakeDataHub) {
+	gin.SetMode(gin.TestMode)
+	root := t.TempDir()
+	client := &fakeDataHub{release: make(chan struct{})}
+	cfg := &config.Config{Cron: config.CronConfig{Download: config.DownloadJobConfig{PoolSize: 1}}}
+	runs := internal.NewRunManager(cfg, store.NewFileStore(root), client, "order-id")
+	history := internal.NewHistory(root)
+	runs.SetHistory(history)
+
+	r := gin.New()
+	registerAdminRoutes(r, runs, history, "secret")
+	return r, runs, client
+}
+
+func adminRequest(r http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdmin_RequireToken(t *testing.T) {
+	r, _, _ := testAdminServer(t)
+
+	for _, token := range []string{"", "wrong"} {
+		w := adminRequest(r, http.MethodGet, adminPathPrefix+"/runs", token, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, token)
+		assert.Equal(t, `Bearer realm="admin"`, w.Header().Get("WWW-Authenticate"))
+	}
+
+	w := adminRequest(r, http.MethodGet, adminPathPrefix+"/runs", "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"runs": []}`, w.Body.String())
+}
+
+func TestAdmin_StartRun(t *testing.T) {
+	r, runs, client := testAdminServer(t)
+
+	w := adminRequest(r, http.MethodPost, adminPathPrefix+"/runs", "secret", `{"overlays": ["rain"]}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var run internal.Run
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &run))
+	assert.Equal(t, internal.TriggerAdmin, run.Trigger)
+	assert.Equal(t, []string{"rain"}, run.Overlays)
+	assert.Equal(t, adminPathPrefix+"/runs/"+run.ID, w.Header().Get("Location"))
+
+	w = adminRequest(r, http.MethodPost, adminPathPrefix+"/runs", "secret", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = adminRequest(r, http.MethodPost, adminPathPrefix+"/runs", "secret", `{"overlays":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	close(client.release)
+	runs.Wait()
+
+	w = adminRequest(r, http.MethodGet, adminPathPrefix+"/runs/"+run.ID, "secret", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &run))
+	assert.Equal(t, internal.RunCompleted, run.Status)
+
+	w = adminRequest(r, http.MethodPost, adminPathPrefix+"/runs/"+run.ID+"/cancel", "secret", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = adminRequest(r, http.MethodGet, adminPathPrefix+"/runs/unknown", "secret", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdmin_NotLeader(t *testing.T) {
+	r, runs, _ := testAdminServer(t)
+	leader, err := internal.NewLeader(lock.NewFileLock(filepath.Join(t.TempDir(), ".cron.lock")), time.Minute)
+	require.NoError(t, err)
+	runs.SetLeader(leader)
+
+	w := adminRequest(r, http.MethodPost, adminPathPrefix+"/runs", "secret", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = adminRequest(r, http.MethodPost, adminPathPrefix+"/cleanup", "secret", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = adminRequest(r, http.MethodPost, adminPathPrefix+"/cleanup?dryRun=true", "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, runs.Runs())
+}
@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -16,6 +19,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rm-hull/godx"
 	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/notify"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
 	healthcheck "github.com/tavsec/gin-healthcheck"
 	"github.com/tavsec/gin-healthcheck/checks"
 	hc_config "github.com/tavsec/gin-healthcheck/config"
@@ -23,11 +32,14 @@ import (
 
 const staticPathPrefix = "/v1/metoffice/datahub/"
 
-var forecastPathRegexp = regexp.MustCompile(`^([^/]+)/(\d{4}/\d{2}/\d{2})/(\d{2})\.webp$`)
+var legendPathRegexp = regexp.MustCompile(`^/([^/]+)/legend(?:\.(json|png|svg))?$`)
 
-// ApiServer starts an HTTP server to serve static files from rootDir on the given port.
+var frameExtRegexp = regexp.MustCompile(`^\.(?:webp|png|jpg|json)$`)
+
+// ApiServer starts an HTTP server to serve frames from the configured store (by default
+// the rootDir directory) on the given port.
 // If debug is true, pprof endpoints are enabled.
-func ApiServer(rootDir string, port int, debug bool) error {
+func ApiServer(cfg *config.Config, rootDir string, port int, debug bool) error {
 	godx.GitVersion()
 	godx.UserInfo()
 	godx.EnvironmentVars()
@@ -42,7 +54,43 @@ func ApiServer(rootDir string, port int, debug bool) error {
 		return errors.New("environment variable METOFFICE_ORDER_ID not set")
 	}
 
-	_, err := internal.StartCron(rootDir, apiKey, orderId)
+	frames, err := store.New(cfg.Storage, rootDir)
+	if err != nil {
+		return err
+	}
+
+	runs := internal.NewRunManager(cfg, frames, internal.NewDataHubClient(apiKey), orderId)
+	history := internal.NewHistory(rootDir)
+	runs.SetHistory(history)
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return err
+	}
+	runs.SetNotifier(notifier)
+	events := internal.NewEventHub()
+	runs.SetEvents(events)
+	// The replica holding the lock publishes the events, so with Redis they are shared
+	// with the others for their clients of the events stream
+	if cfg.Cron.Lock.Backend == lock.BackendRedis {
+		relay, err := internal.NewRedisEventRelay(cfg.Cron.Lock.Redis)
+		if err != nil {
+			return err
+		}
+		if err := events.SetRelay(relay); err != nil {
+			return err
+		}
+	}
+	locker, err := lock.New(cfg.Cron.Lock, rootDir)
+	if err != nil {
+		return err
+	}
+	leader, err := internal.NewLeader(locker, cfg.Cron.Lock.TTL)
+	if err != nil {
+		return err
+	}
+	runs.SetLeader(leader)
+	events.SetLeader(leader)
+	_, err = internal.StartCron(cfg, runs, leader)
 	if err != nil {
 		return err
 	}
@@ -71,24 +119,25 @@ func ApiServer(rootDir string, port int, debug bool) error {
 		return fmt.Errorf("failed to initialize healthcheck: %v", err)
 	}
 
-	r.Static(staticPathPrefix, rootDir)
+	// Make sure every configured output format is served with the right content type
+	for _, enc := range internal.Encoders() {
+		if err := mime.AddExtensionType(enc.Extension(), enc.ContentType()); err != nil {
+			return fmt.Errorf("failed to register content type for %s: %v", enc.Extension(), err)
+		}
+	}
 
-	// Global 404 handler for unmatched routes (including static file misses)
-	r.NoRoute(func(c *gin.Context) {
+	if token := os.Getenv("ADMIN_API_TOKEN"); token != "" {
+		registerAdminRoutes(r, runs, history, token)
+	} else {
+		log.Println("Admin API disabled: environment variable ADMIN_API_TOKEN not set")
+	}
 
-		if strings.HasPrefix(c.Request.URL.Path, staticPathPrefix) {
-			err := tryPreviousDaysForecast(c)
-			if err == nil {
-				return
-			}
-			log.Printf("Error handling previous day's forecast redirect: %v", err)
-		}
+	serveFrame := frameHandler(frames, events)
+	r.GET(staticPathPrefix+"*filepath", serveFrame)
+	r.HEAD(staticPathPrefix+"*filepath", serveFrame)
 
-		c.JSON(404, gin.H{
-			"error": "Resource not found",
-			"path":  c.Request.URL.Path,
-		})
-	})
+	// Global 404 handler for unmatched routes (including static file misses)
+	r.NoRoute(notFound)
 
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Starting HTTP API Server on port %d...", port)
@@ -98,41 +147,227 @@ func ApiServer(rootDir string, port int, debug bool) error {
 	return nil
 }
 
+func notFound(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, staticPathPrefix) {
+		err := tryPreviousDaysForecast(c)
+		if err == nil {
+			return
+		}
+		log.Printf("Error handling previous day's forecast redirect: %v", err)
+	}
+
+	c.JSON(404, gin.H{
+		"error": "Resource not found",
+		"path":  c.Request.URL.Path,
+	})
+}
+
+// frameHandler serves forecast frames from the frame store, substituting a smaller size
+// variant when the client asks for one with the `size` query parameter or
+// indicates its display width through the Sec-CH-Width/Width client hints.
+// Requests for a frame missing from one storage layout are redirected to the
+// same frame in the other, and any other missing file falls through to the 404
+// handler. The events stream is also served from here.
+func frameHandler(frames store.FrameStore, events *internal.EventHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		file := path.Clean(c.Param("filepath"))
+		if file == eventsPath {
+			serveEvents(c, events)
+			return
+		}
+		if matches := legendPathRegexp.FindStringSubmatch(file); matches != nil {
+			serveLegend(c, matches[1], matches[2])
+			return
+		}
+		// Dotfiles, such as the run history, lock file and dedup blobs, are not frames
+		if store.IsHidden(strings.TrimPrefix(file, "/")) {
+			notFound(c)
+			return
+		}
+
+		c.Header("Accept-CH", "Sec-CH-Width, Sec-CH-Viewport-Width")
+		c.Header("Vary", "Accept, Sec-CH-Width, Sec-CH-Viewport-Width, Width, Viewport-Width")
+
+		if variant, ok := requestedVariant(c); ok && !variant.IsFull() {
+			ext := path.Ext(file)
+			candidate := strings.TrimSuffix(file, ext) + "." + variant.Name + ext
+			if isFramePath(file) && fileExists(ctx, frames, candidate) {
+				file = candidate
+			}
+		}
+
+		r, info, err := frames.Get(ctx, strings.TrimPrefix(file, "/"))
+		if errors.Is(err, store.ErrNotExist) {
+			if alt, ok := alternateLayoutPath(ctx, frames, file); ok {
+				redirect(c, staticPathPrefix+alt)
+				return
+			}
+			notFound(c)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read %s from frame store: %v", file, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to read frame",
+				"path":  c.Request.URL.Path,
+			})
+			return
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+
+		if info.ContentType != "" {
+			c.Header("Content-Type", info.ContentType)
+		}
+		http.ServeContent(c.Writer, c.Request, path.Base(file), info.ModTime, r)
+	}
+}
+
+// serveLegend renders the overlay's legend, as it appears after pipeline processing,
+// in the format given by the path extension, the `format` query parameter or the
+// Accept header (in that order), defaulting to JSON
+func serveLegend(c *gin.Context, overlay string, format string) {
+	def, ok := internal.Overlays[overlay]
+	if !ok || def.Legend == nil {
+		notFound(c)
+		return
+	}
+	l := def.DisplayLegend()
+
+	if format == "" {
+		format = c.Query("format")
+	}
+	if format == "" {
+		switch c.NegotiateFormat(gin.MIMEJSON, "image/png", "image/svg+xml") {
+		case "image/png":
+			format = "png"
+		case "image/svg+xml":
+			format = "svg"
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	switch format {
+	case "", "json":
+		c.JSON(http.StatusOK, l)
+	case "png":
+		c.Header("Content-Type", "image/png")
+		if err := l.WritePNG(c.Writer); err != nil {
+			log.Printf("Failed to render legend for %s: %v", overlay, err)
+		}
+	case "svg":
+		c.Header("Content-Type", "image/svg+xml")
+		if err := l.WriteSVG(c.Writer); err != nil {
+			log.Printf("Failed to render legend for %s: %v", overlay, err)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("unsupported legend format: %s", format),
+		})
+	}
+}
+
+// requestedVariant determines which size variant the client wants. An explicit
+// `size` query parameter takes precedence over a `size` or `width` parameter in the
+// Accept header, which in turn takes precedence over any client hint headers.
+func requestedVariant(c *gin.Context) (imageprocessing.Variant, bool) {
+	if size := c.Query("size"); size != "" {
+		return imageprocessing.FindVariant(imageprocessing.DefaultVariants, size)
+	}
+	if v, ok := imageprocessing.AcceptedVariant(imageprocessing.DefaultVariants, c.GetHeader("Accept")); ok {
+		return v, true
+	}
+
+	for _, header := range []string{"Sec-CH-Width", "Width", "Sec-CH-Viewport-Width", "Viewport-Width"} {
+		if value := c.GetHeader(header); value != "" {
+			width, err := strconv.Atoi(value)
+			if err != nil || width <= 0 {
+				continue
+			}
+			return imageprocessing.SelectVariant(imageprocessing.DefaultVariants, width), true
+		}
+	}
+	return imageprocessing.Variant{}, false
+}
+
+func fileExists(ctx context.Context, frames store.FrameStore, file string) bool {
+	_, err := frames.Stat(ctx, strings.TrimPrefix(file, "/"))
+	return err == nil
+}
+
 // tryPreviousDaysForecast attempts to handle requests for missing forecast files
 // by redirecting to the previous day's forecast at the same hour + 24.
 // For example, a request for /v1/metoffice/datahub/cloud_amount_total/2023/10/15/20.webp
 // would be redirected to /v1/metoffice/datahub/cloud_amount_total/2023/10/14/44.webp
 // if the original file is not found.
 func tryPreviousDaysForecast(c *gin.Context) error {
-	// Use regex to extract overlay, year, month, day, hour
 	// Example path: /v1/metoffice/datahub/cloud_amount_total/2023/10/15/20.webp
 	trimmedPath := strings.TrimPrefix(c.Request.URL.Path, staticPathPrefix)
-	matches := forecastPathRegexp.FindStringSubmatch(trimmedPath)
-	if len(matches) != 4 {
+	id, ok := parseFramePath(trimmedPath)
+	if !ok {
 		return fmt.Errorf("URL path does not match expected format: %s", trimmedPath)
 	}
-	overlay := matches[1]
-	dt, err := time.Parse("2006/01/02", matches[2])
-	if err != nil {
-		return fmt.Errorf("invalid date format in URL: %v", err)
+
+	// Subtract 1 day to get the previous day, then advance the timestep by 24
+	// to get the same time at the current date
+	prev := id
+	prev.RunDate = id.RunDate.AddDate(0, 0, -1)
+	prev.Timestep = id.Timestep + 24
+
+	if prev.Timestep > metoffice.MaxTimestep {
+		return fmt.Errorf("calculated hour %d is out of range (0-%d)", prev.Timestep, metoffice.MaxTimestep)
 	}
 
-	hour, err := strconv.Atoi(matches[3])
-	if err != nil {
-		return fmt.Errorf("invalid hour format in URL: %v", err)
+	// Construct new URL and redirect, preserving any variant selection
+	redirect(c, staticPathPrefix+prev.Path()+path.Ext(trimmedPath))
+	return nil
+}
+
+// redirect temporarily redirects to the path, keeping the query (e.g. the size variant)
+func redirect(c *gin.Context, newURL string) {
+	if c.Request.URL.RawQuery != "" {
+		newURL += "?" + c.Request.URL.RawQuery
 	}
+	c.Redirect(http.StatusTemporaryRedirect, newURL)
+}
 
-	// Subtract 1 day to get the previous day, then advance the hour by 24
-	// to get the same time at the current date
-	prevDay := dt.AddDate(0, 0, -1).Format("2006/01/02")
-	prevHour := hour + 24
+// alternateLayoutPath finds the frame requested in one storage layout in the other
+// layout, so that URLs of either keep working while a tree is being migrated. A frame
+// in the run layout is looked up in the run with the shortest lead time
+func alternateLayoutPath(ctx context.Context, frames store.FrameStore, file string) (string, bool) {
+	file = strings.TrimPrefix(file, "/")
+	if kind, validTime, suffix, err := internal.ParseValidTimePath(file); err == nil {
+		id := metoffice.FileID{Kind: kind, RunDate: validTime.Truncate(24 * time.Hour), Timestep: validTime.Hour()}
+		for ; id.Timestep <= metoffice.MaxTimestep; id.Timestep += 24 {
+			if candidate := id.Path() + suffix; fileExists(ctx, frames, candidate) {
+				return candidate, true
+			}
+			id.RunDate = id.RunDate.AddDate(0, 0, -1)
+		}
+		return "", false
+	}
 
-	if prevHour > 72 {
-		return fmt.Errorf("calculated hour %d is out of range (0-72)", prevHour)
+	if id, suffix, err := metoffice.ParsePath(file); err == nil {
+		candidate := internal.ValidTimePath(id.Kind, id.ValidTime()) + suffix
+		return candidate, fileExists(ctx, frames, candidate)
 	}
+	return "", false
+}
 
-	// Construct new URL and redirect
-	newURL := fmt.Sprintf("%s%s/%s/%02d.webp", staticPathPrefix, overlay, prevDay, prevHour)
-	c.Redirect(http.StatusTemporaryRedirect, newURL)
-	return nil
+// isFramePath reports whether the file is a frame in either storage layout, without a
+// size variant
+func isFramePath(file string) bool {
+	if _, _, suffix, err := internal.ParseValidTimePath(strings.TrimPrefix(file, "/")); err == nil {
+		return frameExtRegexp.MatchString(suffix)
+	}
+	_, ok := parseFramePath(file)
+	return ok
+}
+
+// parseFramePath parses a {kind}/YYYY/MM/DD[/{HH}Z]/HH.<ext> frame path, without a size variant
+func parseFramePath(file string) (metoffice.FileID, bool) {
+	id, suffix, err := metoffice.ParsePath(strings.TrimPrefix(file, "/"))
+	return id, err == nil && frameExtRegexp.MatchString(suffix)
 }
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameHandler_Hidden(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	root := t.TempDir()
+	for _, file := range []string{".history.jsonl", ".cron.lock", ".blobs/sha256/ab/abc", "rain/2025/09/15/.00.webp.attrs", "rain/2025/09/15/00.webp"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, file), []byte("{}"), 0644))
+	}
+	r := gin.New()
+	serveFrame := frameHandler(store.NewDedupStore(store.NewFileStore(root)), internal.NewEventHub())
+	r.GET(staticPathPrefix+"*filepath", serveFrame)
+
+	get := func(file string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, staticPathPrefix+file, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("rain/2025/09/15/00.webp"))
+	for _, file := range []string{".history.jsonl", ".cron.lock", ".blobs/sha256/ab/abc", "rain/2025/09/15/.00.webp.attrs", "rain/../.history.jsonl"} {
+		assert.Equal(t, http.StatusNotFound, get(file), file)
+	}
+}
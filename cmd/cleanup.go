@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// Cleanup applies the retention policy to the stored frames, and when dryRun is set
+// reports what would be deleted instead
+func Cleanup(cfg *config.Config, rootDir string, dryRun bool) error {
+	frames, err := store.New(cfg.Storage, rootDir)
+	if err != nil {
+		return err
+	}
+
+	report, err := internal.ApplyRetention(context.Background(), frames, cfg.Retention, time.Now().UTC(), dryRun)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if dryRun {
+		prefix = "[dry run] "
+		for _, frame := range report.Expired {
+			log.Printf("%sWould delete %s (%s, %d files, %d bytes)", prefix, frame.Path, frame.Reason, frame.Files, frame.Bytes)
+		}
+	}
+
+	kinds := make([]string, 0, len(report.Kinds))
+	for kind := range report.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		totals := report.Kinds[kind]
+		log.Printf("%s%s: %d frames (%d files, %d bytes)", prefix, kind, totals.Frames, totals.Files, totals.Bytes)
+	}
+	log.Printf("%sDeleted %d expired frames (%d files), freeing %d bytes; %d bytes remaining",
+		prefix, report.Total.Frames, report.Total.Files, report.Total.Bytes, report.RemainingBytes)
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// Dedup reports the space saved by deduplicating frames, and when gc is set deletes the
+// blobs that no frame refers to any more
+func Dedup(cfg *config.Config, rootDir string, gc, dryRun bool) error {
+	if !cfg.Storage.Dedup {
+		return errors.New("dedup is not enabled in the storage config")
+	}
+	frames, err := store.New(cfg.Storage, rootDir)
+	if err != nil {
+		return err
+	}
+	dedup := frames.(*store.DedupStore)
+
+	ctx := context.Background()
+	var report store.DedupReport
+	if gc {
+		report, err = dedup.CollectGarbage(ctx, dryRun)
+	} else {
+		report, err = dedup.Report(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%d frames share %d blobs: %d bytes stored for %d bytes of frames, saving %d bytes",
+		report.References, report.Blobs-report.Unreferenced, report.StoredBytes-report.UnreferencedBytes, report.LogicalBytes, report.SavedBytes())
+	if gc {
+		prefix := ""
+		if dryRun {
+			prefix = "[dry run] "
+		}
+		log.Printf("%sCollected %d of %d unreferenced blobs (%d bytes)",
+			prefix, report.Collected, report.Unreferenced, report.CollectedBytes)
+	} else {
+		log.Printf("%d blobs (%d bytes) are no longer referenced", report.Unreferenced, report.UnreferencedBytes)
+	}
+	return nil
+}
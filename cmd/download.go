@@ -5,12 +5,62 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/rm-hull/godx"
 	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/notify"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
 )
 
-func Download(rootDir string, poolSize int) error {
+// DownloadOptions are the flags of the download command
+type DownloadOptions struct {
+	PoolSize int
+	// Progress is how progress is reported: auto, bar, json or none
+	Progress string
+	// DryRun prints what would be downloaded without fetching any image data
+	DryRun bool
+
+	// Overlays, Timesteps (e.g. 0-12) and the RFC 3339 ValidFrom and ValidUntil restrict
+	// the files downloaded
+	Overlays   []string
+	Timesteps  string
+	ValidFrom  string
+	ValidUntil string
+	// Force re-fetches files even when their frames already exist
+	Force bool
+	// Limit is the most files downloaded, all of them when zero
+	Limit int
+}
+
+func (o DownloadOptions) filter() (internal.RunFilter, error) {
+	filter := internal.RunFilter{Overlays: o.Overlays, Force: o.Force, Limit: o.Limit}
+	if o.Timesteps != "" {
+		timesteps, err := internal.ParseTimestepRange(o.Timesteps)
+		if err != nil {
+			return filter, err
+		}
+		filter.Timesteps = &timesteps
+	}
+	var err error
+	if filter.ValidFrom, err = parseValidTime(o.ValidFrom); err != nil {
+		return filter, fmt.Errorf("invalid valid-from: %w", err)
+	}
+	if filter.ValidUntil, err = parseValidTime(o.ValidUntil); err != nil {
+		return filter, fmt.Errorf("invalid valid-until: %w", err)
+	}
+	return filter, nil
+}
+
+func parseValidTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func Download(cfg *config.Config, rootDir string, opts DownloadOptions) error {
 	godx.GitVersion()
 	godx.UserInfo()
 	godx.EnvironmentVars()
@@ -25,20 +75,56 @@ func Download(rootDir string, poolSize int) error {
 		return errors.New("environment variable METOFFICE_ORDER_ID not set")
 	}
 
-	downloader, err := internal.NewDownloader(rootDir, poolSize, apiKey, orderId)
+	filter, err := opts.filter()
+	if err != nil {
+		return err
+	}
+	frames, err := store.New(cfg.Storage, rootDir)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return dryRun(cfg, frames, apiKey, orderId, opts.PoolSize, filter)
+	}
+	reporter, err := newProgressReporter(opts.Progress)
 	if err != nil {
 		return err
 	}
 
-	downloader.StartWorkers()
-	downloader.DispatchJobs()
-	errors := downloader.Wait()
+	// Runs from the command line are recorded in the same history as the API server's
+	cfg.Cron.Download.PoolSize = opts.PoolSize
+	runs := internal.NewRunManager(cfg, frames, internal.NewDataHubClient(apiKey), orderId)
+	runs.SetHistory(internal.NewHistory(rootDir))
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return err
+	}
+	runs.SetNotifier(notifier)
+	runs.OnProgress(reporter.update)
+	started, err := runs.StartFiltered(internal.TriggerCLI, filter)
+	if err != nil {
+		return err
+	}
+	reporter.start(started.ID, func() (internal.Progress, bool) {
+		run, err := runs.Get(started.ID)
+		if err != nil || run.Progress == nil {
+			return internal.Progress{}, false
+		}
+		return *run.Progress, true
+	})
+	runs.Wait()
+	reporter.stop()
+	notifier.Wait()
 
-	if len(errors) > 0 {
-		for _, err := range errors {
-			log.Printf("Error: %v", err)
+	run, err := runs.Get(started.ID)
+	if err != nil {
+		return err
+	}
+	if run.Status == internal.RunFailed {
+		for _, file := range run.Summary.Failed {
+			log.Printf("Error: %s: %s", file.FileId, file.Reason)
 		}
-		return fmt.Errorf("%d error(s) occurred", len(errors))
+		return fmt.Errorf("%d error(s) occurred", len(run.Summary.Failed))
 	}
 
 	return nil
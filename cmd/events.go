@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+)
+
+// eventsPath is served by the frame handler, as the catch-all frame route leaves no
+// room for a route of its own
+const eventsPath = "/events"
+
+// keepAliveInterval is how often a comment is sent on an idle events stream, so that
+// proxies do not close it
+const keepAliveInterval = 30 * time.Second
+
+// serveEvents streams catalog events as Server-Sent Events: a "frame" event for every
+// frame written, a "run" event when a download run finishes and a "cleanup" event when
+// frames are deleted. A client reconnecting with the Last-Event-ID header is first
+// sent the recent events it missed. Replicas that only stream their own events answer
+// 503 unless they hold the cron lock, and end the stream when they lose it
+func serveEvents(c *gin.Context, events *internal.EventHub) {
+	if c.Request.Method != http.MethodGet {
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	if !events.Streaming() {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": internal.ErrNotLeader.Error()})
+		return
+	}
+
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	missed, ch, unsubscribe := events.Subscribe(lastID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range missed {
+		renderEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				// Too far behind, so the client reconnects to catch up
+				return false
+			}
+			renderEvent(c, event)
+			return true
+		case <-keepAlive.C:
+			if !events.Streaming() {
+				return false
+			}
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func renderEvent(c *gin.Context, event internal.CatalogEvent) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(event.ID, 10),
+		Event: event.Type,
+		Data:  event.Data,
+	})
+}
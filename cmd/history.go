@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+)
+
+// HistoryOptions selects the runs shown by the history command and API, with times
+// given as RFC 3339 or YYYY-MM-DD (UTC)
+type HistoryOptions struct {
+	Since  string
+	Until  string
+	FileId string
+	Status string
+	Limit  int
+	// JSON prints each run, with the outcome of every file, as a JSON line
+	JSON bool
+}
+
+func (o HistoryOptions) query() (internal.HistoryQuery, error) {
+	q := internal.HistoryQuery{FileId: o.FileId, Limit: o.Limit}
+	var err error
+	if q.Since, err = parseHistoryTime(o.Since); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseHistoryTime(o.Until); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	switch status := internal.FileStatus(o.Status); status {
+	case "", internal.StatusPending, internal.StatusSucceeded, internal.StatusSkipped, internal.StatusFailed:
+		q.Status = status
+	default:
+		return q, fmt.Errorf("invalid status: %s", o.Status)
+	}
+	return q, nil
+}
+
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// History prints the runs recorded in the history below rootDir, most recent first.
+// Unless files are selected by fileId or status, only the failures of each run are
+// listed
+func History(rootDir string, opts HistoryOptions) error {
+	q, err := opts.query()
+	if err != nil {
+		return err
+	}
+	runs, err := internal.NewHistory(rootDir).Query(q)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, run := range runs {
+			if err := enc.Encode(run); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, run := range runs {
+		fmt.Printf("%s  %-9s %-5s  %s\n", run.StartTime.UTC().Format(time.RFC3339), run.Status, run.Trigger, run.ID)
+		if s := run.Summary; s != nil {
+			fmt.Printf("    %d files: %d succeeded, %d skipped, %d failed, %d bytes downloaded in %s\n",
+				s.Succeeded+len(s.Skipped)+len(s.Failed), s.Succeeded, len(s.Skipped), len(s.Failed), s.Bytes,
+				s.EndTime.Sub(s.StartTime).Round(time.Second))
+		}
+		for _, file := range run.Files {
+			if opts.FileId == "" && opts.Status == "" && file.Status != internal.StatusFailed {
+				continue
+			}
+			fmt.Printf("    %-9s %s %s\n", file.Status, file.FileId, file.Reason)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// Migrate converts the run layout tree below rootDir to the valid time layout
+func Migrate(cfg *config.Config, rootDir string, removeSource, dryRun bool) error {
+	if cfg.Storage.Backend != "" && cfg.Storage.Backend != store.BackendFS {
+		return errors.New("migrate only supports the fs storage backend")
+	}
+
+	frames := store.NewFileStore(rootDir)
+	report, err := internal.MigrateToValidTimeLayout(context.Background(), frames, removeSource, dryRun)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if dryRun {
+		prefix = "[dry run] "
+	}
+	log.Printf("%sMigrated %d frames (%d files) to the valid time layout, %d superseded by newer runs, %d source files removed",
+		prefix, report.Frames, report.Files, report.Superseded, report.Removed)
+	return nil
+}
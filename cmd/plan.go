@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// dryRun retrieves the order and prints what a download would do with each file,
+// without fetching any image data or recording the run in the history
+func dryRun(cfg *config.Config, frames store.FrameStore, apiKey, orderId string, poolSize int, filter internal.RunFilter) error {
+	layout, err := internal.ParseLayout(cfg.Storage.Layout)
+	if err != nil {
+		return err
+	}
+	downloader, err := internal.NewDownloader(frames, poolSize, apiKey, orderId)
+	if err != nil {
+		return err
+	}
+	downloader.SetLayout(layout)
+	downloader.SetBasemapDir(cfg.Download.BasemapDir)
+	if err := downloader.SetDefaultOverlay(cfg.Download.DefaultOverlay); err != nil {
+		return err
+	}
+	if err := downloader.SetFilter(filter); err != nil {
+		return err
+	}
+
+	planned := downloader.Plan()
+	printPlan(planned)
+	return nil
+}
+
+func printPlan(planned []internal.PlannedFile) {
+	// Retrieving the order is a data call too
+	calls := 1
+	counts := make(map[string]int)
+	for _, file := range planned {
+		counts[file.Action]++
+		fmt.Printf("%-8s %s", file.Action, file.FileId)
+		if file.Kind != "" {
+			fmt.Printf("  kind=%s timestep=%d", file.Kind, file.Timestep)
+		}
+		if !file.ValidTime.IsZero() {
+			fmt.Printf(" valid=%s", file.ValidTime.UTC().Format(time.RFC3339))
+		}
+		if file.Reason != "" {
+			fmt.Printf("  (%s)", file.Reason)
+		}
+		fmt.Println()
+
+		for _, path := range file.Paths {
+			fmt.Printf("    -> %s\n", path)
+		}
+		if file.Action != internal.PlanDownload {
+			continue
+		}
+		calls++
+		pipeline := "none"
+		if len(file.Pipeline) > 0 {
+			pipeline = strings.Join(file.Pipeline, " | ")
+		}
+		if file.Style != "" {
+			pipeline += fmt.Sprintf(" (style %s)", file.Style)
+		}
+		fmt.Printf("    pipeline: %s\n", pipeline)
+	}
+
+	fmt.Printf("\n%d files: %d to download, %d skipped, %d errors; %d DataHub data calls\n",
+		len(planned), counts[internal.PlanDownload], counts[internal.PlanSkip], counts[internal.PlanError], calls)
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+)
+
+// How the download command reports progress
+const (
+	ProgressAuto = "auto"
+	ProgressBar  = "bar"
+	ProgressJSON = "json"
+	ProgressNone = "none"
+)
+
+const (
+	// barRefreshInterval is how often the bar is redrawn between files, to update the
+	// bytes downloaded and the ETA
+	barRefreshInterval = time.Second
+	// jsonProgressInterval is how often a JSON line is written
+	jsonProgressInterval = 5 * time.Second
+	barWidth             = 30
+)
+
+// progressReporter renders the progress of a download run, both when a file is done
+// and periodically in between, until stopped
+type progressReporter interface {
+	update(id string, progress internal.Progress)
+	start(id string, poll func() (internal.Progress, bool))
+	stop()
+}
+
+// newProgressReporter returns the reporter for the mode, where "auto" draws a bar on a
+// terminal and writes JSON lines otherwise
+func newProgressReporter(mode string) (progressReporter, error) {
+	switch mode {
+	case ProgressAuto, "":
+		if isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()) {
+			return newBarReporter(os.Stderr), nil
+		}
+		return newJSONReporter(os.Stdout), nil
+	case ProgressBar:
+		return newBarReporter(os.Stderr), nil
+	case ProgressJSON:
+		return newJSONReporter(os.Stdout), nil
+	case ProgressNone:
+		return noProgress{}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode: %s (expected %s, %s, %s or %s)", mode, ProgressAuto, ProgressBar, ProgressJSON, ProgressNone)
+	}
+}
+
+// ticker calls fn every interval until the returned channel is closed
+func ticker(interval time.Duration, fn func()) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				fn()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+type noProgress struct{}
+
+func (noProgress) update(string, internal.Progress)               {}
+func (noProgress) start(string, func() (internal.Progress, bool)) {}
+func (noProgress) stop()                                          {}
+
+// barReporter redraws a progress bar on the last line of the terminal. It takes over
+// the log output, so that log lines are written above the bar
+type barReporter struct {
+	mu   sync.Mutex
+	out  io.Writer
+	line string
+	done chan struct{}
+}
+
+func newBarReporter(out io.Writer) *barReporter {
+	return &barReporter{out: out}
+}
+
+func (b *barReporter) start(id string, poll func() (internal.Progress, bool)) {
+	log.SetOutput(b)
+	b.done = ticker(barRefreshInterval, func() {
+		if progress, ok := poll(); ok {
+			b.update(id, progress)
+		}
+	})
+}
+
+func (b *barReporter) update(id string, progress internal.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.line = formatBar(progress)
+	_, _ = fmt.Fprint(b.out, "\r\033[K"+b.line)
+}
+
+// Write clears the bar, writes p and then redraws the bar below it
+func (b *barReporter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.line != "" {
+		_, _ = fmt.Fprint(b.out, "\r\033[K")
+	}
+	n, err := b.out.Write(p)
+	if b.line != "" {
+		_, _ = fmt.Fprint(b.out, b.line)
+	}
+	return n, err
+}
+
+func (b *barReporter) stop() {
+	if b.done != nil {
+		close(b.done)
+	}
+	b.mu.Lock()
+	if b.line != "" {
+		_, _ = fmt.Fprintln(b.out)
+		b.line = ""
+	}
+	b.mu.Unlock()
+	log.SetOutput(os.Stderr)
+}
+
+func formatBar(p internal.Progress) string {
+	filled := barWidth * p.Done / max(p.Total, 1)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%s%s] %3.0f%% %d/%d files", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), p.Percent(), p.Done, p.Total)
+	if p.Failed > 0 {
+		fmt.Fprintf(&sb, " (%d failed)", p.Failed)
+	}
+	fmt.Fprintf(&sb, ", %s in %s", formatBytes(p.Bytes), p.Elapsed().Round(time.Second))
+	if eta, ok := p.ETA(); ok {
+		fmt.Fprintf(&sb, ", ETA %s", eta.Round(time.Second))
+	}
+	return sb.String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// jsonReporter writes the progress as a JSON line every few seconds, and once the run
+// is done, for when the output is not a terminal
+type jsonReporter struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	last *progressLine
+	done chan struct{}
+}
+
+type progressLine struct {
+	RunID string `json:"runId"`
+	internal.Progress
+}
+
+func newJSONReporter(out io.Writer) *jsonReporter {
+	return &jsonReporter{enc: json.NewEncoder(out)}
+}
+
+func (j *jsonReporter) start(id string, poll func() (internal.Progress, bool)) {
+	j.done = ticker(jsonProgressInterval, func() {
+		if progress, ok := poll(); ok {
+			j.write(progressLine{RunID: id, Progress: progress})
+		}
+	})
+}
+
+// update keeps the progress for the final line, as a line for every file would be
+// too many
+func (j *jsonReporter) update(id string, progress internal.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.last = &progressLine{RunID: id, Progress: progress}
+}
+
+func (j *jsonReporter) write(line progressLine) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.enc.Encode(line); err != nil {
+		log.Printf("Failed to write progress: %v", err)
+	}
+}
+
+func (j *jsonReporter) stop() {
+	if j.done != nil {
+		close(j.done)
+	}
+	j.mu.Lock()
+	last := j.last
+	j.mu.Unlock()
+	if last != nil {
+		j.write(*last)
+	}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// catchUpLookback is how far back a missed download is caught up
+	catchUpLookback = 24 * time.Hour
+	// catchUpGrace is how long the scheduled download has to start before it is
+	// considered missed
+	catchUpGrace = 5 * time.Minute
+	// catchUpInterval is how often missed downloads are checked for
+	catchUpInterval = time.Minute
+	// catchUpMaxBackoff is the longest wait between attempts to catch up
+	catchUpMaxBackoff = time.Hour
+)
+
+// previousFireTime returns the most recent time the schedule fired at or before now,
+// looking back up to catchUpLookback
+func previousFireTime(schedule cron.Schedule, now time.Time) (time.Time, bool) {
+	var prev time.Time
+	for t := schedule.Next(now.Add(-catchUpLookback)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		prev = t
+	}
+	return prev, !prev.IsZero()
+}
+
+// CatchUp starts a download run when no run of the whole order has completed since
+// the schedule last fired, more than catchUpGrace ago. A caught up run only downloads
+// the files that are missing, so is cheap when the frames are already present. After
+// each attempt, further attempts back off until a run completes
+func (m *RunManager) CatchUp(schedule cron.Schedule, now time.Time) (bool, error) {
+	missed, ok := previousFireTime(schedule, now.Add(-catchUpGrace))
+	if !ok {
+		return false, nil
+	}
+	completed, err := m.completedSince(missed)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	if completed {
+		m.catchUpAttempts = 0
+		m.catchUpRetry = time.Time{}
+	}
+	waiting := now.Before(m.catchUpRetry)
+	m.mu.Unlock()
+	if completed || waiting {
+		return false, nil
+	}
+
+	log.Printf("Scheduled download at %s did not complete, catching up", missed.Format(time.RFC3339))
+	_, err = m.Start(TriggerCatchUp, nil)
+	if errors.Is(err, ErrRunInProgress) || errors.Is(err, ErrCollecting) || errors.Is(err, ErrNotLeader) {
+		return false, nil
+	}
+
+	m.mu.Lock()
+	m.catchUpAttempts++
+	m.catchUpRetry = now.Add(catchUpBackoff(m.catchUpAttempts))
+	m.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// catchUpBackoff is how long to wait after the given number of catch up attempts
+// before trying again, doubling from twice catchUpInterval up to catchUpMaxBackoff
+func catchUpBackoff(attempts int) time.Duration {
+	backoff := catchUpInterval
+	for range attempts {
+		backoff *= 2
+		if backoff >= catchUpMaxBackoff {
+			return catchUpMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// completedSince reports whether a run of the whole order that started at or after t
+// has completed, either in this process or as recorded in the history. Runs that
+// failed, were cancelled or only downloaded some overlays do not count
+func (m *RunManager) completedSince(t time.Time) (bool, error) {
+	complete := func(run *Run) bool {
+		return !run.StartTime.Before(t) && run.Status == RunCompleted && run.full()
+	}
+
+	m.mu.Lock()
+	for _, run := range m.runs {
+		if complete(run) {
+			m.mu.Unlock()
+			return true, nil
+		}
+	}
+	m.mu.Unlock()
+
+	if m.history == nil {
+		return false, nil
+	}
+	runs, err := m.history.Query(HistoryQuery{Since: t})
+	if err != nil {
+		return false, err
+	}
+	for i := range runs {
+		if complete(&runs[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// watchMissedDownloads catches up the scheduled download at startup, then checks for
+// missed downloads every minute, such as after the machine was asleep. A jump in the
+// wall clock between checks is logged, as timers do not fire while asleep. Only the
+// leader catches up
+func watchMissedDownloads(schedule cron.Schedule, loc *time.Location, runs *RunManager, leader *Leader) {
+	catchUp := func(now time.Time) {
+		if !leader.IsLeader() {
+			return
+		}
+		if _, err := runs.CatchUp(schedule, now.In(loc)); err != nil {
+			log.Printf("Failed to catch up missed download: %v", err)
+		}
+	}
+
+	last := time.Now().Round(0)
+	catchUp(last)
+
+	ticker := time.NewTicker(catchUpInterval)
+	for range ticker.C {
+		// Round(0) strips the monotonic clock, which does not advance while asleep
+		now := time.Now().Round(0)
+		if gap := now.Sub(last); gap > 2*catchUpInterval {
+			log.Printf("Wall clock jumped %s since the last check for missed downloads (was the machine asleep?)", gap.Round(time.Second))
+		}
+		last = now
+		catchUp(now)
+	}
+}
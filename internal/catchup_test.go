@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviousFireTime(t *testing.T) {
+	schedule, err := cron.ParseStandard("30 4,5,6 * * *")
+	require.NoError(t, err)
+
+	prev, ok := previousFireTime(schedule, time.Date(2025, 9, 15, 7, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 9, 15, 6, 30, 0, 0, time.UTC), prev)
+
+	prev, ok = previousFireTime(schedule, time.Date(2025, 9, 15, 5, 30, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 9, 15, 5, 30, 0, 0, time.UTC), prev)
+
+	prev, ok = previousFireTime(schedule, time.Date(2025, 9, 15, 3, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 9, 14, 6, 30, 0, 0, time.UTC), prev)
+
+	yearly, err := cron.ParseStandard("@yearly")
+	require.NoError(t, err)
+	_, ok = previousFireTime(yearly, time.Date(2025, 9, 15, 3, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
+
+func TestRunManager_CatchUp(t *testing.T) {
+	schedule, err := cron.ParseStandard("30 4,5,6 * * *")
+	require.NoError(t, err)
+	client := newFakeDataHub("rain_ts0_2025091500")
+	runs, _ := testRunManager(t, client)
+
+	// A run recorded by a previous process after the missed time counts, once it has
+	// completed a download of the whole order
+	require.NoError(t, runs.history.Append(Run{ID: "partial", Status: RunCompleted, Overlays: []string{"rain"}, StartTime: time.Date(2025, 9, 15, 5, 31, 0, 0, time.UTC)}))
+	require.NoError(t, runs.history.Append(Run{ID: "failed", Status: RunFailed, StartTime: time.Date(2025, 9, 15, 5, 32, 0, 0, time.UTC)}))
+	require.NoError(t, runs.history.Append(Run{ID: "earlier", Status: RunCompleted, StartTime: time.Date(2025, 9, 15, 5, 33, 0, 0, time.UTC)}))
+	caughtUp, err := runs.CatchUp(schedule, time.Date(2025, 9, 15, 6, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.False(t, caughtUp)
+
+	// The scheduled run is given some time to start
+	caughtUp, err = runs.CatchUp(schedule, time.Date(2025, 9, 15, 6, 32, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.False(t, caughtUp)
+
+	caughtUp, err = runs.CatchUp(schedule, time.Date(2025, 9, 15, 7, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, caughtUp)
+	runs.Wait()
+
+	listed := runs.Runs()
+	require.Len(t, listed, 1)
+	assert.Equal(t, TriggerCatchUp, listed[0].Trigger)
+	assert.Equal(t, RunCompleted, listed[0].Status)
+
+	caughtUp, err = runs.CatchUp(schedule, time.Date(2025, 9, 15, 7, 1, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.False(t, caughtUp)
+}
+
+func TestRunManager_CatchUpBackoff(t *testing.T) {
+	schedule, err := cron.ParseStandard("30 4,5,6 * * *")
+	require.NoError(t, err)
+	client := newFakeDataHub("rain_ts0_2025091500")
+	client.err = errors.New("service unavailable")
+	runs, _ := testRunManager(t, client)
+	now := time.Date(2025, 9, 15, 7, 0, 0, 0, time.UTC)
+
+	// A run that could not retrieve the order did not download anything, and each
+	// failed attempt waits longer before the next
+	_, err = runs.CatchUp(schedule, now)
+	assert.ErrorContains(t, err, "service unavailable")
+	assert.Equal(t, 1, client.calls)
+	for _, wait := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute} {
+		caughtUp, err := runs.CatchUp(schedule, now.Add(wait-time.Second))
+		require.NoError(t, err)
+		assert.False(t, caughtUp, "waits %s", wait)
+
+		now = now.Add(wait)
+		_, err = runs.CatchUp(schedule, now)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 4, client.calls)
+
+	// Once the order is available again, the run completes and the backoff is reset
+	client.err = nil
+	caughtUp, err := runs.CatchUp(schedule, now.Add(16*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, caughtUp)
+	runs.Wait()
+	caughtUp, err = runs.CatchUp(schedule, now.Add(17*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, caughtUp)
+	assert.Zero(t, runs.catchUpAttempts)
+}
+
+func TestCatchUpBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Minute, catchUpBackoff(1))
+	assert.Equal(t, 16*time.Minute, catchUpBackoff(4))
+	assert.Equal(t, catchUpMaxBackoff, catchUpBackoff(6))
+	assert.Equal(t, catchUpMaxBackoff, catchUpBackoff(100))
+}
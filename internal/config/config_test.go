@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]ByteSize{
+		"0":       0,
+		"1024":    1024,
+		"500MB":   500_000_000,
+		"1.5 GiB": 3 << 29,
+		"2tb":     2_000_000_000_000,
+	}
+	for s, expected := range tests {
+		size, err := ParseByteSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+
+	for _, s := range []string{"", "MB", "10 parsecs", "-1GB", "1.2.3KB"} {
+		_, err := ParseByteSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLoad_CronDefaultsAndEnv(t *testing.T) {
+	cfg, err := Load(DefaultPath)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultDownloadSchedule, cfg.Cron.Download.Schedule)
+	assert.Equal(t, DefaultDownloadPoolSize, cfg.Cron.Download.PoolSize)
+	assert.Equal(t, DefaultCleanupSchedule, cfg.Cron.Cleanup.Schedule)
+	assert.Nil(t, cfg.Cron.Jobs)
+	assert.Equal(t, DownloadModeSchedule, cfg.Cron.Download.Mode)
+	assert.Equal(t, DefaultPollInterval, cfg.Cron.Download.Poll.Interval)
+	assert.Equal(t, LockConfig{TTL: DefaultLockTTL}, cfg.Cron.Lock)
+	assert.Equal(t, DefaultBasemapDir, cfg.Download.BasemapDir)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("cron:\n  timezone: UTC\n  download:\n    poolSize: 4\n    poll:\n      interval: 2m\n"), 0644))
+	t.Setenv("CRON_JOBS", "download")
+	t.Setenv("CRON_CLEANUP_SCHEDULE", "@daily")
+	t.Setenv("CRON_DOWNLOAD_MODE", "poll")
+	t.Setenv("CRON_DOWNLOAD_POLL_MAX_INTERVAL", "30m")
+	t.Setenv("CRON_LOCK_BACKEND", "redis")
+	t.Setenv("CRON_LOCK_REDIS_URL", "redis://localhost:6379/1")
+
+	cfg, err = Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "UTC", cfg.Cron.Timezone)
+	assert.Equal(t, []string{"download"}, cfg.Cron.Jobs)
+	assert.Equal(t, DefaultDownloadSchedule, cfg.Cron.Download.Schedule)
+	assert.Equal(t, 4, cfg.Cron.Download.PoolSize)
+	assert.Equal(t, "@daily", cfg.Cron.Cleanup.Schedule)
+	assert.Equal(t, DownloadModePoll, cfg.Cron.Download.Mode)
+	assert.Equal(t, LockConfig{Backend: "redis", Redis: RedisConfig{URL: "redis://localhost:6379/1"}, TTL: DefaultLockTTL}, cfg.Cron.Lock)
+	assert.Equal(t, PollConfig{Interval: 2 * time.Minute, MaxInterval: 30 * time.Minute, MaxCallsPerDay: DefaultPollMaxCallsPerDay}, cfg.Cron.Download.Poll)
+
+	t.Setenv("CRON_DOWNLOAD_POOL_SIZE", "many")
+	_, err = Load(path)
+	assert.Error(t, err)
+}
@@ -1,121 +1,208 @@
 package internal
 
 import (
-	"io/fs"
+	"context"
+	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"regexp"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
 	"github.com/robfig/cron/v3"
 )
 
-var forecastPathRegexp = regexp.MustCompile(`^([^/]+)/(\d{4}/\d{2}/\d{2})/(\d{2})\.webp$`)
+// Matches the .<ext> suffix of a frame along with any size variants and alpha masks
+// (e.g. HH.thumb.webp, HH.medium.mask.png)
+var frameSuffixRegexp = regexp.MustCompile(`^(?:\.[a-z]+)*\.(?:webp|png|jpg|json)$`)
 
-func StartCron(rootDir, apiKey, orderId string) (*cron.Cron, error) {
-	c := cron.New()
+// Jobs that the API server can run on a schedule
+const (
+	DownloadJob = "download"
+	CleanupJob  = "cleanup"
+)
 
-	if err := ScheduleDownloadJob(c, rootDir, apiKey, orderId); err != nil {
-		return nil, err
+// nextFireTimes is how many upcoming runs of each job are logged at startup
+const nextFireTimes = 3
+
+// StartCron validates the cron config and schedules the enabled jobs, logging when
+// each will next run. Only the leader runs the jobs, when there is a lock
+func StartCron(cfg *config.Config, runs *RunManager, leader *Leader) (*cron.Cron, error) {
+	loc := time.Local
+	if cfg.Cron.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Cron.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid cron timezone: %w", err)
+		}
 	}
 
-	if err := ScheduleCleanupJob(c, rootDir); err != nil {
+	jobs, err := enabledJobs(cfg.Cron.Jobs)
+	if err != nil {
 		return nil, err
 	}
 
-	c.Start()
-	return c, nil
-}
-
-func ScheduleDownloadJob(c *cron.Cron, rootDir, apiKey, orderId string) error {
-	poolSize := 1
-	schedule := "30 4,5,6 * * *"
-
-	log.Printf("Starting CRON job to download files (schedule=%s)", schedule)
-	_, err := c.AddFunc(schedule, func() {
-		downloader, err := NewDownloader(rootDir, poolSize, apiKey, orderId)
+	c := cron.New(cron.WithLocation(loc))
+	scheduled := make(map[string]cron.EntryID)
+	var poller *Poller
+	for _, job := range jobs {
+		var id cron.EntryID
+		switch job {
+		case DownloadJob:
+			switch cfg.Cron.Download.Mode {
+			case config.DownloadModePoll:
+				poller, err = NewDownloadPoller(cfg, loc, runs)
+				if err != nil {
+					return nil, fmt.Errorf("failed to start polling: %w", err)
+				}
+				continue
+			case config.DownloadModeSchedule, "":
+			default:
+				return nil, fmt.Errorf("unknown download mode: %s (expected %s or %s)",
+					cfg.Cron.Download.Mode, config.DownloadModeSchedule, config.DownloadModePoll)
+			}
+			id, err = ScheduleDownloadJob(c, cfg, runs, leader)
+		case CleanupJob:
+			id, err = ScheduleCleanupJob(c, cfg, runs, leader)
+		}
 		if err != nil {
-			log.Printf("Failed to create downloader: %v", err)
-			return
+			return nil, fmt.Errorf("failed to schedule %s job: %w", job, err)
 		}
+		scheduled[job] = id
+	}
 
-		downloader.StartWorkers()
-		downloader.DispatchJobs()
-		errors := downloader.Wait()
-		if len(errors) > 0 {
-			log.Printf("Errors occurred: %v", errors)
+	now := time.Now().In(loc)
+	for _, job := range jobs {
+		id, ok := scheduled[job]
+		if !ok {
+			continue
 		}
-	})
+		schedule := c.Entry(id).Schedule
+		next := make([]string, 0, nextFireTimes)
+		for t := schedule.Next(now); !t.IsZero() && len(next) < nextFireTimes; t = schedule.Next(t) {
+			next = append(next, t.Format(time.RFC3339))
+		}
+		if len(next) == 0 {
+			return nil, fmt.Errorf("%s job schedule never fires", job)
+		}
+		log.Printf("Next %s job runs: %s", job, strings.Join(next, ", "))
+	}
 
-	return err
-}
+	if len(jobs) > 0 {
+		if !leader.Campaign(context.Background()) {
+			log.Printf("Another instance holds the cron lock, waiting to take over the scheduled jobs")
+		}
+		go leader.Watch()
+	}
 
-func ScheduleCleanupJob(c *cron.Cron, rootDir string) error {
-	schedule := "0 1 * * *"
-	log.Printf("Starting CRON job to cleanup old overflow forecasts (schedule=%s)", schedule)
-	_, err := c.AddFunc(schedule, func() {
-		cleanupOldOverflowForecasts(rootDir)
-	})
-	return err
+	if id, ok := scheduled[DownloadJob]; ok && cfg.Cron.Download.CatchUp {
+		go watchMissedDownloads(c.Entry(id).Schedule, loc, runs, leader)
+	}
+	if poller != nil {
+		go poller.Watch(leader)
+	}
+
+	c.Start()
+	return c, nil
 }
 
-func cleanupOldOverflowForecasts(rootDir string) {
-	now := time.Now()
-	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -7)
+// enabledJobs checks the names of the jobs to run, defaulting to all of them
+func enabledJobs(names []string) ([]string, error) {
+	if names == nil {
+		return []string{DownloadJob, CleanupJob}, nil
+	}
 
-	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			log.Printf("cleanup: error accessing %q: %v", path, err)
-			return nil
-		}
-		if d.IsDir() {
-			return nil
+	jobs := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch name {
+		case DownloadJob, CleanupJob:
+			if !slices.Contains(jobs, name) {
+				jobs = append(jobs, name)
+			}
+		case "none", "":
+		default:
+			return nil, fmt.Errorf("unknown cron job: %s (expected %s or %s)", name, DownloadJob, CleanupJob)
 		}
+	}
+	return jobs, nil
+}
 
-		rel, err := filepath.Rel(rootDir, path)
-		if err != nil {
-			log.Printf("cleanup: could not get relative path for %q: %v", path, err)
-			return nil
-		}
+func ScheduleDownloadJob(c *cron.Cron, cfg *config.Config, runs *RunManager, leader *Leader) (cron.EntryID, error) {
+	poolSize := cfg.Cron.Download.PoolSize
+	schedule := cfg.Cron.Download.Schedule
+	if poolSize < 1 {
+		return 0, fmt.Errorf("invalid pool size: %d", poolSize)
+	}
+	if _, err := ParseLayout(cfg.Storage.Layout); err != nil {
+		return 0, err
+	}
 
-		// Convert to slash to ensure regex works on all platforms
-		matches := forecastPathRegexp.FindStringSubmatch(filepath.ToSlash(rel))
-		if len(matches) != 4 {
-			return nil
+	log.Printf("Starting CRON job to download files (schedule=%s, poolSize=%d)", schedule, poolSize)
+	return c.AddFunc(schedule, func() {
+		if !leader.IsLeader() {
+			log.Printf("Skipping download job, as another instance holds the cron lock")
+			return
 		}
+		if _, err := runs.Start(TriggerCron, nil); err != nil {
+			log.Printf("Failed to start download run: %v", err)
+			return
+		}
+		runs.Wait()
+	})
+}
 
-		dateStr := matches[2]
-		hourStr := matches[3]
+// NewDownloadPoller checks the settings of poll mode, returning the poller that downloads
+// each new run as soon as it appears in the order
+func NewDownloadPoller(cfg *config.Config, loc *time.Location, runs *RunManager) (*Poller, error) {
+	if cfg.Cron.Download.PoolSize < 1 {
+		return nil, fmt.Errorf("invalid pool size: %d", cfg.Cron.Download.PoolSize)
+	}
+	if _, err := ParseLayout(cfg.Storage.Layout); err != nil {
+		return nil, err
+	}
+	poll := cfg.Cron.Download.Poll
+	poller, err := NewPoller(poll, loc, runs)
+	if err != nil {
+		return nil, err
+	}
 
-		forecastDate, err := time.ParseInLocation("2006/01/02", dateStr, now.Location())
-		if err != nil {
-			log.Printf("cleanup: could not parse date from path %q: %v", path, err)
-			return nil
-		}
+	log.Printf("Polling the order for new runs to download (interval=%s, maxInterval=%s, maxCallsPerDay=%d, poolSize=%d)",
+		poller.cfg.Interval, poller.cfg.MaxInterval, poller.cfg.MaxCallsPerDay, cfg.Cron.Download.PoolSize)
+	return poller, nil
+}
 
-		if !forecastDate.Before(cutoff) {
-			return nil
+func ScheduleCleanupJob(c *cron.Cron, cfg *config.Config, runs *RunManager, leader *Leader) (cron.EntryID, error) {
+	schedule := cfg.Cron.Cleanup.Schedule
+	log.Printf("Starting CRON job to apply the retention policy (schedule=%s)", schedule)
+	return c.AddFunc(schedule, func() {
+		if !leader.IsLeader() {
+			log.Printf("Skipping cleanup job, as another instance holds the cron lock")
+			return
 		}
-
-		hour, err := strconv.Atoi(hourStr)
+		report, err := runs.Cleanup(context.Background(), false)
 		if err != nil {
-			log.Printf("cleanup: could not parse hour from path %q: %v", path, err)
-			return nil
-		}
-
-		if hour >= 24 {
-			log.Printf("Deleting old overflow forecast: %s", path)
-			if err := os.Remove(path); err != nil {
-				log.Printf("Failed to delete %s: %v", path, err)
-			}
+			log.Printf("Cleanup job failed: %v", err)
+			return
 		}
-
-		return nil
+		log.Printf("Cleanup job deleted %d expired frames (%d files, %d bytes), %d bytes remaining",
+			report.Total.Frames, report.Total.Files, report.Total.Bytes, report.RemainingBytes)
 	})
+}
 
+// collectUnreferencedBlobs deletes the content of deduplicated frames once no frame
+// refers to it any more
+func collectUnreferencedBlobs(ctx context.Context, frames store.FrameStore) {
+	dedup, ok := frames.(*store.DedupStore)
+	if !ok {
+		return
+	}
+	report, err := dedup.CollectGarbage(ctx, false)
 	if err != nil {
-		log.Printf("Cleanup job failed to walk directory: %v", err)
+		log.Printf("Cleanup job failed to collect unreferenced blobs: %v", err)
+		return
 	}
+	log.Printf("Collected %d unreferenced blobs (%d bytes); %d frames share %d blobs, saving %d bytes",
+		report.Collected, report.CollectedBytes, report.References, report.Blobs-report.Unreferenced, report.SavedBytes())
 }
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCronConfig() *config.Config {
+	return &config.Config{
+		Cron: config.CronConfig{
+			Timezone: "Europe/London",
+			Download: config.DownloadJobConfig{JobConfig: config.JobConfig{Schedule: "@hourly"}, PoolSize: 2},
+			Cleanup:  config.JobConfig{Schedule: "0 1 * * *"},
+		},
+	}
+}
+
+func TestStartCron(t *testing.T) {
+	frames := store.NewFileStore(t.TempDir())
+
+	cfg := testCronConfig()
+	c, err := StartCron(cfg, NewRunManager(cfg, frames, NewDataHubClient("api-key"), "order-id"), nil)
+	require.NoError(t, err)
+	defer c.Stop()
+	assert.Len(t, c.Entries(), 2)
+	assert.Equal(t, "Europe/London", c.Location().String())
+
+	cfg = testCronConfig()
+	cfg.Cron.Jobs = []string{"cleanup", "cleanup"}
+	c, err = StartCron(cfg, NewRunManager(cfg, frames, NewDataHubClient("api-key"), "order-id"), nil)
+	require.NoError(t, err)
+	defer c.Stop()
+	assert.Len(t, c.Entries(), 1)
+
+	cfg.Cron.Jobs = []string{"none"}
+	cfg.Cron.Download.Schedule = "invalid"
+	c, err = StartCron(cfg, NewRunManager(cfg, frames, NewDataHubClient("api-key"), "order-id"), nil)
+	require.NoError(t, err)
+	defer c.Stop()
+	assert.Empty(t, c.Entries())
+}
+
+func TestStartCron_Invalid(t *testing.T) {
+	frames := store.NewFileStore(t.TempDir())
+	tests := map[string]func(cfg *config.CronConfig){
+		"timezone":      func(cfg *config.CronConfig) { cfg.Timezone = "Mars/Olympus_Mons" },
+		"job":           func(cfg *config.CronConfig) { cfg.Jobs = []string{"download", "backup"} },
+		"schedule":      func(cfg *config.CronConfig) { cfg.Cleanup.Schedule = "0 25 * * *" },
+		"never fires":   func(cfg *config.CronConfig) { cfg.Cleanup.Schedule = "0 0 30 2 *" },
+		"pool size":     func(cfg *config.CronConfig) { cfg.Download.PoolSize = 0 },
+		"seconds field": func(cfg *config.CronConfig) { cfg.Download.Schedule = "0 30 4 * * *" },
+		"download mode": func(cfg *config.CronConfig) { cfg.Download.Mode = "webhook" },
+		"poll interval": func(cfg *config.CronConfig) { cfg.Download.Mode = config.DownloadModePoll },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testCronConfig()
+			modify(&cfg.Cron)
+			_, err := StartCron(cfg, NewRunManager(cfg, frames, NewDataHubClient("api-key"), "order-id"), nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 
 	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
 )
@@ -82,6 +83,23 @@ func (mgr *DataHubManager) get(url string, acceptHeader string) (io.ReadCloser,
 	return resp.Body, nil
 }
 
+// countingClient counts the calls made to the DataHub API through a client, whether
+// or not they succeed
+type countingClient struct {
+	DataHubClient
+	calls atomic.Int64
+}
+
+func (c *countingClient) GetLatest(orderId string, params QueryParams) (*metoffice.Response, error) {
+	c.calls.Add(1)
+	return c.DataHubClient.GetLatest(orderId, params)
+}
+
+func (c *countingClient) GetLatestDataFile(orderId, fileId string, params QueryParams) (io.ReadCloser, error) {
+	c.calls.Add(1)
+	return c.DataHubClient.GetLatestDataFile(orderId, fileId, params)
+}
+
 type QueryParams map[string]string
 
 func NewQueryParams(keypairs ...string) QueryParams {
@@ -1,44 +1,66 @@
 package internal
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"image/color"
+	"io"
 	"log"
 	"net/url"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
 	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
-	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing/stage"
 	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
 )
 
 type Processor struct {
-	startTime   time.Time
-	endTime     time.Time
-	rootDir     string
-	poolSize    int
-	maxJobs     int
-	jobs        chan metoffice.File
-	results     chan error
-	client      DataHubClient
-	files       []metoffice.File
-	orderId     string
-	fileIdRegex *regexp.Regexp
-	pipelines   map[string][]imageprocessing.PipelineStage
-}
-
-func NewDownloader(rootDir string, poolSize int, apiKey, orderId string) (*Processor, error) {
+	startTime time.Time
+	endTime   time.Time
+	ctx       context.Context
+	frames    store.FrameStore
+	poolSize  int
+	jobs      chan metoffice.File
+	results   chan FileResult
+	client    DataHubClient
+	files     []metoffice.File
+	orderId   string
+	overlays  map[string]Overlay
+	variants  []imageprocessing.Variant
+	traceDir  string
+	wind      *windCollector
+	fallback  *Overlay
+	summary   RunSummary
+	dated     map[metoffice.FileID]bool
+	layout    Layout
+	selected  map[string]bool
+	filter    RunFilter
+	limited   map[string]bool
+	planned   map[string]plannedFile
+	onResult  func(FileResult)
+	onFrame   func(FrameEvent)
+
+	progressMu sync.Mutex
+	progress   Progress
+	onProgress func(Progress)
+	bytesRead  atomic.Int64
+}
+
+func NewDownloader(frames store.FrameStore, poolSize int, apiKey, orderId string) (*Processor, error) {
+	return newDownloader(frames, poolSize, NewDataHubClient(apiKey), orderId)
+}
+
+func newDownloader(frames store.FrameStore, poolSize int, client DataHubClient, orderId string) (*Processor, error) {
 	if poolSize < 1 {
 		return nil, errors.New("pool size must be at least 1")
 	}
 	startTime := time.Now()
 	orderId = url.QueryEscape(orderId)
-	client := NewDataHubClient(apiKey)
 	resp, err := client.GetLatest(orderId, NewQueryParams("dataSpec", "1.1.0"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve order %s: %w", orderId, err)
@@ -49,52 +71,219 @@ func NewDownloader(rootDir string, poolSize int, apiKey, orderId string) (*Proce
 		return nil, errors.New("no files to download")
 	}
 
-	return &Processor{
-		startTime:   startTime,
-		rootDir:     rootDir,
-		poolSize:    poolSize,
-		maxJobs:     -1,
-		jobs:        make(chan metoffice.File),
-		results:     make(chan error),
-		client:      client,
-		files:       resp.OrderDetails.Files,
-		orderId:     orderId,
-		fileIdRegex: regexp.MustCompile(`(.*?)_ts(\d{1,2})_(\d{4})(\d{2})(\d{2})00`),
-		pipelines: map[string][]imageprocessing.PipelineStage{
-			"total_precipitation_rate": {
-				&stage.ReplaceColorStage{Tolerance: 50, Replace: color.White},
-				&stage.GaussianBlurStage{Sigma: 1.0},
-				&stage.ResampleStage{},
-			},
-			"cloud_amount_total": {
-				&stage.ReplaceColorStage{Tolerance: 50, Replace: color.White},
-				&stage.GreyscaleStage{},
-				&stage.GaussianBlurStage{Sigma: 1.0},
-				&stage.ResampleStage{},
-			},
-			// NoOp's
-			"mean_sea_level_pressure": {},
-			"temperature_at_surface":  {},
-		},
-	}, nil
-}
-
-// dispatchJobs sends files to the jobs channel for processing by workers.
-// When maxJobs is greater than zero, it limits the number of jobs dispatched,
-// hence set to -1 to dispatch all jobs.
-func (p *Processor) DispatchJobs() {
+	p := &Processor{
+		startTime: startTime,
+		ctx:       context.Background(),
+		frames:    frames,
+		poolSize:  poolSize,
+		jobs:      make(chan metoffice.File),
+		results:   make(chan FileResult),
+		client:    client,
+		files:     resp.OrderDetails.Files,
+		orderId:   orderId,
+		variants:  imageprocessing.DefaultVariants,
+		wind:      newWindCollector(),
+		fallback:  &Overlay{Encoder: defaultEncoder},
+		layout:    RunLayout,
+	}
+	p.progress.Total = len(p.files)
+	p.SetBasemapDir(config.DefaultBasemapDir)
+	p.discover()
+	return p, nil
+}
+
+// discover lists the kinds of data present in the order, and the fileIds that do not
+// follow the expected naming scheme. Frames that appear under both their dated and
+// relative fileIds are noted so that only the dated one is processed
+func (p *Processor) discover() {
+	p.dated = make(map[metoffice.FileID]bool)
+	p.summary = RunSummary{
+		StartTime: p.startTime,
+		Kinds:     make(map[string]int),
+		Unmatched: make([]string, 0),
+		Skipped:   make([]FileResult, 0),
+		Failed:    make([]FileResult, 0),
+	}
+	for _, file := range p.files {
+		id, err := file.ID()
+		if err != nil {
+			p.summary.Unmatched = append(p.summary.Unmatched, file.FileId)
+			continue
+		}
+		p.summary.Kinds[id.Kind]++
+		if !id.Relative {
+			p.dated[id] = true
+		}
+	}
+
+	for _, kind := range p.summary.sortedKinds() {
+		note := ""
+		if _, ok := p.overlays[kind]; !ok {
+			note = " (no overlay defined)"
+		}
+		log.Printf("Order contains %d files of kind %s%s", p.summary.Kinds[kind], kind, note)
+	}
+	if len(p.summary.Unmatched) > 0 {
+		log.Printf("Order contains %d files with unrecognised fileIds", len(p.summary.Unmatched))
+	}
+	if !p.wind.enabled && (p.summary.Kinds[windSpeedKind] > 0 || p.summary.Kinds[windDirectionKind] > 0) {
+		log.Printf("Skipping wind arrows, barbs and vectors: set legends for %s and %s under download.legends", windSpeedKind, windDirectionKind)
+	}
+}
+
+// SetDefaultOverlay chooses the overlay definition applied to kinds found in the order
+// that have none of their own. An empty name publishes them unprocessed with the
+// default encoder, while "none" skips them
+func (p *Processor) SetDefaultOverlay(name string) error {
+	switch name {
+	case "":
+		p.fallback = &Overlay{Encoder: defaultEncoder}
+	case "none":
+		p.fallback = nil
+	default:
+		overlay, ok := p.overlays[name]
+		if !ok || overlay.Derived {
+			return fmt.Errorf("unknown default overlay: %s", name)
+		}
+		// Only the processing is reused, the style and composite are specific to the kind
+		p.fallback = &Overlay{Pipeline: overlay.Pipeline, Encoder: overlay.Encoder}
+	}
+	return nil
+}
+
+// SetBasemapDir chooses the directory holding the basemap that composites are drawn
+// with. Without a basemap there, overlays have no composite
+func (p *Processor) SetBasemapDir(dir string) {
+	overlays := make(map[string]Overlay, len(Overlays))
+	for kind, overlay := range Overlays {
+		if overlay.Composite != nil {
+			overlay.Composite = overlay.Composite.In(dir)
+		}
+		overlays[kind] = overlay
+	}
+	p.overlays = overlays
+}
+
+// Kinds returns the number of files of each kind present in the order
+func (p *Processor) Kinds() map[string]int {
+	return p.summary.Kinds
+}
+
+// Summary returns the outcome of the run, which is complete once Wait has returned
+func (p *Processor) Summary() RunSummary {
+	return p.summary
+}
+
+// SetContext sets the context that the run is cancelled with. Files still to be
+// processed when it is done are skipped, while those in progress are completed
+func (p *Processor) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+// SetOverlayFilter restricts the run to files of the given kinds, which must be
+// present in the order. An empty filter processes every kind
+func (p *Processor) SetOverlayFilter(kinds []string) error {
+	if len(kinds) == 0 {
+		p.selected = nil
+		return nil
+	}
+	selected := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		if _, ok := p.summary.Kinds[kind]; !ok {
+			return fmt.Errorf("order contains no files of kind %s", kind)
+		}
+		selected[kind] = true
+	}
+	p.selected = selected
+	return nil
+}
 
+// SetFilter restricts the run to the files matching the filter, skipping the others
+func (p *Processor) SetFilter(filter RunFilter) error {
+	if filter.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if !filter.ValidFrom.IsZero() && !filter.ValidUntil.IsZero() && filter.ValidUntil.Before(filter.ValidFrom) {
+		return errors.New("valid time window ends before it starts")
+	}
+	if err := p.SetOverlayFilter(filter.Overlays); err != nil {
+		return err
+	}
+	p.filter = filter
+	return nil
+}
+
+// OnResult registers a function called with the outcome of each file as Wait receives
+// it
+func (p *Processor) OnResult(fn func(FileResult)) {
+	p.onResult = fn
+}
+
+// OnFrame registers a function called, from the workers, for every frame once all of
+// its files have been written
+func (p *Processor) OnFrame(fn func(FrameEvent)) {
+	p.onFrame = fn
+}
+
+// OnProgress registers a function called with the progress of the run as Wait
+// receives the outcome of each file
+func (p *Processor) OnProgress(fn func(Progress)) {
+	p.onProgress = fn
+}
+
+// Progress returns how far the run has got, as of now
+func (p *Processor) Progress() Progress {
+	p.progressMu.Lock()
+	defer p.progressMu.Unlock()
+	return p.progress.at(p.startTime, time.Now(), p.bytesRead.Load())
+}
+
+// SetLayout chooses where frames are written below the root directory
+func (p *Processor) SetLayout(layout Layout) {
+	p.layout = layout
+}
+
+// SetTraceDir enables pipeline tracing: the output of every stage for each processed
+// file is written below dir, along with an HTML contact sheet
+func (p *Processor) SetTraceDir(dir string) {
+	p.traceDir = dir
+}
+
+// DispatchJobs sends files to the jobs channel for processing by workers
+func (p *Processor) DispatchJobs() {
+	p.applyLimit()
 	go func() {
-		for n, file := range p.files {
-			if p.maxJobs > 0 && n >= p.maxJobs {
-				break
-			}
+		for _, file := range p.files {
 			p.jobs <- file
 		}
 		close(p.jobs)
 	}()
 }
 
+// applyLimit notes the files beyond the filter's limit, counting only those in the
+// order that would otherwise be downloaded, so not those whose frames already exist.
+// The plans it makes are kept for processFile, so the store is only checked once
+func (p *Processor) applyLimit() {
+	p.limited = nil
+	p.planned = nil
+	if p.filter.Limit <= 0 {
+		return
+	}
+	p.limited = make(map[string]bool)
+	p.planned = make(map[string]plannedFile)
+	n := 0
+	for _, file := range p.files {
+		plan, err := p.planFile(file)
+		if err == nil {
+			if n++; n > p.filter.Limit {
+				p.limited[file.FileId] = true
+				continue
+			}
+		}
+		p.planned[file.FileId] = plannedFile{plan: plan, err: err}
+	}
+}
+
 func (p *Processor) StartWorkers() {
 	log.Printf("Starting downloading files with pool size: %d", p.poolSize)
 
@@ -106,40 +295,137 @@ func (p *Processor) StartWorkers() {
 func (p *Processor) worker(i int) {
 	log.Printf("Worker %d started", i)
 	for file := range p.jobs {
-		p.results <- p.processFile(file)
+		id, _ := file.ID()
+		var downloaded int64
+		result := newFileResult(file, id.Kind, p.processFile(file, &downloaded))
+		result.Bytes = downloaded
+		p.results <- result
 	}
 	log.Printf("Worker %d finished", i)
 }
 
-func (p *Processor) processFile(file metoffice.File) error {
-	matches := p.fileIdRegex.FindStringSubmatch(file.FileId)
-	if matches == nil {
-		return nil
-	}
+// frameOutput is a directory that a processed frame is written to, along with any
+// extra stages applied on top of the overlay pipeline and the variants still missing
+type frameOutput struct {
+	id      metoffice.FileID
+	dir     string
+	stages  []imageprocessing.PipelineStage
+	encoder imageprocessing.Encoder
+	pending []imageprocessing.Variant
+}
 
-	path, err := p.createPath(matches)
+// filePlan is how a file is processed, as decided before any image data is fetched
+type filePlan struct {
+	id      metoffice.FileID
+	overlay Overlay
+	hour    int
+	outputs []*frameOutput
+}
+
+// plannedFile is the outcome of planning a file ahead of processing it
+type plannedFile struct {
+	plan *filePlan
+	err  error
+}
+
+// selectFile returns the file's id and overlay, or a skip error when the file is not
+// to be processed at all
+func (p *Processor) selectFile(file metoffice.File) (metoffice.FileID, Overlay, error) {
+	id, err := file.ID()
 	if err != nil {
-		return fmt.Errorf("failed to create path: %w", err)
+		return id, Overlay{}, skipped(err.Error())
+	}
+	if p.selected != nil && !p.selected[id.Kind] {
+		return id, Overlay{}, skipped("not selected")
+	}
+	if err := p.filter.match(id); err != nil {
+		return id, Overlay{}, err
+	}
+	if id.Relative {
+		dated := id
+		dated.Relative = false
+		if p.dated[dated] {
+			return id, Overlay{}, skipped(fmt.Sprintf("duplicate of %s", dated))
+		}
+	}
+
+	overlay, ok := p.overlayFor(id.Kind)
+	if !ok {
+		return id, Overlay{}, skipped(fmt.Sprintf("no overlay defined for data type %s", id.Kind))
 	}
+	return id, overlay, nil
+}
 
-	hour, err := strconv.Atoi(matches[2])
+// planFile decides how a file is processed, returning a skip error when it does not
+// need downloading
+func (p *Processor) planFile(file metoffice.File) (*filePlan, error) {
+	id, overlay, err := p.selectFile(file)
 	if err != nil {
-		return fmt.Errorf("failed to convert %s to integer: %w", matches[2], err)
+		return nil, err
+	}
+	if p.limited[file.FileId] {
+		return nil, skipped(fmt.Sprintf("beyond the limit of %d files", p.filter.Limit))
 	}
 
-	kind := matches[1]
-	filename := fmt.Sprintf("%s/%02d.webp", path, hour)
+	kind := id.Kind
 
-	// if the file already exists, skip processing
-	if _, err := os.Stat(filename); err == nil {
-		return nil
-	} else if !os.IsNotExist(err) {
+	path, hour := p.frameDir(id)
+	enc := p.encoderFor(kind)
+
+	outputs := []*frameOutput{{id: id, dir: path, encoder: enc}}
+	if overlay.Composite != nil {
+		compositeId := id
+		compositeId.Kind += compositeSuffix
+		compositePath, _ := p.frameDir(compositeId)
+		outputs = append(outputs, &frameOutput{
+			id:      compositeId,
+			dir:     compositePath,
+			stages:  []imageprocessing.PipelineStage{overlay.Composite},
+			encoder: compositeEncoder,
+		})
+	}
+	plan := &filePlan{id: id, overlay: overlay, hour: hour, outputs: outputs}
+
+	// if every size variant of every output already exists, skip processing
+	complete := true
+	for _, out := range outputs {
+		if out.pending, err = p.pendingVariants(out.dir, hour, id.RunTime(), out.encoder); err != nil {
+			return plan, err
+		}
+		complete = complete && len(out.pending) == 0
+	}
+	if complete && isWindComponent(kind) && p.wind.enabled {
+		pending, err := p.windProductsPending(id)
+		if err != nil {
+			return plan, err
+		}
+		complete = !pending
+	}
+	if complete {
+		return plan, skipped("already exists")
+	}
+	return plan, nil
+}
+
+// processFile downloads and processes a file, counting the bytes downloaded
+func (p *Processor) processFile(file metoffice.File, downloaded *int64) error {
+	if p.ctx.Err() != nil {
+		return skipped("cancelled")
+	}
+	planned, ok := p.planned[file.FileId]
+	if !ok {
+		planned.plan, planned.err = p.planFile(file)
+	}
+	plan, err := planned.plan, planned.err
+	if err != nil {
 		return err
 	}
+	id, overlay, hour, outputs := plan.id, plan.overlay, plan.hour, plan.outputs
+	kind := id.Kind
 
 	params := NewQueryParams("dataSpec", "1.1.0")
-	if kind == "cloud_amount_total" {
-		params.Add("styleName", "iso_fill_bu_gn_30_100_pc")
+	if overlay.StyleName != "" {
+		params.Add("styleName", overlay.StyleName)
 	}
 
 	inFile, err := p.client.GetLatestDataFile(p.orderId, file.FileId, params)
@@ -150,77 +436,233 @@ func (p *Processor) processFile(file metoffice.File) error {
 		_ = inFile.Close()
 	}()
 
-	tmpFile, err := os.CreateTemp(path, "download-*.tmp")
+	img, err := imageprocessing.NewImageFromReader(&countingReader{r: inFile, n: downloaded, total: &p.bytesRead})
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+		return fmt.Errorf("failed to decode PNG from data file: %w", err)
 	}
-	cleanupTemp := true
-	defer func() {
-		_ = tmpFile.Close()
-		if cleanupTemp {
-			_ = os.Remove(tmpFile.Name())
-		}
-	}()
+	img.Kind = kind
+	img.ValidTime = id.ValidTime()
 
-	pipeline := p.pipelines[kind]
-	if pipeline == nil {
-		return fmt.Errorf("no processing pipeline defined for data type %s", kind)
+	// Wind vectors are sampled from the raw image, before any processing
+	if isWindComponent(kind) && p.wind.enabled {
+		key := windKey{runTime: id.RunTime(), timestep: id.Timestep}
+		field, err := p.wind.add(kind, key, img.Img)
+		if err != nil {
+			return fmt.Errorf("failed to decode wind data: %w", err)
+		}
+		if field != nil {
+			if err := p.writeWindProducts(field, id); err != nil {
+				return fmt.Errorf("failed to write wind products: %w", err)
+			}
+		}
 	}
 
-	img, err := imageprocessing.NewImageFromReader(inFile)
-	if err != nil {
-		return fmt.Errorf("failed to decode PNG from data file: %w", err)
+	if p.traceDir != "" {
+		name := id.String()
+		if img.Trace, err = imageprocessing.NewTrace(p.traceDir, name); err != nil {
+			return err
+		}
+		if err := img.Trace.Record("Input", 0, img.Img); err != nil {
+			return err
+		}
+		defer func() {
+			if err := img.Trace.WriteContactSheet(); err != nil {
+				log.Printf("Failed to write trace contact sheet for %s: %v", name, err)
+			}
+		}()
 	}
 
-	if err := img.Pipeline(pipeline...); err != nil {
+	if err := img.Pipeline(overlay.Pipeline...); err != nil {
 		return fmt.Errorf("failed to process image pipeline: %w", err)
 	}
 
-	if err := img.Write(tmpFile); err != nil {
-		return fmt.Errorf("failed to write processed image to temporary file: %w", err)
+	for _, out := range outputs {
+		if len(out.pending) == 0 {
+			continue
+		}
+
+		frame := img
+		if len(out.stages) > 0 {
+			frame = &imageprocessing.ProcessedImage{Img: img.Img, Kind: img.Kind, ValidTime: img.ValidTime, Trace: img.Trace}
+			if err := frame.Pipeline(out.stages...); err != nil {
+				return fmt.Errorf("failed to process %s output pipeline: %w", out.dir, err)
+			}
+		}
+
+		if err := p.writeVariants(frame, out.dir, hour, out.pending, out.encoder); err != nil {
+			return err
+		}
+		if err := p.writeMetadata(out.dir, hour, out.id); err != nil {
+			return fmt.Errorf("failed to write frame metadata: %w", err)
+		}
+		p.published(out.id, VariantFilename(out.dir, hour, imageprocessing.Variant{Name: imageprocessing.FullVariant}, out.encoder.Extension()))
+	}
+
+	return nil
+}
+
+// published reports a frame that has been written in full
+func (p *Processor) published(id metoffice.FileID, path string) {
+	if p.onFrame != nil {
+		p.onFrame(newFrameEvent(id, path))
+	}
+}
+
+// overlayFor returns the overlay definition for the kind, falling back to the default
+// overlay for kinds without one
+func (p *Processor) overlayFor(kind string) (Overlay, bool) {
+	if overlay, ok := p.overlays[kind]; ok {
+		return overlay, true
+	}
+	if p.fallback != nil {
+		return *p.fallback, true
 	}
+	return Overlay{}, false
+}
 
-	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary file before rename: %w", err)
+func (p *Processor) encoderFor(kind string) imageprocessing.Encoder {
+	if overlay, ok := p.overlayFor(kind); ok && overlay.Encoder != nil {
+		return overlay.Encoder
 	}
+	return defaultEncoder
+}
 
-	if err := os.Rename(tmpFile.Name(), filename); err != nil {
-		return fmt.Errorf("failed to rename temporary file: %w", err)
+// pendingVariants lists the variants of a frame that still need to be written. With
+// the valid time layout, a frame from an older run is replaced in full, while one from
+// a newer run is left alone
+func (p *Processor) pendingVariants(dir string, hour int, runTime time.Time, enc imageprocessing.Encoder) ([]imageprocessing.Variant, error) {
+	switch cmp, err := p.layout.compareStoredRun(p.ctx, p.frames, dir, hour, runTime); {
+	case err != nil:
+		return nil, err
+	case cmp < 0:
+		return p.variants, nil
+	case cmp > 0:
+		return nil, nil
+	case p.filter.Force:
+		return p.variants, nil
 	}
 
-	cleanupTemp = false // Successfully renamed, don't delete
+	pending := make([]imageprocessing.Variant, 0, len(p.variants))
+	for _, v := range p.variants {
+		if _, err := p.frames.Stat(p.ctx, VariantFilename(dir, hour, v, enc.Extension())); err == nil {
+			continue
+		} else if !errors.Is(err, store.ErrNotExist) {
+			return nil, err
+		}
+		pending = append(pending, v)
+	}
+	return pending, nil
+}
+
+func (p *Processor) writeVariants(img *imageprocessing.ProcessedImage, dir string, hour int, variants []imageprocessing.Variant, enc imageprocessing.Encoder) error {
+	for _, v := range variants {
+		resized := img.Resize(v)
+		filename := VariantFilename(dir, hour, v, enc.Extension())
+		err := p.put(filename, enc.ContentType(), func(w io.Writer) error {
+			return resized.Write(w, enc)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s variant: %w", v.Name, err)
+		}
+
+		if maskEnc, ok := enc.(imageprocessing.MaskEncoder); ok {
+			filename := VariantFilename(dir, hour, v, maskEnc.MaskExtension())
+			err := p.put(filename, "image/png", func(w io.Writer) error {
+				return maskEnc.EncodeMask(w, resized.Img)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write %s variant mask: %w", v.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
-func (p *Processor) Wait() []error {
-	waitFor := p.maxJobs
-	if waitFor < 0 {
-		waitFor = len(p.files)
+// put encodes a file in memory and stores it under the key once complete, so readers
+// never observe a partially written image
+func (p *Processor) put(key, contentType string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
 	}
+	return p.frames.Put(p.ctx, key, &buf, int64(buf.Len()), store.PutOptions{ContentType: contentType})
+}
+
+// writeMetadata records the run and lead time of a frame in the valid time layout,
+// once all of its files have been written
+func (p *Processor) writeMetadata(dir string, hour int, id metoffice.FileID) error {
+	if p.layout != ValidTimeLayout {
+		return nil
+	}
+	return writeFrameMetadata(p.ctx, p.frames, dir, hour, newFrameMetadata(id))
+}
+
+// VariantFilename returns the store key of a frame for the given size variant and
+// file extension. The full variant keeps the plain HH<ext> name; others are stored as
+// HH.<name><ext>
+func VariantFilename(dir string, hour int, v imageprocessing.Variant, ext string) string {
+	if v.IsFull() {
+		return fmt.Sprintf("%s/%02d%s", dir, hour, ext)
+	}
+	return fmt.Sprintf("%s/%02d.%s%s", dir, hour, v.Name, ext)
+}
+
+func (p *Processor) Wait() []error {
+	waitFor := len(p.files)
 	log.Printf("Waiting for %d files to be downloaded and processed", waitFor)
 
 	errors := make([]error, 0, 10)
 	for range waitFor {
-		err := <-p.results
-		if err != nil {
-			errors = append(errors, err)
+		result := <-p.results
+		p.summary.add(result)
+		if p.onResult != nil {
+			p.onResult(result)
+		}
+		p.progressMu.Lock()
+		p.progress.add(result)
+		p.progressMu.Unlock()
+		if p.onProgress != nil {
+			p.onProgress(p.Progress())
 		}
+		if result.Status == StatusFailed {
+			errors = append(errors, result.Err())
+		}
+	}
+	if unpaired := p.wind.unpaired(); len(unpaired) > 0 {
+		log.Printf("No wind products for %d timesteps missing a component: %s", len(unpaired), strings.Join(unpaired, ", "))
 	}
 	p.endTime = time.Now()
+	p.summary.EndTime = p.endTime
 	elapsed := p.endTime.Sub(p.startTime)
-	log.Printf("All files downloaded and processed in %s (errors=%d)", elapsed, len(errors))
+	log.Printf("All files downloaded and processed in %s (succeeded=%d, skipped=%d, errors=%d, unmatched=%d, bytes=%d)",
+		elapsed, p.summary.Succeeded, len(p.summary.Skipped), len(errors), len(p.summary.Unmatched), p.summary.Bytes)
+
+	if p.traceDir != "" {
+		if err := imageprocessing.WriteTraceIndex(p.traceDir); err != nil {
+			log.Printf("Failed to write trace index: %v", err)
+		} else {
+			log.Printf("Pipeline traces written to %s", p.traceDir)
+		}
+	}
 	return errors
 }
 
-func (p *Processor) createPath(matches []string) (string, error) {
-	path := filepath.Join(p.rootDir,
-		matches[1], // type
-		matches[3], // year
-		matches[4], // month
-		matches[5], // day
-	)
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return "", err
-	}
-	return path, nil
+// frameDir returns the key prefix of the frame's directory in the configured layout,
+// along with the hour its files are named after
+func (p *Processor) frameDir(id metoffice.FileID) (string, int) {
+	return p.layout.Dir(id)
+}
+
+// countingReader counts the bytes read through it, both for the file and in total
+type countingReader struct {
+	r     io.Reader
+	n     *int64
+	total *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	c.total.Add(int64(n))
+	return n, err
 }
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+)
+
+// Catalog event types
+const (
+	// EventFrame is published for every frame written by a download run
+	EventFrame = "frame"
+	// EventRun is published when a download run finishes
+	EventRun = "run"
+	// EventCleanup is published when the retention policy deletes frames
+	EventCleanup = "cleanup"
+)
+
+const (
+	// maxReplayedEvents is how many recent events are kept for clients that reconnect
+	maxReplayedEvents = 256
+	// subscriberBuffer is how many events a subscriber can fall behind by before it is
+	// disconnected, to catch up by reconnecting
+	subscriberBuffer = 64
+	// relayTimeout is how long publishing an event through the relay may take
+	relayTimeout = 5 * time.Second
+	// relayBuffer is how many events can wait to be published through the relay before
+	// more are dropped, so publishers never wait on it
+	relayBuffer = 256
+)
+
+// CatalogEvent is a change to the frames available. IDs increase with every event, so
+// a client can resume where it left off
+type CatalogEvent struct {
+	ID   uint64
+	Type string
+	Data any
+}
+
+// FrameEvent describes a frame that has just been written
+type FrameEvent struct {
+	RunID     string    `json:"runId"`
+	Kind      string    `json:"kind"`
+	Path      string    `json:"path"`
+	ValidTime time.Time `json:"validTime"`
+	RunTime   time.Time `json:"runTime"`
+	Timestep  int       `json:"timestep"`
+}
+
+func newFrameEvent(id metoffice.FileID, path string) FrameEvent {
+	return FrameEvent{
+		Kind:      id.Kind,
+		Path:      path,
+		ValidTime: id.ValidTime(),
+		RunTime:   id.RunTime(),
+		Timestep:  id.Timestep,
+	}
+}
+
+// RunEvent describes a finished download run, without the outcome of every file
+type RunEvent struct {
+	ID          string     `json:"id"`
+	Trigger     string     `json:"trigger"`
+	Status      RunStatus  `json:"status"`
+	RunDateTime *time.Time `json:"runDateTime,omitempty"`
+	StartTime   time.Time  `json:"startTime"`
+	EndTime     *time.Time `json:"endTime,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Succeeded   int        `json:"succeeded"`
+	Skipped     int        `json:"skipped"`
+	Failed      int        `json:"failed"`
+	Bytes       int64      `json:"bytes"`
+}
+
+func newRunEvent(run Run) RunEvent {
+	event := RunEvent{
+		ID:          run.ID,
+		Trigger:     run.Trigger,
+		Status:      run.Status,
+		RunDateTime: run.RunDateTime,
+		StartTime:   run.StartTime,
+		EndTime:     run.EndTime,
+		Error:       run.Error,
+	}
+	if run.Summary != nil {
+		event.Succeeded = run.Summary.Succeeded
+		event.Skipped = len(run.Summary.Skipped)
+		event.Failed = len(run.Summary.Failed)
+		event.Bytes = run.Summary.Bytes
+	}
+	return event
+}
+
+// EventRelay shares catalog events between the replicas of the server, so that the
+// events stream of each carries the events published by any of them
+type EventRelay interface {
+	// Publish sends an event to every replica, including this one
+	Publish(ctx context.Context, eventType string, data any) error
+	// Subscribe returns the events published by every replica, with ids shared by
+	// all of them
+	Subscribe(ctx context.Context) (<-chan CatalogEvent, error)
+}
+
+// EventHub fans out catalog events to subscribers, such as clients of the events
+// stream, keeping the most recent ones for those that reconnect
+type EventHub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	recent      []CatalogEvent
+	subscribers map[chan CatalogEvent]struct{}
+	relay       EventRelay
+	outbox      chan CatalogEvent
+	leader      *Leader
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan CatalogEvent]struct{})}
+}
+
+// SetRelay publishes events through the relay, to the subscribers of every replica,
+// rather than only to those of this one
+func (h *EventHub) SetRelay(relay EventRelay) error {
+	events, err := relay.Subscribe(context.Background())
+	if err != nil {
+		return err
+	}
+	h.relay = relay
+	h.outbox = make(chan CatalogEvent, relayBuffer)
+	go func() {
+		for event := range events {
+			h.receive(event)
+		}
+	}()
+	go h.forward()
+	return nil
+}
+
+// forward publishes the queued events through the relay, one at a time so they keep
+// their order
+func (h *EventHub) forward() {
+	for event := range h.outbox {
+		ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+		if err := h.relay.Publish(ctx, event.Type, event.Data); err != nil {
+			log.Printf("Failed to relay %s event: %v", event.Type, err)
+		}
+		cancel()
+	}
+}
+
+// SetLeader only streams events while the instance holds the cron lock, unless there is
+// a relay, as without one only the events of this instance's runs and cleanups are
+// streamed
+func (h *EventHub) SetLeader(leader *Leader) {
+	h.leader = leader
+}
+
+// Streaming reports whether the events of every run and cleanup are streamed from
+// this instance
+func (h *EventHub) Streaming() bool {
+	return h.relay != nil || h.leader.IsLeader()
+}
+
+// Publish sends the event to every subscriber, of every replica when there is a relay.
+// A subscriber too far behind to take it is disconnected. Events for the relay are
+// queued rather than waited on, and dropped when too many are queued
+func (h *EventHub) Publish(eventType string, data any) {
+	if h == nil {
+		return
+	}
+	if h.relay != nil {
+		select {
+		case h.outbox <- CatalogEvent{Type: eventType, Data: data}:
+		default:
+			log.Printf("Dropped %s event, too many are waiting to be relayed", eventType)
+		}
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deliver(CatalogEvent{ID: h.lastID + 1, Type: eventType, Data: data})
+}
+
+// receive delivers an event from the relay
+func (h *EventHub) receive(event CatalogEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deliver(event)
+}
+
+// deliver sends the event to every subscriber, keeping it for those that reconnect.
+// It must be called with h.mu held
+func (h *EventHub) deliver(event CatalogEvent) {
+	h.lastID = max(h.lastID, event.ID)
+	h.recent = append(h.recent, event)
+	if len(h.recent) > maxReplayedEvents {
+		h.recent = h.recent[len(h.recent)-maxReplayedEvents:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the events published after lastID that are still kept, and a
+// channel of those published from now on, which is closed should the subscriber fall
+// too far behind. The returned function unsubscribes
+func (h *EventHub) Subscribe(lastID uint64) ([]CatalogEvent, <-chan CatalogEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	missed := make([]CatalogEvent, 0)
+	if lastID > 0 {
+		for _, event := range h.recent {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	ch := make(chan CatalogEvent, subscriberBuffer)
+	h.subscribers[ch] = struct{}{}
+	return missed, ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
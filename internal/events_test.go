@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	hub.Publish(EventRun, "before")
+
+	missed, ch, unsubscribe := hub.Subscribe(0)
+	assert.Empty(t, missed, "a new client is not sent past events")
+
+	hub.Publish(EventFrame, "a")
+	hub.Publish(EventFrame, "b")
+	assert.Equal(t, CatalogEvent{ID: 2, Type: EventFrame, Data: "a"}, <-ch)
+	assert.Equal(t, CatalogEvent{ID: 3, Type: EventFrame, Data: "b"}, <-ch)
+
+	// A reconnecting client is sent the events it missed
+	missed, _, unsubscribeAgain := hub.Subscribe(1)
+	defer unsubscribeAgain()
+	assert.Equal(t, []CatalogEvent{{ID: 2, Type: EventFrame, Data: "a"}, {ID: 3, Type: EventFrame, Data: "b"}}, missed)
+
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+	unsubscribe()
+
+	// A client too far behind is disconnected
+	_, slow, unsubscribeSlow := hub.Subscribe(0)
+	defer unsubscribeSlow()
+	for range subscriberBuffer + 1 {
+		hub.Publish(EventFrame, "frame")
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+
+	var none *EventHub
+	none.Publish(EventRun, "ignored")
+}
+
+func TestRunManager_Events(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500")
+	runs, _ := testRunManager(t, client)
+	hub := NewEventHub()
+	runs.SetEvents(hub)
+	_, ch, unsubscribe := hub.Subscribe(0)
+	defer unsubscribe()
+
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+
+	frames := make(map[string]FrameEvent)
+	for range 2 {
+		event := <-ch
+		require.Equal(t, EventFrame, event.Type)
+		frame := event.Data.(FrameEvent)
+		frames[frame.Path] = frame
+	}
+	require.Contains(t, frames, "rain/2025/09/15/01.webp")
+	frame := frames["rain/2025/09/15/01.webp"]
+	assert.Equal(t, started.ID, frame.RunID)
+	assert.Equal(t, "rain", frame.Kind)
+	assert.Equal(t, 1, frame.Timestep)
+
+	event := <-ch
+	require.Equal(t, EventRun, event.Type)
+	run := event.Data.(RunEvent)
+	assert.Equal(t, started.ID, run.ID)
+	assert.Equal(t, RunCompleted, run.Status)
+	assert.Equal(t, 2, run.Succeeded)
+}
+
+func TestEventHub_Streaming(t *testing.T) {
+	hub := NewEventHub()
+	assert.True(t, hub.Streaming(), "without a lock, every instance streams")
+
+	leader, err := NewLeader(lock.NewFileLock(filepath.Join(t.TempDir(), ".cron.lock")), time.Minute)
+	require.NoError(t, err)
+	hub.SetLeader(leader)
+	assert.False(t, hub.Streaming())
+	require.True(t, leader.Campaign(t.Context()))
+	assert.True(t, hub.Streaming())
+}
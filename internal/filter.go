@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+)
+
+// RunFilter restricts a download run to some of the files in the order, so that a
+// single broken overlay can be repaired without redoing the whole order
+type RunFilter struct {
+	// Overlays are the kinds processed, all of them when empty
+	Overlays []string `json:"overlays,omitempty"`
+	// Timesteps are the lead times processed, all of them when nil
+	Timesteps *TimestepRange `json:"timesteps,omitempty"`
+	// ValidFrom and ValidUntil bound the valid times processed, inclusively, when set
+	ValidFrom  time.Time `json:"validFrom,omitzero"`
+	ValidUntil time.Time `json:"validUntil,omitzero"`
+	// Force re-fetches files even when their frames already exist
+	Force bool `json:"force,omitempty"`
+	// Limit is the most files downloaded once the others are filtered out, with the
+	// rest skipped. Files whose frames exist are not counted. Zero downloads all of them
+	Limit int `json:"limit,omitempty"`
+}
+
+// Restricted reports whether the filter does anything beyond choosing the overlays
+func (f RunFilter) Restricted() bool {
+	return f.Timesteps != nil || !f.ValidFrom.IsZero() || !f.ValidUntil.IsZero() || f.Force || f.Limit > 0
+}
+
+// match returns a skip error when the filter excludes the file
+func (f RunFilter) match(id metoffice.FileID) error {
+	if f.Timesteps != nil && !f.Timesteps.Contains(id.Timestep) {
+		return skipped(fmt.Sprintf("timestep outside %s", f.Timesteps))
+	}
+	validTime := id.ValidTime()
+	if !f.ValidFrom.IsZero() && validTime.Before(f.ValidFrom) {
+		return skipped(fmt.Sprintf("valid before %s", f.ValidFrom.UTC().Format(time.RFC3339)))
+	}
+	if !f.ValidUntil.IsZero() && validTime.After(f.ValidUntil) {
+		return skipped(fmt.Sprintf("valid after %s", f.ValidUntil.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// TimestepRange is an inclusive range of timesteps, written as 0-12 or ts0-ts12, or
+// as a single timestep
+type TimestepRange struct {
+	Min int
+	Max int
+}
+
+func ParseTimestepRange(s string) (TimestepRange, error) {
+	parse := func(part string) (int, error) {
+		ts, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(part), "ts"))
+		if err != nil || ts < 0 || ts > metoffice.MaxTimestep {
+			return 0, fmt.Errorf("invalid timestep range %q (expected e.g. 0-12 or ts0-ts12, up to %d)", s, metoffice.MaxTimestep)
+		}
+		return ts, nil
+	}
+
+	from, to, isRange := strings.Cut(s, "-")
+	min, err := parse(from)
+	if err != nil {
+		return TimestepRange{}, err
+	}
+	max := min
+	if isRange {
+		if max, err = parse(to); err != nil {
+			return TimestepRange{}, err
+		}
+	}
+	if max < min {
+		return TimestepRange{}, fmt.Errorf("invalid timestep range %q: %d is before %d", s, max, min)
+	}
+	return TimestepRange{Min: min, Max: max}, nil
+}
+
+func (r TimestepRange) Contains(timestep int) bool {
+	return timestep >= r.Min && timestep <= r.Max
+}
+
+func (r TimestepRange) String() string {
+	return fmt.Sprintf("ts%d-ts%d", r.Min, r.Max)
+}
+
+func (r TimestepRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *TimestepRange) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimestepRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestepRange(t *testing.T) {
+	for s, expected := range map[string]TimestepRange{
+		"0-12":      {Min: 0, Max: 12},
+		"ts0-ts12":  {Min: 0, Max: 12},
+		"6":         {Min: 6, Max: 6},
+		"ts3 - ts4": {Min: 3, Max: 4},
+	} {
+		parsed, err := ParseTimestepRange(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, parsed, s)
+	}
+
+	for _, s := range []string{"", "ts", "12-0", "0-73", "-1", "a-b"} {
+		_, err := ParseTimestepRange(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRunFilter_JSON(t *testing.T) {
+	var filter RunFilter
+	require.NoError(t, json.Unmarshal([]byte(`{"timesteps":"ts0-ts12","validFrom":"2025-09-15T06:00:00Z","force":true}`), &filter))
+	assert.Equal(t, &TimestepRange{Min: 0, Max: 12}, filter.Timesteps)
+	assert.Equal(t, time.Date(2025, 9, 15, 6, 0, 0, 0, time.UTC), filter.ValidFrom)
+	assert.True(t, filter.Restricted())
+
+	encoded, err := json.Marshal(filter)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"timesteps":"ts0-ts12","validFrom":"2025-09-15T06:00:00Z","force":true}`, string(encoded))
+
+	assert.False(t, RunFilter{Overlays: []string{"rain"}}.Restricted())
+}
+
+func TestRunManager_StartFiltered(t *testing.T) {
+	client := newFakeDataHub(
+		"rain_ts0_2025091500", "rain_ts1_2025091500", "rain_ts2_2025091500",
+		"rain_ts3_2025091500", "snow_ts1_2025091500", "snow_ts2_2025091500",
+	)
+	runs, _ := testRunManager(t, client)
+
+	_, err := runs.StartFiltered(TriggerCLI, RunFilter{Limit: -1})
+	assert.ErrorContains(t, err, "limit must not be negative")
+
+	timesteps := TimestepRange{Min: 1, Max: 3}
+	started, err := runs.StartFiltered(TriggerCLI, RunFilter{
+		Overlays:   []string{"rain"},
+		Timesteps:  &timesteps,
+		ValidUntil: time.Date(2025, 9, 15, 2, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, started.Filter)
+	assert.Equal(t, []string{"rain"}, started.Overlays)
+	assert.Nil(t, started.Filter.Overlays)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCompleted, run.Status)
+	assert.Equal(t, map[string]FileStatus{
+		"rain_ts0_2025091500": StatusSkipped,
+		"rain_ts1_2025091500": StatusSucceeded,
+		"rain_ts2_2025091500": StatusSucceeded,
+		"rain_ts3_2025091500": StatusSkipped,
+		"snow_ts1_2025091500": StatusSkipped,
+		"snow_ts2_2025091500": StatusSkipped,
+	}, fileStatuses(run))
+
+	downloaded, err := runs.LastDownloaded()
+	require.NoError(t, err)
+	assert.True(t, downloaded.IsZero(), "a filtered run does not download the order in full")
+
+	// Without force, the frames written above are skipped, and the limit only counts
+	// the files still to be downloaded
+	started, err = runs.StartFiltered(TriggerCLI, RunFilter{Limit: 3})
+	require.NoError(t, err)
+	runs.Wait()
+	run, err = runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]FileStatus{
+		"rain_ts0_2025091500": StatusSucceeded,
+		"rain_ts1_2025091500": StatusSkipped,
+		"rain_ts2_2025091500": StatusSkipped,
+		"rain_ts3_2025091500": StatusSucceeded,
+		"snow_ts1_2025091500": StatusSucceeded,
+		"snow_ts2_2025091500": StatusSkipped,
+	}, fileStatuses(run))
+	assert.Equal(t, "already exists", run.Files[1].Reason)
+	assert.Equal(t, "beyond the limit of 3 files", run.Files[5].Reason)
+
+	started, err = runs.StartFiltered(TriggerCLI, RunFilter{Overlays: []string{"rain"}, Force: true})
+	require.NoError(t, err)
+	runs.Wait()
+	run, err = runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, run.Summary.Succeeded, "force re-fetches frames that exist")
+
+	// With force, the limit counts the files whose frames exist too
+	started, err = runs.StartFiltered(TriggerCLI, RunFilter{Force: true, Limit: 2})
+	require.NoError(t, err)
+	runs.Wait()
+	run, err = runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, run.Summary.Succeeded)
+}
+
+// statCountingStore counts the lookups made in the store
+type statCountingStore struct {
+	store.FrameStore
+	stats atomic.Int64
+}
+
+func (s *statCountingStore) Stat(ctx context.Context, key string) (store.ObjectInfo, error) {
+	s.stats.Add(1)
+	return s.FrameStore.Stat(ctx, key)
+}
+
+func TestProcessor_LimitPlansOnce(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500")
+	stats := func(filter RunFilter) int64 {
+		frames := &statCountingStore{FrameStore: store.NewFileStore(t.TempDir())}
+		p, err := newDownloader(frames, 1, client, "order-id")
+		require.NoError(t, err)
+		require.NoError(t, p.SetFilter(filter))
+		p.DispatchJobs()
+		p.StartWorkers()
+		require.Empty(t, p.Wait())
+		return frames.stats.Load()
+	}
+
+	// The plans made to apply the limit are reused, so the store is not checked twice
+	assert.Equal(t, stats(RunFilter{}), stats(RunFilter{Limit: 10}))
+}
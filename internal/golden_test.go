@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/require"
+	_ "golang.org/x/image/webp"
+)
+
+// Regenerate the golden images after an intentional pipeline change with:
+//
+//	go test ./internal -run TestOverlayPipelines_Golden -update
+var update = flag.Bool("update", false, "regenerate golden images")
+
+const (
+	samplesDir = "testdata/samples"
+	goldenDir  = "testdata/golden"
+	diffDir    = "testdata/diff"
+
+	// pixelTolerance is the largest per-pixel perceptual difference (0-255) that is
+	// not considered a change, allowing for minor floating point drift between platforms
+	pixelTolerance = 8
+	// maxDiffRatio is the fraction of pixels allowed to exceed pixelTolerance
+	maxDiffRatio = 0.001
+)
+
+// TestOverlayPipelines_Golden runs every downloaded overlay with a pipeline over its
+// sample, which must exist. Overlays without a pipeline are left as DataHub drew them,
+// and derived overlays are drawn from the wind field rather than an image
+func TestOverlayPipelines_Golden(t *testing.T) {
+	for kind, overlay := range Overlays {
+		if len(overlay.Pipeline) == 0 || overlay.Derived {
+			continue
+		}
+		t.Run(kind, func(t *testing.T) {
+			f, err := os.Open(filepath.Join(samplesDir, kind+".png"))
+			require.NoError(t, err, "missing sample image for %s", kind)
+			defer func() {
+				_ = f.Close()
+			}()
+
+			img, err := imageprocessing.NewImageFromReader(f)
+			require.NoError(t, err)
+			require.NoError(t, img.Pipeline(overlay.Pipeline...))
+			checkGolden(t, kind+".png", img.Img)
+
+			// What customers see is the encoded frame, so it is decoded and compared too
+			enc := overlay.Encoder
+			if enc == nil {
+				enc = defaultEncoder
+			}
+			var buf bytes.Buffer
+			require.NoError(t, img.Write(&buf, enc))
+			decoded, _, err := image.Decode(&buf)
+			require.NoError(t, err)
+			checkGolden(t, kind+".encoded.png", decoded)
+		})
+	}
+}
+
+// checkGolden compares the image with the named golden image, writing a diff image
+// when they differ, or replaces the golden image when run with -update
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	goldenPath := filepath.Join(goldenDir, name)
+	if *update {
+		writePNG(t, goldenPath, img)
+		return
+	}
+
+	golden := readPNG(t, goldenPath)
+	diff, changed := compareImages(golden, img)
+	if changed == 0 {
+		return
+	}
+
+	total := golden.Bounds().Dx() * golden.Bounds().Dy()
+	if float64(changed)/float64(total) > maxDiffRatio {
+		diffPath := filepath.Join(diffDir, name)
+		writePNG(t, diffPath, diff)
+		t.Errorf("%s differs from golden image in %d of %d pixels, see %s (run with -update to accept)",
+			name, changed, total, diffPath)
+	}
+}
+
+// compareImages counts the pixels whose perceptual difference exceeds pixelTolerance
+// and returns a diff image highlighting them in red over a faded copy of the golden
+func compareImages(golden, actual image.Image) (image.Image, int) {
+	bounds := golden.Bounds()
+	if bounds != actual.Bounds() {
+		return actual, bounds.Dx() * bounds.Dy()
+	}
+
+	diff := image.NewNRGBA(bounds)
+	changed := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			g := color.NRGBAModel.Convert(golden.At(x, y)).(color.NRGBA)
+			a := color.NRGBAModel.Convert(actual.At(x, y)).(color.NRGBA)
+			if perceptualDistance(g, a) > pixelTolerance {
+				changed++
+				diff.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+				continue
+			}
+			diff.SetNRGBA(x, y, color.NRGBA{g.R, g.G, g.B, g.A / 4})
+		}
+	}
+	return diff, changed
+}
+
+// perceptualDistance compares two colours using their alpha-premultiplied values, so
+// changes hidden in fully transparent pixels are ignored. Brightness (luma) and
+// opacity changes are the most visible and count in full, while shifts in a single
+// channel that leave the brightness alone count at half weight
+func perceptualDistance(c1, c2 color.NRGBA) float64 {
+	premultiply := func(v, a uint8) float64 {
+		return float64(v) * float64(a) / 255
+	}
+	dr := premultiply(c1.R, c1.A) - premultiply(c2.R, c2.A)
+	dg := premultiply(c1.G, c1.A) - premultiply(c2.G, c2.A)
+	db := premultiply(c1.B, c1.A) - premultiply(c2.B, c2.A)
+	da := float64(c1.A) - float64(c2.A)
+
+	luma := abs(0.299*dr + 0.587*dg + 0.114*db)
+	channel := max(abs(dr), abs(dg), abs(db))
+	return max(luma, channel/2, abs(da))
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func readPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err, "missing golden image, run with -update to create it")
+	defer func() {
+		_ = f.Close()
+	}()
+
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+	require.NoError(t, png.Encode(f, img))
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyFilename is the journal of finished runs, kept in the root directory
+const historyFilename = ".history.jsonl"
+
+// History is a journal of finished download runs, one JSON line per run, recording
+// the outcome of every file in the order. Runs that failed to start are recorded with
+// their error and no files
+type History struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewHistory(rootDir string) *History {
+	return &History{path: filepath.Join(rootDir, historyFilename)}
+}
+
+// Append records a finished run
+func (h *History) Append(run Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to append to run history: %w", err)
+	}
+	return f.Close()
+}
+
+// HistoryQuery selects runs from the history. Zero fields match everything
+type HistoryQuery struct {
+	// Since and Until select runs started within [Since, Until)
+	Since time.Time
+	Until time.Time
+	// FileId selects runs, and their files, whose fileId contains it
+	FileId string
+	// Status selects runs, and their files, with the status
+	Status FileStatus
+	// Limit is the maximum number of runs returned
+	Limit int
+}
+
+func (q HistoryQuery) filterFiles() bool {
+	return q.FileId != "" || q.Status != ""
+}
+
+// match reports whether the run is selected, keeping only the selected files
+func (q HistoryQuery) match(run *Run) bool {
+	if !q.Since.IsZero() && run.StartTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !run.StartTime.Before(q.Until) {
+		return false
+	}
+	if !q.filterFiles() {
+		return true
+	}
+
+	files := make([]FileResult, 0)
+	for _, file := range run.Files {
+		if q.FileId != "" && !strings.Contains(file.FileId, q.FileId) {
+			continue
+		}
+		if q.Status != "" && file.Status != q.Status {
+			continue
+		}
+		files = append(files, file)
+	}
+	run.Files = files
+	return len(files) > 0
+}
+
+// Query returns the runs selected by the query, most recent first. Lines that cannot
+// be parsed, such as one cut short by a crash, are skipped
+func (h *History) Query(q HistoryQuery) ([]Run, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	runs := make([]Run, 0)
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			log.Printf("Skipping line %d of %s: %v", line, h.path, err)
+			continue
+		}
+		if q.match(&run) {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read run history: %w", err)
+	}
+
+	// The journal is in the order runs finished
+	slices.Reverse(runs)
+	if q.Limit > 0 && len(runs) > q.Limit {
+		runs = runs[:q.Limit]
+	}
+	return runs, nil
+}
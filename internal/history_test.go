@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	root := t.TempDir()
+	history := NewHistory(root)
+
+	runs, err := history.Query(HistoryQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	monday := time.Date(2025, 9, 15, 4, 30, 0, 0, time.UTC)
+	tuesday := monday.AddDate(0, 0, 1)
+	require.NoError(t, history.Append(Run{ID: "monday", Status: RunCompleted, StartTime: monday, Files: []FileResult{
+		{FileId: "rain_ts6_2025091500", Status: StatusSucceeded, Bytes: 100},
+	}}))
+	require.NoError(t, history.Append(Run{ID: "tuesday", Status: RunFailed, StartTime: tuesday, Files: []FileResult{
+		{FileId: "rain_ts5_2025091600", Status: StatusSucceeded, Bytes: 100},
+		{FileId: "rain_ts6_2025091600", Status: StatusFailed, Reason: "timeout"},
+	}}))
+
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(filepath.Join(root, historyFilename), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"id":"partial","sta`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ids := func(runs []Run) []string {
+		result := make([]string, 0, len(runs))
+		for _, run := range runs {
+			result = append(result, run.ID)
+		}
+		return result
+	}
+
+	runs, err = history.Query(HistoryQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuesday", "monday"}, ids(runs))
+	assert.Len(t, runs[0].Files, 2)
+
+	runs, err = history.Query(HistoryQuery{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuesday"}, ids(runs))
+
+	runs, err = history.Query(HistoryQuery{Since: monday.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuesday"}, ids(runs))
+
+	runs, err = history.Query(HistoryQuery{Until: tuesday})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"monday"}, ids(runs))
+
+	runs, err = history.Query(HistoryQuery{FileId: "_ts6_"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuesday", "monday"}, ids(runs))
+	assert.Equal(t, []FileResult{{FileId: "rain_ts6_2025091600", Status: StatusFailed, Reason: "timeout"}}, runs[0].Files)
+
+	runs, err = history.Query(HistoryQuery{Status: StatusFailed})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuesday"}, ids(runs))
+	assert.Len(t, runs[0].Files, 1)
+}
@@ -0,0 +1,54 @@
+package encoder
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+type JPEGEncoder struct {
+	Quality int
+}
+
+// Encode writes the colour channels of the image as JPEG
+// JPEG has no alpha channel, so the transparency is written separately by EncodeMask
+// and the colours are stored un-premultiplied, ready for the mask to be applied
+func (e *JPEGEncoder) Encode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	opaque := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			opaque.SetRGBA(x, y, color.RGBA{c.R, c.G, c.B, 255})
+		}
+	}
+	return jpeg.Encode(w, opaque, &jpeg.Options{Quality: e.Quality})
+}
+
+// EncodeMask writes the alpha channel of the image as an 8-bit greyscale PNG,
+// where white is fully opaque and black fully transparent
+func (e *JPEGEncoder) EncodeMask(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	mask := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			mask.Pix[mask.PixOffset(x, y)] = uint8(a >> 8)
+		}
+	}
+	return png.Encode(w, mask)
+}
+
+func (e *JPEGEncoder) Extension() string {
+	return ".jpg"
+}
+
+func (e *JPEGEncoder) MaskExtension() string {
+	return ".mask.png"
+}
+
+func (e *JPEGEncoder) ContentType() string {
+	return "image/jpeg"
+}
@@ -0,0 +1,25 @@
+package encoder
+
+import (
+	"image"
+	"image/png"
+	"io"
+)
+
+type PNGEncoder struct {
+	Compression png.CompressionLevel
+}
+
+// Encode writes the image as a full colour (32-bit RGBA) PNG
+func (e *PNGEncoder) Encode(w io.Writer, img image.Image) error {
+	enc := png.Encoder{CompressionLevel: e.Compression}
+	return enc.Encode(w, img)
+}
+
+func (e *PNGEncoder) Extension() string {
+	return ".png"
+}
+
+func (e *PNGEncoder) ContentType() string {
+	return "image/png"
+}
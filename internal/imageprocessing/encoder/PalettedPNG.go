@@ -0,0 +1,102 @@
+package encoder
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"sort"
+)
+
+type PalettedPNGEncoder struct {
+	Colors      int
+	Dither      bool
+	Compression png.CompressionLevel
+}
+
+// Encode quantises the image to an 8-bit palette (including alpha) and writes it as PNG
+// The palette is built from the most common colours, bucketed to 5 bits per channel
+// and averaged within each bucket. Colors defaults to 256 when unset
+// When Dither is set, Floyd-Steinberg error diffusion is used to map pixels to the palette
+func (e *PalettedPNGEncoder) Encode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, e.palette(img))
+	if e.Dither {
+		draw.FloydSteinberg.Draw(paletted, bounds, img, bounds.Min)
+	} else {
+		draw.Draw(paletted, bounds, img, bounds.Min, draw.Src)
+	}
+
+	enc := png.Encoder{CompressionLevel: e.Compression}
+	return enc.Encode(w, paletted)
+}
+
+func (e *PalettedPNGEncoder) Extension() string {
+	return ".png"
+}
+
+func (e *PalettedPNGEncoder) ContentType() string {
+	return "image/png"
+}
+
+type bucket struct {
+	count      int
+	r, g, b, a int
+}
+
+func (e *PalettedPNGEncoder) palette(img image.Image) color.Palette {
+	size := e.Colors
+	if size <= 0 || size > 256 {
+		size = 256
+	}
+
+	buckets := make(map[uint32]*bucket)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			key := uint32(c.R>>3)<<15 | uint32(c.G>>3)<<10 | uint32(c.B>>3)<<5 | uint32(c.A>>3)
+			bkt, ok := buckets[key]
+			if !ok {
+				bkt = &bucket{}
+				buckets[key] = bkt
+			}
+			bkt.count++
+			bkt.r += int(c.R)
+			bkt.g += int(c.G)
+			bkt.b += int(c.B)
+			bkt.a += int(c.A)
+		}
+	}
+
+	ranked := make([]*bucket, 0, len(buckets))
+	for _, bkt := range buckets {
+		ranked = append(ranked, bkt)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].count > ranked[j].count
+	})
+
+	// Always reserve a fully transparent entry so empty areas stay transparent
+	palette := color.Palette{color.NRGBA{}}
+	for _, bkt := range ranked {
+		if len(palette) >= size {
+			break
+		}
+		c := color.NRGBA{
+			R: uint8(bkt.r / bkt.count),
+			G: uint8(bkt.g / bkt.count),
+			B: uint8(bkt.b / bkt.count),
+			A: uint8(bkt.a / bkt.count),
+		}
+		if c.A == 0 {
+			continue
+		}
+		palette = append(palette, c)
+	}
+	return palette
+}
@@ -0,0 +1,52 @@
+package encoder
+
+import (
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+type WebPEncoder struct {
+	Quality  float32
+	Lossless bool
+	Exact    bool
+}
+
+// Encode writes the image as WebP
+// Lossless avoids the halo artefacts lossy compression leaves around transparent edges,
+// while Exact preserves the RGB values of fully transparent pixels rather than letting
+// the encoder discard them
+func (e *WebPEncoder) Encode(w io.Writer, img image.Image) error {
+	if e.Exact {
+		img = straightAlpha(img)
+	}
+	return webp.Encode(w, img, &webp.Options{
+		Quality:  e.Quality,
+		Lossless: e.Lossless,
+		Exact:    e.Exact,
+	})
+}
+
+// straightAlpha returns the image's un-premultiplied pixels as an image.RGBA, which
+// the webp package passes to libwebp as they are. Given anything else, it premultiplies
+// the colours, losing those of transparent pixels and darkening translucent ones, as
+// libwebp expects straight alpha
+func straightAlpha(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(bounds)
+		draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+	}
+	return &image.RGBA{Pix: nrgba.Pix, Stride: nrgba.Stride, Rect: nrgba.Rect}
+}
+
+func (e *WebPEncoder) Extension() string {
+	return ".webp"
+}
+
+func (e *WebPEncoder) ContentType() string {
+	return "image/webp"
+}
@@ -0,0 +1,165 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/chai2010/webp"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage has an opaque red left half, a half transparent blue top right quarter and
+// a fully transparent, but green, bottom right quarter
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			switch {
+			case x < 8:
+				img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			case y < 8:
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 128})
+			default:
+				img.SetNRGBA(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+	return img
+}
+
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+// webpAt reads a pixel of a decoded WebP, whose colours are not premultiplied by alpha
+// despite being returned as an image.RGBA
+func webpAt(img *image.RGBA, x, y int) color.NRGBA {
+	i := img.PixOffset(x, y)
+	return color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+}
+
+func TestEncoders_Format(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoder     imageprocessing.Encoder
+		extension   string
+		contentType string
+	}{
+		{name: "webp", encoder: &WebPEncoder{Quality: 80}, extension: ".webp", contentType: "image/webp"},
+		{name: "png", encoder: &PNGEncoder{}, extension: ".png", contentType: "image/png"},
+		{name: "paletted png", encoder: &PalettedPNGEncoder{}, extension: ".png", contentType: "image/png"},
+		{name: "jpeg", encoder: &JPEGEncoder{Quality: 90}, extension: ".jpg", contentType: "image/jpeg"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.extension, tt.encoder.Extension(), tt.name)
+		assert.Equal(t, tt.contentType, tt.encoder.ContentType(), tt.name)
+	}
+}
+
+func TestWebPEncoder_LosslessExact(t *testing.T) {
+	src := testImage()
+	var buf bytes.Buffer
+	require.NoError(t, (&WebPEncoder{Lossless: true, Exact: true}).Encode(&buf, src))
+
+	decoded, err := webp.DecodeRGBA(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, src.Bounds(), decoded.Bounds())
+	for _, p := range []image.Point{{0, 0}, {12, 4}, {12, 12}} {
+		assert.Equal(t, src.NRGBAAt(p.X, p.Y), webpAt(decoded, p.X, p.Y), "pixel %v", p)
+	}
+}
+
+func TestWebPEncoder_Lossy(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, (&WebPEncoder{Quality: 80}).Encode(&buf, testImage()))
+
+	decoded, err := webp.DecodeRGBA(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, uint8(255), webpAt(decoded, 2, 2).A)
+	assert.InDelta(t, 255, int(webpAt(decoded, 2, 2).R), 16)
+	assert.Equal(t, uint8(0), webpAt(decoded, 14, 14).A)
+}
+
+func TestPNGEncoder(t *testing.T) {
+	src := testImage()
+	var buf bytes.Buffer
+	require.NoError(t, (&PNGEncoder{Compression: png.BestSpeed}).Encode(&buf, src))
+
+	decoded, err := png.Decode(&buf)
+	require.NoError(t, err)
+	for _, p := range []image.Point{{0, 0}, {12, 4}} {
+		assert.Equal(t, nrgbaAt(src, p.X, p.Y), nrgbaAt(decoded, p.X, p.Y), "pixel %v", p)
+	}
+	assert.Equal(t, uint8(0), nrgbaAt(decoded, 12, 12).A)
+}
+
+func TestPalettedPNGEncoder(t *testing.T) {
+	src := testImage()
+	var buf bytes.Buffer
+	require.NoError(t, (&PalettedPNGEncoder{Colors: 4}).Encode(&buf, src))
+
+	decoded, err := png.Decode(&buf)
+	require.NoError(t, err)
+	paletted, ok := decoded.(*image.Paletted)
+	require.True(t, ok, "8-bit palettised")
+	assert.LessOrEqual(t, len(paletted.Palette), 4)
+	assert.Equal(t, color.NRGBA{}, color.NRGBAModel.Convert(paletted.Palette[0]), "transparent entry first")
+
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, nrgbaAt(decoded, 0, 0))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 128}, nrgbaAt(decoded, 12, 4))
+	assert.Equal(t, uint8(0), nrgbaAt(decoded, 12, 12).A)
+}
+
+func TestPalettedPNGEncoder_Limit(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 4))
+	for x := range 256 {
+		for y := range 4 {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(255 - x), 0, 255})
+		}
+	}
+
+	for _, colors := range []int{0, 16, 300} {
+		var buf bytes.Buffer
+		require.NoError(t, (&PalettedPNGEncoder{Colors: colors, Dither: true}).Encode(&buf, gradient))
+		decoded, err := png.Decode(&buf)
+		require.NoError(t, err)
+		expected := colors
+		if colors <= 0 || colors > 256 {
+			expected = 256
+		}
+		assert.LessOrEqual(t, len(decoded.(*image.Paletted).Palette), expected, "colors %d", colors)
+	}
+}
+
+func TestJPEGEncoder(t *testing.T) {
+	src := testImage()
+	enc := &JPEGEncoder{Quality: 95}
+
+	var buf bytes.Buffer
+	require.NoError(t, enc.Encode(&buf, src))
+	decoded, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	red := nrgbaAt(decoded, 2, 8)
+	assert.Equal(t, uint8(255), red.A)
+	assert.InDelta(t, 255, int(red.R), 8)
+	// The colour of translucent pixels is kept un-premultiplied
+	assert.InDelta(t, 255, int(nrgbaAt(decoded, 12, 2).B), 8)
+
+	buf.Reset()
+	require.NoError(t, enc.EncodeMask(&buf, src))
+	mask, err := png.Decode(&buf)
+	require.NoError(t, err)
+	gray, ok := mask.(*image.Gray)
+	require.True(t, ok)
+	assert.Equal(t, uint8(255), gray.GrayAt(2, 2).Y)
+	assert.Equal(t, uint8(128), gray.GrayAt(12, 4).Y)
+	assert.Equal(t, uint8(0), gray.GrayAt(12, 12).Y)
+	assert.Equal(t, ".mask.png", enc.MaskExtension())
+}
@@ -2,20 +2,46 @@ package imageprocessing
 
 import (
 	"image"
+	"image/color"
 	"image/png"
 	"io"
-
-	"github.com/chai2010/webp"
+	"time"
 )
 
 type ProcessedImage struct {
-	Img image.Image
+	Img       image.Image
+	Kind      string
+	ValidTime time.Time
+	Trace     *Trace
+	Field     *VectorField
 }
 
 type PipelineStage interface {
 	Process(img *ProcessedImage) error
 }
 
+// ColorStage is implemented by stages that transform each pixel independently of its
+// neighbours, so the same mapping can be applied to individual colours (e.g. a legend)
+type ColorStage interface {
+	PipelineStage
+	MapColor(c color.Color) color.Color
+}
+
+// Encoder serialises a processed image into a specific file format
+type Encoder interface {
+	Encode(w io.Writer, img image.Image) error
+	Extension() string
+	ContentType() string
+}
+
+// MaskEncoder is implemented by encoders whose format cannot carry transparency,
+// so the alpha channel is written to a separate mask file alongside the image
+type MaskEncoder interface {
+	Encoder
+	EncodeMask(w io.Writer, img image.Image) error
+	MaskExtension() string
+}
+
 func NewImageFromReader(r io.Reader) (*ProcessedImage, error) {
 	img, err := png.Decode(r)
 	if err != nil {
@@ -26,15 +52,23 @@ func NewImageFromReader(r io.Reader) (*ProcessedImage, error) {
 	}, nil
 }
 
-func (p *ProcessedImage) Write(w io.Writer) error {
-	return webp.Encode(w, p.Img, &webp.Options{Quality: 80})
+func (p *ProcessedImage) Write(w io.Writer, enc Encoder) error {
+	return enc.Encode(w, p.Img)
 }
 
+// Pipeline runs each stage over the image in turn. When a trace is attached to the
+// image, the output of every stage is recorded to it
 func (p *ProcessedImage) Pipeline(stages ...PipelineStage) error {
 	for _, stage := range stages {
+		start := time.Now()
 		if err := stage.Process(p); err != nil {
 			return err
 		}
+		if p.Trace != nil {
+			if err := p.Trace.Record(StageName(stage), time.Since(start), p.Img); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,184 @@
+package stage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/legend"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type CompositeStage struct {
+	// Basemap and the optional Coastline are the PNGs drawn below and above the
+	// overlay, given as file names until the stage is located In a directory
+	Basemap   string
+	Coastline string
+	Title     string
+	Legend    *legend.Legend
+
+	once      sync.Once
+	mu        sync.Mutex // font faces are not safe for concurrent use
+	basemap   image.Image
+	coastline image.Image
+	titleFace font.Face
+	textFace  font.Face
+	err       error
+}
+
+const (
+	compositeMargin = 12
+)
+
+var (
+	bannerColor = color.NRGBA{0, 0, 0, 160}
+	textColor   = color.White
+)
+
+// In returns a copy of the stage with its basemap and coastline file names resolved
+// against dir, or nil when the basemap is missing so compositing can be skipped on
+// installations that have not provided one. A missing coastline is left out
+func (s *CompositeStage) In(dir string) *CompositeStage {
+	located := &CompositeStage{
+		Basemap: filepath.Join(dir, s.Basemap),
+		Title:   s.Title,
+		Legend:  s.Legend,
+	}
+	if !fileExists(located.Basemap) {
+		return nil
+	}
+	if s.Coastline != "" {
+		if coastline := filepath.Join(dir, s.Coastline); fileExists(coastline) {
+			located.Coastline = coastline
+		}
+	}
+	return located
+}
+
+// Process draws the overlay on top of the basemap (scaled to the overlay's bounds),
+// followed by the optional coastline outline layer, and then stamps the title, valid
+// time and legend in semi-transparent panels, producing an opaque ready-to-view image
+func (s *CompositeStage) Process(p *imageprocessing.ProcessedImage) error {
+	s.once.Do(s.load)
+	if s.err != nil {
+		return s.err
+	}
+
+	bounds := p.Img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.CatmullRom.Scale(out, bounds, s.basemap, s.basemap.Bounds(), draw.Src, nil)
+	draw.Draw(out, bounds, p.Img, bounds.Min, draw.Over)
+	if s.coastline != nil {
+		draw.CatmullRom.Scale(out, bounds, s.coastline, s.coastline.Bounds(), draw.Over, nil)
+	}
+
+	lines := []string{}
+	if !p.ValidTime.IsZero() {
+		lines = append(lines, "Valid "+p.ValidTime.UTC().Format("Mon 02 Jan 2006 15:04 UTC"))
+	}
+	s.mu.Lock()
+	s.drawTitle(out, lines)
+	s.drawLegend(out)
+	s.mu.Unlock()
+
+	p.Img = out
+	return nil
+}
+
+func (s *CompositeStage) load() {
+	if s.basemap, s.err = loadPNG(s.Basemap); s.err != nil {
+		s.err = fmt.Errorf("failed to load basemap: %w", s.err)
+		return
+	}
+	if s.Coastline != "" {
+		if s.coastline, s.err = loadPNG(s.Coastline); s.err != nil {
+			s.err = fmt.Errorf("failed to load coastline: %w", s.err)
+			return
+		}
+	}
+	if s.titleFace, s.err = newFace(gobold.TTF, 16); s.err != nil {
+		return
+	}
+	s.textFace, s.err = legend.NewFace(12)
+}
+
+func (s *CompositeStage) drawTitle(dst draw.Image, lines []string) {
+	if s.Title == "" && len(lines) == 0 {
+		return
+	}
+
+	titleHeight := s.titleFace.Metrics().Height.Ceil()
+	lineHeight := s.textFace.Metrics().Height.Ceil()
+	width := font.MeasureString(s.titleFace, s.Title).Ceil()
+	for _, line := range lines {
+		width = max(width, font.MeasureString(s.textFace, line).Ceil())
+	}
+
+	origin := dst.Bounds().Min.Add(image.Pt(compositeMargin, compositeMargin))
+	panel := image.Rect(0, 0, width+2*compositeMargin, titleHeight+len(lines)*lineHeight+compositeMargin).Add(origin)
+	draw.Draw(dst, panel, image.NewUniform(bannerColor), image.Point{}, draw.Over)
+
+	y := panel.Min.Y + compositeMargin/2 + s.titleFace.Metrics().Ascent.Ceil()
+	drawText(dst, s.titleFace, panel.Min.X+compositeMargin, y, s.Title)
+	y += titleHeight
+	for _, line := range lines {
+		drawText(dst, s.textFace, panel.Min.X+compositeMargin, y, line)
+		y += lineHeight
+	}
+}
+
+func (s *CompositeStage) drawLegend(dst draw.Image) {
+	if s.Legend == nil || len(s.Legend.Stops) == 0 {
+		return
+	}
+
+	size := s.Legend.Size(s.textFace)
+	bounds := dst.Bounds()
+	panel := image.Rect(0, 0, size.X+2*compositeMargin, size.Y+compositeMargin).
+		Add(image.Pt(bounds.Min.X+compositeMargin, bounds.Max.Y-2*compositeMargin-size.Y))
+	draw.Draw(dst, panel, image.NewUniform(bannerColor), image.Point{}, draw.Over)
+	s.Legend.Draw(dst, panel.Min.Add(image.Pt(compositeMargin, compositeMargin/2)), s.textFace, textColor)
+}
+
+func drawText(dst draw.Image, face font.Face, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func loadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return png.Decode(f)
+}
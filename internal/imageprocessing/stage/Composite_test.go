@@ -0,0 +1,82 @@
+package stage
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+	require.NoError(t, png.Encode(f, img))
+}
+
+func uniform(bounds image.Rectangle, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCompositeStage_In(t *testing.T) {
+	dir := t.TempDir()
+	template := &CompositeStage{Basemap: "basemap.png", Coastline: "coastline.png", Title: "Rain"}
+
+	assert.Nil(t, template.In(dir), "no basemap")
+
+	writePNG(t, filepath.Join(dir, "basemap.png"), uniform(image.Rect(0, 0, 4, 4), color.White))
+	located := template.In(dir)
+	require.NotNil(t, located)
+	assert.Equal(t, filepath.Join(dir, "basemap.png"), located.Basemap)
+	assert.Empty(t, located.Coastline, "a missing coastline is left out")
+	assert.Equal(t, "Rain", located.Title)
+
+	writePNG(t, filepath.Join(dir, "coastline.png"), uniform(image.Rect(0, 0, 4, 4), color.Transparent))
+	assert.Equal(t, filepath.Join(dir, "coastline.png"), template.In(dir).Coastline)
+	assert.Equal(t, "basemap.png", template.Basemap, "the template is unchanged")
+}
+
+func TestCompositeStage_Process(t *testing.T) {
+	dir := t.TempDir()
+	green := color.NRGBA{0, 128, 0, 255}
+	// The basemap is scaled to the overlay's bounds
+	writePNG(t, filepath.Join(dir, "basemap.png"), uniform(image.Rect(0, 0, 50, 50), green))
+
+	overlay := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 150; y < 160; y++ {
+		for x := 150; x < 160; x++ {
+			overlay.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+	p := &imageprocessing.ProcessedImage{Img: overlay, ValidTime: time.Date(2025, 9, 15, 6, 0, 0, 0, time.UTC)}
+
+	s := (&CompositeStage{Basemap: "basemap.png", Coastline: "coastline.png", Title: "Rain"}).In(dir)
+	require.NoError(t, s.Process(p))
+
+	assert.Equal(t, overlay.Bounds(), p.Img.Bounds())
+	assert.True(t, p.Img.(*image.RGBA).Opaque(), "composites are opaque")
+	assert.Equal(t, color.RGBA{0, 128, 0, 255}, p.Img.At(100, 100), "basemap where the overlay is transparent")
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, p.Img.At(155, 155), "overlay on top")
+	assert.NotEqual(t, color.RGBA{0, 128, 0, 255}, p.Img.At(14, 14), "title panel")
+}
+
+func TestCompositeStage_ProcessMissingBasemap(t *testing.T) {
+	s := &CompositeStage{Basemap: filepath.Join(t.TempDir(), "basemap.png")}
+	err := s.Process(&imageprocessing.ProcessedImage{Img: image.NewNRGBA(image.Rect(0, 0, 4, 4))})
+	assert.ErrorContains(t, err, "failed to load basemap")
+}
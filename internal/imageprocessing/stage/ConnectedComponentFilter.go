@@ -0,0 +1,74 @@
+package stage
+
+import (
+	"image"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+)
+
+type ConnectedComponentFilterStage struct {
+	MinArea        int
+	Threshold      uint8
+	EightConnected bool
+}
+
+// Process removes isolated blobs: pixels with an alpha above Threshold are grouped into
+// connected components (4-connected, or 8-connected when EightConnected is set), and
+// any component covering fewer than MinArea pixels is made fully transparent
+func (s *ConnectedComponentFilterStage) Process(p *imageprocessing.ProcessedImage) error {
+	src := toNRGBA(p.Img)
+	bounds := src.Bounds()
+	out := cloneNRGBA(src)
+
+	neighbours := []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	if s.EightConnected {
+		neighbours = append(neighbours, image.Pt(1, 1), image.Pt(1, -1), image.Pt(-1, 1), image.Pt(-1, -1))
+	}
+
+	visible := func(pt image.Point) bool {
+		return src.Pix[src.PixOffset(pt.X, pt.Y)+3] > s.Threshold
+	}
+
+	visited := make([]bool, bounds.Dx()*bounds.Dy())
+	index := func(pt image.Point) int {
+		return (pt.Y-bounds.Min.Y)*bounds.Dx() + (pt.X - bounds.Min.X)
+	}
+
+	var component, stack []image.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			start := image.Pt(x, y)
+			if visited[index(start)] || !visible(start) {
+				continue
+			}
+
+			// Flood fill the component using an explicit stack to avoid deep recursion
+			component = component[:0]
+			stack = append(stack[:0], start)
+			visited[index(start)] = true
+			for len(stack) > 0 {
+				pt := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				component = append(component, pt)
+				for _, n := range neighbours {
+					next := pt.Add(n)
+					if !next.In(bounds) || visited[index(next)] || !visible(next) {
+						continue
+					}
+					visited[index(next)] = true
+					stack = append(stack, next)
+				}
+			}
+
+			if len(component) >= s.MinArea {
+				continue
+			}
+			for _, pt := range component {
+				out.Pix[out.PixOffset(pt.X, pt.Y)+3] = 0
+			}
+		}
+	}
+
+	p.Img = out
+	return nil
+}
@@ -0,0 +1,100 @@
+package stage
+
+import (
+	"image"
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectedComponentFilter(t *testing.T) {
+	input := []string{
+		"#.....##",
+		"......##",
+		"..#.....",
+		"...#....",
+		"....###.",
+	}
+
+	tests := []struct {
+		name     string
+		stage    *ConnectedComponentFilterStage
+		expected []string
+	}{
+		{
+			name:     "no minimum area",
+			stage:    &ConnectedComponentFilterStage{},
+			expected: input,
+		},
+		{
+			name:  "four connected",
+			stage: &ConnectedComponentFilterStage{MinArea: 3},
+			expected: []string{
+				"......##",
+				"......##",
+				"........",
+				"........",
+				"....###.",
+			},
+		},
+		{
+			name:  "eight connected joins diagonals",
+			stage: &ConnectedComponentFilterStage{MinArea: 3, EightConnected: true},
+			expected: []string{
+				"......##",
+				"......##",
+				"..#.....",
+				"...#....",
+				"....###.",
+			},
+		},
+		{
+			name:  "larger than every component",
+			stage: &ConnectedComponentFilterStage{MinArea: 6, EightConnected: true},
+			expected: []string{
+				"........",
+				"........",
+				"........",
+				"........",
+				"........",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &imageprocessing.ProcessedImage{Img: alphaGrid(input...)}
+			require.NoError(t, tt.stage.Process(p))
+			assert.Equal(t, tt.expected, gridOf(p.Img))
+		})
+	}
+}
+
+func TestConnectedComponentFilter_Threshold(t *testing.T) {
+	img := alphaGrid("##.#")
+	img.Pix[img.PixOffset(1, 0)+3] = 10
+	p := &imageprocessing.ProcessedImage{Img: img}
+	require.NoError(t, (&ConnectedComponentFilterStage{MinArea: 2, Threshold: 20}).Process(p))
+	// The faint pixel does not join the first component, but is left as it is
+	assert.Equal(t, []string{".#.."}, gridOf(p.Img))
+}
+
+func TestConnectedComponentFilter_SubImage(t *testing.T) {
+	parent := alphaGrid(
+		"#####",
+		"#.#.#",
+		"#...#",
+		"#.###",
+		"#####",
+	)
+	sub := parent.SubImage(image.Rect(1, 1, 4, 4))
+	p := &imageprocessing.ProcessedImage{Img: sub}
+	require.NoError(t, (&ConnectedComponentFilterStage{MinArea: 2}).Process(p))
+	assert.Equal(t, []string{
+		"...",
+		"...",
+		".##",
+	}, gridOf(p.Img))
+}
@@ -17,17 +17,21 @@ func (s *GreyscaleStage) Process(p *imageprocessing.ProcessedImage) error {
 	gs := image.NewNRGBA(bounds)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := p.Img.At(x, y).RGBA()
-			if a == 0 {
-				gs.Set(x, y, color.NRGBA{0, 0, 0, 0})
-				continue
-			}
-			// Calculate luminance using standard coefficients
-			// Reference: https://en.wikipedia.org/wiki/Grayscale#Luma_coding_in_video_systems
-			lum := uint8(0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8))
-			gs.Set(x, y, color.NRGBA{255, 255, 255, lum})
+			gs.Set(x, y, s.MapColor(p.Img.At(x, y)))
 		}
 	}
 	p.Img = gs
 	return nil
 }
+
+// MapColor converts a single color, see Process
+func (s *GreyscaleStage) MapColor(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return color.NRGBA{0, 0, 0, 0}
+	}
+	// Calculate luminance using standard coefficients
+	// Reference: https://en.wikipedia.org/wiki/Grayscale#Luma_coding_in_video_systems
+	lum := uint8(0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8))
+	return color.NRGBA{255, 255, 255, lum}
+}
@@ -0,0 +1,155 @@
+package stage
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+)
+
+type KernelShape int
+
+const (
+	KernelSquare KernelShape = iota
+	KernelCross
+	KernelDisk
+)
+
+// Kernel is the structuring element for the morphological stages: a square, cross
+// (plus sign) or disk shape extending Radius pixels either side of the centre
+type Kernel struct {
+	Shape  KernelShape
+	Radius int
+}
+
+type ErodeStage struct {
+	Kernel Kernel
+}
+
+type DilateStage struct {
+	Kernel Kernel
+}
+
+type OpenStage struct {
+	Kernel Kernel
+}
+
+type CloseStage struct {
+	Kernel Kernel
+}
+
+// Process shrinks the visible regions of the image: each pixel's alpha becomes the
+// minimum alpha under the kernel, so features smaller than the kernel disappear
+func (s *ErodeStage) Process(p *imageprocessing.ProcessedImage) error {
+	p.Img = erode(toNRGBA(p.Img), s.Kernel.offsets())
+	return nil
+}
+
+// Process grows the visible regions of the image: each pixel takes the colour and
+// alpha of the most opaque pixel under the kernel, filling small holes and gaps
+func (s *DilateStage) Process(p *imageprocessing.ProcessedImage) error {
+	p.Img = dilate(toNRGBA(p.Img), s.Kernel.offsets())
+	return nil
+}
+
+// Process applies an erosion followed by a dilation, which removes speckles smaller
+// than the kernel while leaving the shape of larger regions largely intact
+func (s *OpenStage) Process(p *imageprocessing.ProcessedImage) error {
+	offsets := s.Kernel.offsets()
+	p.Img = dilate(erode(toNRGBA(p.Img), offsets), offsets)
+	return nil
+}
+
+// Process applies a dilation followed by an erosion, which fills pinholes and narrow
+// gaps smaller than the kernel while leaving the shape of larger regions largely intact
+func (s *CloseStage) Process(p *imageprocessing.ProcessedImage) error {
+	offsets := s.Kernel.offsets()
+	p.Img = erode(dilate(toNRGBA(p.Img), offsets), offsets)
+	return nil
+}
+
+func (k Kernel) offsets() []image.Point {
+	r := max(k.Radius, 0)
+	offsets := make([]image.Point, 0, (2*r+1)*(2*r+1))
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			switch k.Shape {
+			case KernelCross:
+				if dx != 0 && dy != 0 {
+					continue
+				}
+			case KernelDisk:
+				if dx*dx+dy*dy > r*r {
+					continue
+				}
+			}
+			offsets = append(offsets, image.Pt(dx, dy))
+		}
+	}
+	return offsets
+}
+
+func erode(src *image.NRGBA, offsets []image.Point) *image.NRGBA {
+	bounds := src.Bounds()
+	out := cloneNRGBA(src)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := src.Pix[src.PixOffset(x, y)+3]
+			for _, o := range offsets {
+				pt := image.Pt(x+o.X, y+o.Y)
+				if !pt.In(bounds) {
+					continue
+				}
+				alpha = min(alpha, src.Pix[src.PixOffset(pt.X, pt.Y)+3])
+			}
+			out.Pix[out.PixOffset(x, y)+3] = alpha
+		}
+	}
+	return out
+}
+
+func dilate(src *image.NRGBA, offsets []image.Point) *image.NRGBA {
+	bounds := src.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			best := src.PixOffset(x, y)
+			for _, o := range offsets {
+				pt := image.Pt(x+o.X, y+o.Y)
+				if !pt.In(bounds) {
+					continue
+				}
+				if j := src.PixOffset(pt.X, pt.Y); src.Pix[j+3] > src.Pix[best+3] {
+					best = j
+				}
+			}
+			i := out.PixOffset(x, y)
+			copy(out.Pix[i:i+4], src.Pix[best:best+4])
+		}
+	}
+	return out
+}
+
+// cloneNRGBA copies the image into a new one with the same bounds. The source may be a
+// sub-image, whose rows are further apart than the copy's, so it is copied row by row
+func cloneNRGBA(src *image.NRGBA) *image.NRGBA {
+	bounds := src.Bounds()
+	out := image.NewNRGBA(bounds)
+	rowLen := 4 * bounds.Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i, j := src.PixOffset(bounds.Min.X, y), out.PixOffset(bounds.Min.X, y)
+		copy(out.Pix[j:j+rowLen], src.Pix[i:i+rowLen])
+	}
+	return out
+}
+
+// toNRGBA returns the image as non-premultiplied RGBA, copying only when necessary
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	return out
+}
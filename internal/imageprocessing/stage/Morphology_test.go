@@ -0,0 +1,191 @@
+package stage
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// alphaGrid builds an image from rows of '#' (opaque red) and '.' (transparent)
+func alphaGrid(rows ...string) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			}
+		}
+	}
+	return img
+}
+
+// gridOf renders the alpha of an image as rows of '#' and '.'
+func gridOf(img image.Image) []string {
+	bounds := img.Bounds()
+	rows := make([]string, 0, bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var row strings.Builder
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a > 0 {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+func TestKernel_Offsets(t *testing.T) {
+	tests := []struct {
+		kernel   Kernel
+		expected int
+	}{
+		{kernel: Kernel{Shape: KernelSquare, Radius: 0}, expected: 1},
+		{kernel: Kernel{Shape: KernelSquare, Radius: 1}, expected: 9},
+		{kernel: Kernel{Shape: KernelCross, Radius: 1}, expected: 5},
+		{kernel: Kernel{Shape: KernelCross, Radius: 2}, expected: 9},
+		{kernel: Kernel{Shape: KernelDisk, Radius: 1}, expected: 5},
+		{kernel: Kernel{Shape: KernelDisk, Radius: 2}, expected: 13},
+		{kernel: Kernel{Shape: KernelSquare, Radius: -1}, expected: 1},
+	}
+
+	for _, tt := range tests {
+		assert.Len(t, tt.kernel.offsets(), tt.expected, "%+v", tt.kernel)
+	}
+}
+
+func TestMorphology(t *testing.T) {
+	square := Kernel{Shape: KernelSquare, Radius: 1}
+	cross := Kernel{Shape: KernelCross, Radius: 1}
+
+	tests := []struct {
+		name     string
+		stage    imageprocessing.PipelineStage
+		input    []string
+		expected []string
+	}{
+		{
+			name:  "erode square",
+			stage: &ErodeStage{Kernel: square},
+			input: []string{
+				".....",
+				".###.",
+				".###.",
+				".###.",
+				".....",
+			},
+			expected: []string{
+				".....",
+				".....",
+				"..#..",
+				".....",
+				".....",
+			},
+		},
+		{
+			name:  "dilate cross",
+			stage: &DilateStage{Kernel: cross},
+			input: []string{
+				".....",
+				".....",
+				"..#..",
+				".....",
+				".....",
+			},
+			expected: []string{
+				".....",
+				"..#..",
+				".###.",
+				"..#..",
+				".....",
+			},
+		},
+		{
+			name:  "open removes speckles",
+			stage: &OpenStage{Kernel: square},
+			input: []string{
+				"#......",
+				"...###.",
+				"...###.",
+				".#.###.",
+				".......",
+			},
+			expected: []string{
+				".......",
+				"...###.",
+				"...###.",
+				"...###.",
+				".......",
+			},
+		},
+		{
+			name:  "close fills pinholes",
+			stage: &CloseStage{Kernel: square},
+			input: []string{
+				"........",
+				"........",
+				"..####..",
+				"..#.##..",
+				"..####..",
+				"........",
+				"........",
+			},
+			expected: []string{
+				"........",
+				"........",
+				"..####..",
+				"..####..",
+				"..####..",
+				"........",
+				"........",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &imageprocessing.ProcessedImage{Img: alphaGrid(tt.input...)}
+			require.NoError(t, tt.stage.Process(p))
+			assert.Equal(t, tt.expected, gridOf(p.Img))
+		})
+	}
+}
+
+func TestDilate_KeepsColour(t *testing.T) {
+	img := alphaGrid("...", ".#.", "...")
+	p := &imageprocessing.ProcessedImage{Img: img}
+	require.NoError(t, (&DilateStage{Kernel: Kernel{Radius: 1}}).Process(p))
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, p.Img.(*image.NRGBA).NRGBAAt(0, 0))
+}
+
+func TestErode_SubImage(t *testing.T) {
+	parent := alphaGrid(
+		"#######",
+		"#.....#",
+		"#.###.#",
+		"#.###.#",
+		"#.###.#",
+		"#.....#",
+		"#######",
+	)
+	sub := parent.SubImage(image.Rect(1, 1, 6, 6)).(*image.NRGBA)
+	p := &imageprocessing.ProcessedImage{Img: sub}
+	require.NoError(t, (&ErodeStage{Kernel: Kernel{Radius: 1}}).Process(p))
+
+	assert.Equal(t, sub.Bounds(), p.Img.Bounds())
+	assert.Equal(t, []string{
+		".....",
+		".....",
+		"..#..",
+		".....",
+		".....",
+	}, gridOf(p.Img))
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, p.Img.(*image.NRGBA).NRGBAAt(3, 3))
+}
@@ -17,23 +17,38 @@ type ReplaceColorStage struct {
 // Tolerance defines how close a pixel must be to the target color to be affected
 // A pixel exactly matching the target color becomes fully transparent, one at the edge of the tolerance remains opaque
 func (s *ReplaceColorStage) Process(p *imageprocessing.ProcessedImage) error {
+	replace := s.replacement()
 	bounds := p.Img.Bounds()
 	out := image.NewNRGBA(bounds)
-	replaceR, replaceG, replaceB, _ := s.Replace.RGBA()
-	rR, rG, rB := float64(replaceR>>8), float64(replaceG>>8), float64(replaceB>>8)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := p.Img.At(x, y).RGBA()
-			R, G, B, A := float64(r>>8), float64(g>>8), float64(b>>8), float64(a>>8)
-			dist := math.Sqrt((rR-R)*(rR-R) + (rG-G)*(rG-G) + (rB-B)*(rB-B))
-			if dist < s.Tolerance {
-				alpha := uint8((dist / s.Tolerance) * A)
-				out.Set(x, y, color.NRGBA{uint8(R), uint8(G), uint8(B), alpha})
-			} else {
-				out.Set(x, y, color.NRGBA{uint8(R), uint8(G), uint8(B), uint8(A)})
-			}
+			out.SetNRGBA(x, y, s.mapColor(p.Img.At(x, y), replace))
 		}
 	}
 	p.Img = out
 	return nil
 }
+
+// MapColor applies the replacement to a single color, see Process
+func (s *ReplaceColorStage) MapColor(c color.Color) color.Color {
+	return s.mapColor(c, s.replacement())
+}
+
+// replacement returns the 8-bit channels of the color being replaced, which Process
+// works out once rather than for every pixel
+func (s *ReplaceColorStage) replacement() [3]float64 {
+	r, g, b, _ := s.Replace.RGBA()
+	return [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+}
+
+func (s *ReplaceColorStage) mapColor(c color.Color, replace [3]float64) color.NRGBA {
+	rR, rG, rB := replace[0], replace[1], replace[2]
+	r, g, b, a := c.RGBA()
+	R, G, B, A := float64(r>>8), float64(g>>8), float64(b>>8), float64(a>>8)
+	dist := math.Sqrt((rR-R)*(rR-R) + (rG-G)*(rG-G) + (rB-B)*(rB-B))
+	if dist < s.Tolerance {
+		alpha := uint8((dist / s.Tolerance) * A)
+		return color.NRGBA{uint8(R), uint8(G), uint8(B), alpha}
+	}
+	return color.NRGBA{uint8(R), uint8(G), uint8(B), uint8(A)}
+}
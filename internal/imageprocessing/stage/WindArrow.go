@@ -0,0 +1,84 @@
+package stage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"golang.org/x/image/vector"
+)
+
+type WindArrowStage struct {
+	Color     color.Color
+	Scale     float64
+	MaxLength float64
+	Width     float64
+}
+
+// Process draws an arrow at each point of the image's vector field, pointing in the
+// direction the wind blows towards. Arrow length is the speed multiplied by Scale
+// (pixels per unit), capped at MaxLength. Points without data are left empty
+func (s *WindArrowStage) Process(p *imageprocessing.ProcessedImage) error {
+	if p.Field == nil {
+		return errMissingVectorField
+	}
+
+	dst := canvas(p.Img)
+	bounds := dst.Bounds()
+	r := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	field := p.Field
+	for row := 0; row < field.Rows; row++ {
+		for col := 0; col < field.Cols; col++ {
+			u, v, ok := field.At(col, row)
+			if !ok {
+				continue
+			}
+			length := math.Min(math.Hypot(u, v)*s.Scale, s.MaxLength)
+			if length < 1 {
+				continue
+			}
+
+			// Image y axis points down, so the northward component is negated
+			angle := math.Atan2(-v, u)
+			centre := field.Point(col, row).Sub(bounds.Min)
+			cx, cy := float64(centre.X), float64(centre.Y)
+			dx, dy := math.Cos(angle)*length/2, math.Sin(angle)*length/2
+			tipX, tipY := cx+dx, cy+dy
+
+			stroke(r, cx-dx, cy-dy, tipX, tipY, s.Width)
+			head := math.Max(length/3, 3)
+			for _, side := range []float64{-1, 1} {
+				a := angle + math.Pi - side*math.Pi/6
+				stroke(r, tipX, tipY, tipX+math.Cos(a)*head, tipY+math.Sin(a)*head, s.Width)
+			}
+		}
+	}
+	r.Draw(dst, bounds, image.NewUniform(s.Color), image.Point{})
+
+	p.Img = dst
+	return nil
+}
+
+// stroke adds a line of the given width to the rasterizer as a filled quad
+func stroke(r *vector.Rasterizer, x0, y0, x1, y1, width float64) {
+	length := math.Hypot(x1-x0, y1-y0)
+	if length == 0 {
+		return
+	}
+	nx, ny := -(y1-y0)/length*width/2, (x1-x0)/length*width/2
+	r.MoveTo(float32(x0+nx), float32(y0+ny))
+	r.LineTo(float32(x1+nx), float32(y1+ny))
+	r.LineTo(float32(x1-nx), float32(y1-ny))
+	r.LineTo(float32(x0-nx), float32(y0-ny))
+	r.ClosePath()
+}
+
+// canvas returns a drawable copy of the image for glyphs to be rendered on to
+func canvas(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+	return dst
+}
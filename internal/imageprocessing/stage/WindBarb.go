@@ -0,0 +1,101 @@
+package stage
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"golang.org/x/image/vector"
+)
+
+const metresPerSecondToKnots = 1.943844
+
+var errMissingVectorField = errors.New("image has no vector field to render")
+
+type WindBarbStage struct {
+	Color  color.Color
+	Length float64
+	Width  float64
+}
+
+// Process draws a standard meteorological wind barb at each point of the image's
+// vector field (in m/s). The staff points into the wind, and the speed is shown in
+// knots, rounded to the nearest 5, by the feathers at its far end: a half barb for
+// 5 knots, a full barb for 10 and a pennant for 50. Calm winds are drawn as a circle
+func (s *WindBarbStage) Process(p *imageprocessing.ProcessedImage) error {
+	if p.Field == nil {
+		return errMissingVectorField
+	}
+
+	dst := canvas(p.Img)
+	bounds := dst.Bounds()
+	r := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	field := p.Field
+	for row := 0; row < field.Rows; row++ {
+		for col := 0; col < field.Cols; col++ {
+			u, v, ok := field.At(col, row)
+			if !ok {
+				continue
+			}
+
+			centre := field.Point(col, row).Sub(bounds.Min)
+			cx, cy := float64(centre.X), float64(centre.Y)
+			knots := int(math.Round(math.Hypot(u, v)*metresPerSecondToKnots/5)) * 5
+			if knots < 5 {
+				circle(r, cx, cy, s.Length/6, s.Width)
+				continue
+			}
+
+			// The staff runs from the station towards where the wind comes from
+			angle := math.Atan2(v, -u)
+			ux, uy := math.Cos(angle), math.Sin(angle)
+			endX, endY := cx+ux*s.Length, cy+uy*s.Length
+			stroke(r, cx, cy, endX, endY, s.Width)
+
+			// Feathers sit on the left of the staff (northern hemisphere convention)
+			fx, fy := -uy, ux
+			spacing := s.Length / 7
+			feather := s.Length / 2.5
+			pos := 0.0
+			for knots >= 50 {
+				bx, by := endX-ux*pos, endY-uy*pos
+				// Wound the same way as the strokes so overlapping coverage adds up
+				r.MoveTo(float32(bx), float32(by))
+				r.LineTo(float32(bx-ux*spacing*1.5), float32(by-uy*spacing*1.5))
+				r.LineTo(float32(bx+fx*feather), float32(by+fy*feather))
+				r.ClosePath()
+				pos += spacing * 2
+				knots -= 50
+			}
+			for knots >= 10 {
+				bx, by := endX-ux*pos, endY-uy*pos
+				stroke(r, bx, by, bx+fx*feather+ux*spacing, by+fy*feather+uy*spacing, s.Width)
+				pos += spacing
+				knots -= 10
+			}
+			if knots >= 5 {
+				if pos == 0 {
+					pos = spacing // a lone half barb is set in from the end
+				}
+				bx, by := endX-ux*pos, endY-uy*pos
+				stroke(r, bx, by, bx+(fx*feather+ux*spacing)/2, by+(fy*feather+uy*spacing)/2, s.Width)
+			}
+		}
+	}
+	r.Draw(dst, bounds, image.NewUniform(s.Color), image.Point{})
+
+	p.Img = dst
+	return nil
+}
+
+// circle approximates a ring of the given radius and line width with short strokes
+func circle(r *vector.Rasterizer, cx, cy, radius, width float64) {
+	const segments = 12
+	for i := range segments {
+		a0 := 2 * math.Pi * float64(i) / segments
+		a1 := 2 * math.Pi * float64(i+1) / segments
+		stroke(r, cx+radius*math.Cos(a0), cy+radius*math.Sin(a0), cx+radius*math.Cos(a1), cy+radius*math.Sin(a1), width)
+	}
+}
@@ -0,0 +1,81 @@
+package stage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inkIn counts the pixels drawn within the rectangle
+func inkIn(img image.Image, r image.Rectangle) int {
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a > 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func windFrame(speed, direction float64) *imageprocessing.ProcessedImage {
+	bounds := image.Rect(0, 0, 96, 48)
+	field := imageprocessing.NewVectorField(bounds, 48)
+	field.Set(0, 0, speed, direction)
+	return &imageprocessing.ProcessedImage{Img: image.NewNRGBA(bounds), Field: field}
+}
+
+func TestWindGlyphStages(t *testing.T) {
+	stages := map[string]imageprocessing.PipelineStage{
+		"arrow": &WindArrowStage{Color: color.Black, Scale: 3, MaxLength: 40, Width: 2},
+		"barb":  &WindBarbStage{Color: color.Black, Length: 20, Width: 2},
+	}
+	for name, s := range stages {
+		t.Run(name, func(t *testing.T) {
+			p := windFrame(10, 270)
+			require.NoError(t, s.Process(p))
+
+			assert.Positive(t, inkIn(p.Img, image.Rect(0, 0, 48, 48)), "glyph drawn at the grid point")
+			assert.Zero(t, inkIn(p.Img, image.Rect(48, 0, 96, 48)), "cell without data left empty")
+
+			err := s.Process(&imageprocessing.ProcessedImage{Img: image.NewNRGBA(image.Rect(0, 0, 8, 8))})
+			assert.ErrorIs(t, err, errMissingVectorField)
+		})
+	}
+}
+
+func TestWindArrowStage_Direction(t *testing.T) {
+	// A westerly blows towards the east, so the arrow head is on the right
+	p := windFrame(10, 270)
+	require.NoError(t, (&WindArrowStage{Color: color.Black, Scale: 3, MaxLength: 40, Width: 2}).Process(p))
+
+	left := inkIn(p.Img, image.Rect(0, 0, 24, 48))
+	right := inkIn(p.Img, image.Rect(24, 0, 48, 48))
+	assert.Greater(t, right, left)
+	assert.Zero(t, inkIn(p.Img, image.Rect(0, 0, 48, 16)), "horizontal arrow stays near the centre line")
+}
+
+func TestWindBarbStage(t *testing.T) {
+	barb := &WindBarbStage{Color: color.Black, Length: 20, Width: 2}
+
+	// The staff of a westerly points west, into the wind
+	p := windFrame(10, 270)
+	require.NoError(t, barb.Process(p))
+	assert.Greater(t, inkIn(p.Img, image.Rect(0, 0, 24, 48)), inkIn(p.Img, image.Rect(25, 0, 48, 48)))
+
+	// A calm wind is a small circle around the grid point, with no staff
+	calm := windFrame(1, 270)
+	require.NoError(t, barb.Process(calm))
+	assert.Positive(t, inkIn(calm.Img, image.Rect(18, 18, 30, 30)))
+	assert.Zero(t, inkIn(calm.Img, image.Rect(0, 0, 14, 48)))
+
+	// 20 knots has two full barbs where 10 knots has one
+	light := windFrame(5, 270)
+	require.NoError(t, barb.Process(light))
+	assert.Greater(t, inkIn(p.Img, image.Rect(0, 0, 48, 48)), inkIn(light.Img, image.Rect(0, 0, 48, 48)))
+}
@@ -0,0 +1,189 @@
+package imageprocessing
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const histogramBuckets = 16
+
+// TraceStep records the state of the image after a single pipeline stage
+type TraceStep struct {
+	Name           string                `json:"name"`
+	Duration       time.Duration         `json:"duration"`
+	Bounds         image.Rectangle       `json:"bounds"`
+	AlphaHistogram [histogramBuckets]int `json:"alphaHistogram"`
+	Image          string                `json:"image"`
+}
+
+// Trace collects the intermediate images produced while running a pipeline, writing
+// each one to its own directory below the trace root
+type Trace struct {
+	Name  string      `json:"name"`
+	Steps []TraceStep `json:"steps"`
+
+	dir string
+	mu  sync.Mutex
+}
+
+func NewTrace(rootDir, name string) (*Trace, error) {
+	dir := filepath.Join(rootDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+	return &Trace{Name: name, dir: dir}, nil
+}
+
+// Record writes the image as a PNG and appends a step with its bounds and a histogram
+// of alpha values (in 16 equal buckets) to the trace
+func (t *Trace) Record(name string, duration time.Duration, img image.Image) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	filename := fmt.Sprintf("%02d_%s.png", len(t.Steps), name)
+	f, err := os.Create(filepath.Join(t.dir, filename))
+	if err != nil {
+		return fmt.Errorf("failed to create trace image: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("failed to write trace image: %w", err)
+	}
+
+	t.Steps = append(t.Steps, TraceStep{
+		Name:           name,
+		Duration:       duration,
+		Bounds:         img.Bounds(),
+		AlphaHistogram: alphaHistogram(img),
+		Image:          filename,
+	})
+	return nil
+}
+
+// WriteContactSheet writes trace.json and an index.html page showing each stage's
+// image side by side with its timing, bounds and alpha histogram
+func (t *Trace) WriteContactSheet() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, "trace.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write trace summary: %w", err)
+	}
+
+	f, err := os.Create(filepath.Join(t.dir, "index.html"))
+	if err != nil {
+		return fmt.Errorf("failed to create contact sheet: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return contactSheetTemplate.Execute(f, t)
+}
+
+// WriteTraceIndex writes an index.html in the trace root linking to the contact sheet
+// of every traced frame found below it
+func WriteTraceIndex(rootDir string) error {
+	entries, err := os.ReadDir(rootDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(rootDir, entry.Name(), "index.html")); entry.IsDir() && err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	f, err := os.Create(filepath.Join(rootDir, "index.html"))
+	if err != nil {
+		return fmt.Errorf("failed to create trace index: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return traceIndexTemplate.Execute(f, names)
+}
+
+// StageName returns the type name of a pipeline stage, e.g. GaussianBlurStage
+func StageName(stage PipelineStage) string {
+	t := reflect.TypeOf(stage)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func alphaHistogram(img image.Image) [histogramBuckets]int {
+	var histogram [histogramBuckets]int
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			histogram[(a>>8)*histogramBuckets/256]++
+		}
+	}
+	return histogram
+}
+
+var contactSheetTemplate = template.Must(template.New("trace").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Pipeline trace: {{.Name}}</title>
+<style>
+body { font-family: sans-serif; background: #333; color: #eee; }
+.steps { display: flex; flex-wrap: wrap; gap: 16px; }
+figure { margin: 0; background: #555; padding: 8px; }
+img { max-width: 320px; display: block; background: repeating-conic-gradient(#777 0% 25%, #999 0% 50%) 50% / 16px 16px; }
+figcaption { font-size: 12px; margin-top: 4px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<div class="steps">
+{{range $i, $step := .Steps}}<figure>
+<a href="{{$step.Image}}"><img src="{{$step.Image}}" alt="{{$step.Name}}"></a>
+<figcaption>
+<b>{{$i}}. {{$step.Name}}</b><br>
+duration: {{$step.Duration}}<br>
+bounds: {{$step.Bounds}}<br>
+alpha histogram: {{$step.AlphaHistogram}}
+</figcaption>
+</figure>
+{{end}}</div>
+</body>
+</html>
+`))
+
+var traceIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Pipeline traces</title>
+</head>
+<body>
+<h1>Pipeline traces</h1>
+<ul>
+{{range .}}<li><a href="{{.}}/index.html">{{.}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,99 @@
+package imageprocessing
+
+import (
+	"image"
+	"mime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Variant describes a named output size for a processed image.
+// A Width of zero (or less) keeps the original resolution.
+type Variant struct {
+	Name   string
+	Width  int
+	Scaler draw.Scaler
+}
+
+const FullVariant = "full"
+
+// DefaultVariants is the resolution pyramid emitted for every frame, ordered from
+// largest to smallest. The full variant keeps the original filename so existing
+// clients continue to work unchanged.
+var DefaultVariants = []Variant{
+	{Name: FullVariant},
+	{Name: "medium", Width: 512, Scaler: draw.CatmullRom},
+	{Name: "thumb", Width: 192, Scaler: draw.ApproxBiLinear},
+}
+
+func (v Variant) IsFull() bool {
+	return v.Width <= 0
+}
+
+// Resize returns a copy of the image scaled down to the variant's width, preserving
+// the aspect ratio. The image is returned unchanged when the variant is full size or
+// the source is already narrower than the requested width.
+func (p *ProcessedImage) Resize(v Variant) *ProcessedImage {
+	bounds := p.Img.Bounds()
+	if v.IsFull() || bounds.Dx() <= v.Width {
+		return p
+	}
+
+	height := max(1, bounds.Dy()*v.Width/bounds.Dx())
+	scaler := v.Scaler
+	if scaler == nil {
+		scaler = draw.CatmullRom
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, v.Width, height))
+	scaler.Scale(dst, dst.Bounds(), p.Img, bounds, draw.Src, nil)
+	return &ProcessedImage{Img: dst, Kind: p.Kind, ValidTime: p.ValidTime}
+}
+
+// FindVariant returns the variant with the given name from the list.
+func FindVariant(variants []Variant, name string) (Variant, bool) {
+	for _, v := range variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// SelectVariant picks the smallest variant that is at least as wide as the requested
+// width, falling back to the full size variant when none is wide enough.
+func SelectVariant(variants []Variant, width int) Variant {
+	best := Variant{Name: FullVariant}
+	for _, v := range variants {
+		if v.IsFull() || v.Width < width {
+			continue
+		}
+		if best.IsFull() || v.Width < best.Width {
+			best = v
+		}
+	}
+	return best
+}
+
+// AcceptedVariant picks the variant asked for by a `size` or `width` parameter on any
+// of the media ranges of an Accept header, e.g. `image/webp;size=thumb` or
+// `image/*;width=300`.
+func AcceptedVariant(variants []Variant, accept string) (Variant, bool) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if size, ok := params["size"]; ok {
+			if v, found := FindVariant(variants, size); found {
+				return v, true
+			}
+		}
+		if width, err := strconv.Atoi(params["width"]); err == nil && width > 0 {
+			return SelectVariant(variants, width), true
+		}
+	}
+	return Variant{}, false
+}
@@ -0,0 +1,118 @@
+package imageprocessing
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+)
+
+var testVariants = []Variant{
+	{Name: FullVariant},
+	{Name: "medium", Width: 512, Scaler: draw.CatmullRom},
+	{Name: "thumb", Width: 192, Scaler: draw.ApproxBiLinear},
+}
+
+func TestResize(t *testing.T) {
+	src := &ProcessedImage{Img: image.NewNRGBA(image.Rect(0, 0, 1000, 500)), Kind: "rain"}
+
+	tests := []struct {
+		name     string
+		img      *ProcessedImage
+		variant  Variant
+		expected image.Rectangle
+		same     bool
+	}{
+		{name: "full size", img: src, variant: Variant{Name: FullVariant}, expected: src.Img.Bounds(), same: true},
+		{name: "medium", img: src, variant: testVariants[1], expected: image.Rect(0, 0, 512, 256)},
+		{name: "thumb", img: src, variant: testVariants[2], expected: image.Rect(0, 0, 192, 96)},
+		{name: "no scaler", img: src, variant: Variant{Name: "tiny", Width: 10}, expected: image.Rect(0, 0, 10, 5)},
+		{
+			name:     "at least a pixel high",
+			img:      &ProcessedImage{Img: image.NewNRGBA(image.Rect(0, 0, 1000, 1))},
+			variant:  testVariants[2],
+			expected: image.Rect(0, 0, 192, 1),
+		},
+		{
+			name:     "narrower than the variant",
+			img:      &ProcessedImage{Img: image.NewNRGBA(image.Rect(0, 0, 100, 50))},
+			variant:  testVariants[2],
+			expected: image.Rect(0, 0, 100, 50),
+			same:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resized := tt.img.Resize(tt.variant)
+			assert.Equal(t, tt.expected, resized.Img.Bounds())
+			if tt.same {
+				assert.Same(t, tt.img, resized)
+			} else {
+				assert.Equal(t, tt.img.Kind, resized.Kind)
+			}
+		})
+	}
+}
+
+func TestFindVariant(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		found    bool
+	}{
+		{name: "full", expected: "full", found: true},
+		{name: "thumb", expected: "thumb", found: true},
+		{name: "huge", found: false},
+		{name: "", found: false},
+	}
+
+	for _, tt := range tests {
+		v, found := FindVariant(testVariants, tt.name)
+		assert.Equal(t, tt.found, found, tt.name)
+		assert.Equal(t, tt.expected, v.Name, tt.name)
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	tests := []struct {
+		width    int
+		expected string
+	}{
+		{width: 1, expected: "thumb"},
+		{width: 192, expected: "thumb"},
+		{width: 193, expected: "medium"},
+		{width: 512, expected: "medium"},
+		{width: 513, expected: "full"},
+		{width: 4000, expected: "full"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, SelectVariant(testVariants, tt.width).Name, "width %d", tt.width)
+	}
+}
+
+func TestAcceptedVariant(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+		found    bool
+	}{
+		{accept: "", found: false},
+		{accept: "image/webp,*/*;q=0.8", found: false},
+		{accept: "image/webp;size=thumb", expected: "thumb", found: true},
+		{accept: "image/avif, image/webp;size=medium;q=0.9", expected: "medium", found: true},
+		{accept: "image/*;width=300", expected: "medium", found: true},
+		{accept: "image/*;width=5000", expected: "full", found: true},
+		{accept: "image/webp;size=huge", found: false},
+		{accept: "image/webp;width=-1", found: false},
+		{accept: "not a media type;;", found: false},
+	}
+
+	for _, tt := range tests {
+		v, found := AcceptedVariant(testVariants, tt.accept)
+		assert.Equal(t, tt.found, found, tt.accept)
+		assert.Equal(t, tt.expected, v.Name, tt.accept)
+	}
+}
@@ -0,0 +1,93 @@
+package imageprocessing
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"time"
+)
+
+// VectorField is a regular grid of (u, v) vectors sampled from a map image, one every
+// Step pixels. U is the eastward and V the northward component; NaN marks grid points
+// without data
+type VectorField struct {
+	Step   int
+	Cols   int
+	Rows   int
+	Bounds image.Rectangle
+	U      []float64
+	V      []float64
+}
+
+type vectorFieldJSON struct {
+	ValidTime time.Time  `json:"validTime"`
+	Units     string     `json:"units"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Step      int        `json:"step"`
+	Cols      int        `json:"cols"`
+	Rows      int        `json:"rows"`
+	U         []*float64 `json:"u"`
+	V         []*float64 `json:"v"`
+}
+
+func NewVectorField(bounds image.Rectangle, step int) *VectorField {
+	cols := (bounds.Dx() + step - 1) / step
+	rows := (bounds.Dy() + step - 1) / step
+	f := &VectorField{
+		Step:   step,
+		Cols:   cols,
+		Rows:   rows,
+		Bounds: bounds,
+		U:      make([]float64, cols*rows),
+		V:      make([]float64, cols*rows),
+	}
+	for i := range f.U {
+		f.U[i], f.V[i] = math.NaN(), math.NaN()
+	}
+	return f
+}
+
+// Point returns the pixel position of the grid point at the given column and row,
+// which is the centre of its Step x Step cell
+func (f *VectorField) Point(col, row int) image.Point {
+	return f.Bounds.Min.Add(image.Pt(col*f.Step+f.Step/2, row*f.Step+f.Step/2))
+}
+
+// Set stores the vector for a wind of the given speed blowing from the direction
+// (degrees clockwise from north), as used by meteorological conventions
+func (f *VectorField) Set(col, row int, speed, fromDirection float64) {
+	rad := fromDirection * math.Pi / 180
+	i := row*f.Cols + col
+	f.U[i] = -speed * math.Sin(rad)
+	f.V[i] = -speed * math.Cos(rad)
+}
+
+func (f *VectorField) At(col, row int) (u, v float64, ok bool) {
+	i := row*f.Cols + col
+	u, v = f.U[i], f.V[i]
+	return u, v, !math.IsNaN(u) && !math.IsNaN(v)
+}
+
+// JSON encodes the grid for front-end particle animation, with the U and V components
+// in row-major order and missing vectors as null
+func (f *VectorField) JSON(validTime time.Time, units string) ([]byte, error) {
+	out := vectorFieldJSON{
+		ValidTime: validTime,
+		Units:     units,
+		Width:     f.Bounds.Dx(),
+		Height:    f.Bounds.Dy(),
+		Step:      f.Step,
+		Cols:      f.Cols,
+		Rows:      f.Rows,
+		U:         make([]*float64, len(f.U)),
+		V:         make([]*float64, len(f.V)),
+	}
+	for i := range f.U {
+		if !math.IsNaN(f.U[i]) && !math.IsNaN(f.V[i]) {
+			u, v := math.Round(f.U[i]*100)/100, math.Round(f.V[i]*100)/100
+			out.U[i], out.V[i] = &u, &v
+		}
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,78 @@
+package imageprocessing
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVectorField(t *testing.T) {
+	f := NewVectorField(image.Rect(10, 20, 60, 44), 24)
+	assert.Equal(t, 3, f.Cols)
+	assert.Equal(t, 1, f.Rows)
+	assert.Len(t, f.U, 3)
+	assert.Equal(t, image.Pt(22, 32), f.Point(0, 0))
+	assert.Equal(t, image.Pt(70, 32), f.Point(2, 0))
+
+	for col := range f.Cols {
+		_, _, ok := f.At(col, 0)
+		assert.False(t, ok, "new fields have no data")
+	}
+}
+
+func TestVectorField_Set(t *testing.T) {
+	tests := map[string]struct {
+		speed, direction float64
+		u, v             float64
+	}{
+		"from north":      {10, 0, 0, -10},
+		"from east":       {10, 90, -10, 0},
+		"from south":      {10, 180, 0, 10},
+		"from west":       {10, 270, 10, 0},
+		"from south-west": {math.Sqrt2, 225, 1, 1},
+		"calm":            {0, 123, 0, 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := NewVectorField(image.Rect(0, 0, 4, 4), 2)
+			f.Set(1, 1, tt.speed, tt.direction)
+
+			u, v, ok := f.At(1, 1)
+			require.True(t, ok)
+			assert.InDelta(t, tt.u, u, 1e-9, "u")
+			assert.InDelta(t, tt.v, v, 1e-9, "v")
+			_, _, ok = f.At(0, 1)
+			assert.False(t, ok, "other points are untouched")
+
+			// Back to speed and the direction the wind blows from
+			assert.InDelta(t, tt.speed, math.Hypot(u, v), 1e-9, "speed")
+			if tt.speed > 0 {
+				from := math.Mod(math.Atan2(-u, -v)*180/math.Pi+360, 360)
+				assert.InDelta(t, tt.direction, from, 1e-9, "direction")
+			}
+		})
+	}
+}
+
+func TestVectorField_JSON(t *testing.T) {
+	f := NewVectorField(image.Rect(0, 0, 4, 2), 2)
+	f.Set(0, 0, 5, 45)
+
+	data, err := f.JSON(time.Date(2025, 9, 15, 6, 0, 0, 0, time.UTC), "m/s")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"validTime": "2025-09-15T06:00:00Z",
+		"units": "m/s",
+		"width": 4,
+		"height": 2,
+		"step": 2,
+		"cols": 2,
+		"rows": 1,
+		"u": [-3.54, null],
+		"v": [-3.54, null]
+	}`, string(data))
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// Layout determines where frames are stored below the root directory
+type Layout string
+
+const (
+	// RunLayout stores frames by run date and lead time: {kind}/YYYY/MM/DD/{timestep},
+	// with timesteps up to 72 hours. Runs other than 00Z go in a {HH}Z subdirectory
+	RunLayout Layout = "run"
+	// ValidTimeLayout stores frames by the time they forecast: valid/{kind}/YYYY/MM/DD/HH,
+	// keeping the frame from the most recent run, whose run and lead time are recorded
+	// in a HH.meta.json file alongside
+	ValidTimeLayout Layout = "valid"
+
+	validTimePrefix = "valid"
+	metadataSuffix  = ".meta.json"
+)
+
+func ParseLayout(s string) (Layout, error) {
+	switch Layout(s) {
+	case "", RunLayout:
+		return RunLayout, nil
+	case ValidTimeLayout:
+		return ValidTimeLayout, nil
+	}
+	return "", fmt.Errorf("unknown storage layout: %s (expected %s or %s)", s, RunLayout, ValidTimeLayout)
+}
+
+// Dir returns the directory holding the frame, relative to the root, and the hour
+// that the frame's files are named after
+func (l Layout) Dir(id metoffice.FileID) (string, int) {
+	if l == ValidTimeLayout {
+		validTime := id.ValidTime()
+		return path.Join(validTimePrefix, id.Kind, validTime.Format("2006/01/02")), validTime.Hour()
+	}
+	return id.Dir(), id.Timestep
+}
+
+// ValidTimePath returns the location of the kind's frame for the valid time in the
+// valid time layout, relative to the root and without any variant or extension suffix
+func ValidTimePath(kind string, validTime time.Time) string {
+	validTime = validTime.UTC()
+	return fmt.Sprintf("%s/%s/%s/%02d", validTimePrefix, kind, validTime.Format("2006/01/02"), validTime.Hour())
+}
+
+// ParseValidTimePath is the inverse of ValidTimePath, returning the kind and valid time
+// of the frame along with the remainder of the filename, e.g. ".thumb.webp"
+func ParseValidTimePath(p string) (string, time.Time, string, error) {
+	rest, ok := strings.CutPrefix(p, validTimePrefix+"/")
+	if !ok {
+		return "", time.Time{}, "", fmt.Errorf("%w %q: not in the valid time layout", metoffice.ErrInvalidPath, p)
+	}
+	id, suffix, err := metoffice.ParsePath(rest)
+	if err != nil {
+		return "", time.Time{}, "", err
+	}
+	if id.RunHour != 0 {
+		return "", time.Time{}, "", fmt.Errorf("%w %q: not in the valid time layout", metoffice.ErrInvalidPath, p)
+	}
+	if id.Timestep > 23 {
+		return "", time.Time{}, "", fmt.Errorf("%w %q: hour out of range (0-23)", metoffice.ErrInvalidPath, p)
+	}
+	return id.Kind, id.ValidTime(), suffix, nil
+}
+
+// FrameMetadata records which run and lead time a frame in the valid time layout came from
+type FrameMetadata struct {
+	Kind      string    `json:"kind"`
+	ValidTime time.Time `json:"validTime"`
+	RunTime   time.Time `json:"runTime"`
+	Timestep  int       `json:"timestep"`
+	FileId    string    `json:"fileId,omitempty"`
+}
+
+func newFrameMetadata(id metoffice.FileID) FrameMetadata {
+	return FrameMetadata{
+		Kind:      id.Kind,
+		ValidTime: id.ValidTime(),
+		RunTime:   id.RunTime(),
+		Timestep:  id.Timestep,
+		FileId:    id.String(),
+	}
+}
+
+func metadataFilename(dir string, hour int) string {
+	return fmt.Sprintf("%s/%02d%s", dir, hour, metadataSuffix)
+}
+
+// ReadFrameMetadata reads the metadata of a frame in the valid time layout, returning
+// nil when there is none
+func ReadFrameMetadata(ctx context.Context, frames store.FrameStore, dir string, hour int) (*FrameMetadata, error) {
+	r, _, err := frames.Get(ctx, metadataFilename(dir, hour))
+	if errors.Is(err, store.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta FrameMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse frame metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+func writeFrameMetadata(ctx context.Context, frames store.FrameStore, dir string, hour int, meta FrameMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	opts := store.PutOptions{ContentType: "application/json"}
+	return frames.Put(ctx, metadataFilename(dir, hour), bytes.NewReader(data), int64(len(data)), opts)
+}
+
+// compareStoredRun compares the run of the frame already stored in dir with runTime
+// when using the valid time layout. It returns -1 when the frame is missing or from an
+// older run, so should be replaced, 1 when it is from a newer run, and 0 when it is
+// from the same run. Frames in the run layout are never replaced, so always compare 0
+func (l Layout) compareStoredRun(ctx context.Context, frames store.FrameStore, dir string, hour int, runTime time.Time) (int, error) {
+	if l != ValidTimeLayout {
+		return 0, nil
+	}
+	meta, err := ReadFrameMetadata(ctx, frames, dir, hour)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return -1, nil
+	}
+	return meta.RunTime.Compare(runTime), nil
+}
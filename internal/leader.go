@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+)
+
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "metoffice_cron_leader",
+	Help: "Whether this instance holds the lock and runs the scheduled jobs",
+})
+
+// Leader campaigns for the lock, so that only the replica holding it runs the
+// scheduled jobs. Without a lock, every instance leads
+type Leader struct {
+	locker  lock.Locker
+	ttl     time.Duration
+	leading atomic.Bool
+	// onStepDown is called when the instance loses the lock
+	onStepDown func()
+}
+
+func NewLeader(locker lock.Locker, ttl time.Duration) (*Leader, error) {
+	if locker != nil && ttl <= 0 {
+		return nil, errors.New("lock ttl must be positive")
+	}
+	return &Leader{locker: locker, ttl: ttl}, nil
+}
+
+// IsLeader reports whether this instance runs the scheduled jobs
+func (l *Leader) IsLeader() bool {
+	return l == nil || l.locker == nil || l.leading.Load()
+}
+
+// OnStepDown registers a function called whenever the instance loses the lock, to
+// stop work another instance may now start
+func (l *Leader) OnStepDown(fn func()) {
+	if l != nil {
+		l.onStepDown = fn
+	}
+}
+
+// Campaign tries once to acquire, or renew, the lock. An instance that cannot reach
+// the lock steps down, as its lease may expire and be taken by another
+func (l *Leader) Campaign(ctx context.Context) bool {
+	if l == nil || l.locker == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.ttl/3)
+	defer cancel()
+	acquired, err := l.locker.Acquire(ctx, l.ttl)
+	if err != nil {
+		log.Printf("Failed to acquire the cron lock: %v", err)
+		acquired = false
+	}
+
+	if was := l.leading.Swap(acquired); was != acquired {
+		if acquired {
+			log.Printf("Acquired the cron lock, running the scheduled jobs")
+			leaderGauge.Set(1)
+		} else {
+			log.Printf("Lost the cron lock, leaving the scheduled jobs to another instance")
+			leaderGauge.Set(0)
+			if l.onStepDown != nil {
+				l.onStepDown()
+			}
+		}
+	}
+	return acquired
+}
+
+// Watch campaigns for the lock every third of its ttl, so it is renewed well before
+// it expires
+func (l *Leader) Watch() {
+	if l == nil || l.locker == nil {
+		return
+	}
+	ticker := time.NewTicker(l.ttl / 3)
+	for range ticker.C {
+		l.Campaign(context.Background())
+	}
+}
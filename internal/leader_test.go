@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeader_Campaign(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), ".cron.lock")
+	a, err := NewLeader(lock.NewFileLock(path), time.Minute)
+	require.NoError(t, err)
+	b, err := NewLeader(lock.NewFileLock(path), time.Minute)
+	require.NoError(t, err)
+
+	assert.False(t, a.IsLeader(), "not a leader until it has campaigned")
+	assert.True(t, a.Campaign(ctx))
+	assert.False(t, b.Campaign(ctx))
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	steppedDown := 0
+	a.OnStepDown(func() { steppedDown++ })
+	require.NoError(t, a.locker.Release(ctx))
+	assert.True(t, b.Campaign(ctx))
+	assert.True(t, b.IsLeader())
+	assert.Equal(t, 0, steppedDown, "not told until it campaigns")
+	assert.False(t, a.Campaign(ctx))
+	assert.False(t, a.Campaign(ctx))
+	assert.Equal(t, 1, steppedDown)
+
+	// Without a lock, every instance leads
+	var none *Leader
+	assert.True(t, none.IsLeader())
+	unlocked, err := NewLeader(nil, 0)
+	require.NoError(t, err)
+	assert.True(t, unlocked.Campaign(ctx))
+	assert.True(t, unlocked.IsLeader())
+
+	_, err = NewLeader(lock.NewFileLock(path), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package legend
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+)
+
+// Stop is a single colour band in a legend, covering values from Value up to the
+// next stop
+type Stop struct {
+	Value float64
+	Color color.NRGBA
+	Label string
+}
+
+type Legend struct {
+	Title string
+	Units string
+	Stops []Stop
+}
+
+type stopJSON struct {
+	Value float64 `json:"value"`
+	Color string  `json:"color"`
+	Label string  `json:"label"`
+}
+
+type legendJSON struct {
+	Title string     `json:"title"`
+	Units string     `json:"units"`
+	Stops []stopJSON `json:"stops"`
+}
+
+// Remap returns a copy of the legend with each colour passed through the per-pixel
+// stages of a pipeline, so the legend matches the published images. Stages that
+// depend on neighbouring pixels (blur, resampling) do not alter colours and are skipped
+func (l *Legend) Remap(stages ...imageprocessing.PipelineStage) *Legend {
+	remapped := &Legend{
+		Title: l.Title,
+		Units: l.Units,
+		Stops: make([]Stop, len(l.Stops)),
+	}
+	for i, stop := range l.Stops {
+		var c color.Color = stop.Color
+		for _, stage := range stages {
+			if cs, ok := stage.(imageprocessing.ColorStage); ok {
+				c = cs.MapColor(c)
+			}
+		}
+		stop.Color = color.NRGBAModel.Convert(c).(color.NRGBA)
+		remapped.Stops[i] = stop
+	}
+	return remapped
+}
+
+func (l *Legend) MarshalJSON() ([]byte, error) {
+	out := legendJSON{
+		Title: l.Title,
+		Units: l.Units,
+		Stops: make([]stopJSON, len(l.Stops)),
+	}
+	for i, stop := range l.Stops {
+		out.Stops[i] = stopJSON{Value: stop.Value, Color: Hex(stop.Color), Label: stop.Label}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads a legend in the format MarshalJSON writes, so the colours of a
+// DataHub style can be supplied without rebuilding
+func (l *Legend) UnmarshalJSON(data []byte) error {
+	var in legendJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if len(in.Stops) == 0 {
+		return fmt.Errorf("legend %s has no stops", in.Title)
+	}
+	stops := make([]Stop, len(in.Stops))
+	for i, stop := range in.Stops {
+		c, err := ParseHex(stop.Color)
+		if err != nil {
+			return fmt.Errorf("stop %d: %w", i, err)
+		}
+		stops[i] = Stop{Value: stop.Value, Color: c, Label: stop.Label}
+	}
+	*l = Legend{Title: in.Title, Units: in.Units, Stops: stops}
+	return nil
+}
+
+// Load reads a legend from a JSON file
+func Load(path string) (*Legend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legend %s: %w", path, err)
+	}
+	l := &Legend{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse legend %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// ParseHex reads a colour written as #rrggbb or #rrggbbaa
+func ParseHex(s string) (color.NRGBA, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || (len(hex) != 6 && len(hex) != 8) {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q (expected #rrggbb or #rrggbbaa)", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q (expected #rrggbb or #rrggbbaa)", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// Hex formats the colour as #rrggbb, or #rrggbbaa when it is not fully opaque
+func Hex(c color.NRGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// Lookup decodes a pixel colour back to a value by finding the stop with the nearest
+// colour. It fails for transparent pixels, or when no stop is within tolerance (the
+// Euclidean RGB distance), e.g. for coastlines or labels drawn over the data
+func (l *Legend) Lookup(c color.Color, tolerance float64) (float64, bool) {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if nrgba.A == 0 || len(l.Stops) == 0 {
+		return 0, false
+	}
+
+	best, bestDist := 0, math.MaxFloat64
+	for i, stop := range l.Stops {
+		dr := float64(stop.Color.R) - float64(nrgba.R)
+		dg := float64(stop.Color.G) - float64(nrgba.G)
+		db := float64(stop.Color.B) - float64(nrgba.B)
+		if dist := math.Sqrt(dr*dr + dg*dg + db*db); dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	if bestDist > tolerance {
+		return 0, false
+	}
+	return l.Stops[best].Value, true
+}
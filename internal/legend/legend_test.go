@@ -0,0 +1,147 @@
+package legend_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing/stage"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/legend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegend_Remap(t *testing.T) {
+	lgd := &legend.Legend{
+		Title: "Test",
+		Units: "mm/h",
+		Stops: []legend.Stop{
+			{Value: 0, Color: color.NRGBA{255, 255, 255, 255}, Label: "white"},
+			{Value: 1, Color: color.NRGBA{0, 0, 255, 255}, Label: "blue"},
+		},
+	}
+
+	remapped := lgd.Remap(
+		&stage.ReplaceColorStage{Tolerance: 50, Replace: color.White},
+		&stage.GaussianBlurStage{Sigma: 1.0},
+	)
+
+	assert.Equal(t, color.NRGBA{255, 255, 255, 0}, remapped.Stops[0].Color)
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, remapped.Stops[1].Color)
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, lgd.Stops[0].Color, "original legend should be unchanged")
+}
+
+func TestLegend_MarshalJSON(t *testing.T) {
+	lgd := &legend.Legend{
+		Title: "Test",
+		Units: "%",
+		Stops: []legend.Stop{
+			{Value: 30, Color: color.NRGBA{0, 88, 36, 255}, Label: "30 - 40"},
+			{Value: 40, Color: color.NRGBA{255, 255, 255, 128}, Label: "40 - 50"},
+		},
+	}
+
+	data, err := json.Marshal(lgd)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"title": "Test",
+		"units": "%",
+		"stops": [
+			{"value": 30, "color": "#005824", "label": "30 - 40"},
+			{"value": 40, "color": "#ffffff80", "label": "40 - 50"}
+		]
+	}`, string(data))
+}
+
+func TestLegend_Lookup(t *testing.T) {
+	lgd := &legend.Legend{
+		Stops: []legend.Stop{
+			{Value: 0, Color: color.NRGBA{0, 0, 255, 255}},
+			{Value: 10, Color: color.NRGBA{0, 255, 0, 255}},
+			{Value: 20, Color: color.NRGBA{255, 0, 0, 255}},
+		},
+	}
+
+	tests := map[string]struct {
+		legend   *legend.Legend
+		color    color.Color
+		expected float64
+		ok       bool
+	}{
+		"exact":              {lgd, color.NRGBA{0, 255, 0, 255}, 10, true},
+		"within tolerance":   {lgd, color.NRGBA{20, 230, 10, 255}, 10, true},
+		"nearest stop":       {lgd, color.NRGBA{230, 20, 20, 255}, 20, true},
+		"beyond tolerance":   {lgd, color.NRGBA{128, 128, 128, 255}, 0, false},
+		"transparent":        {lgd, color.NRGBA{0, 0, 255, 0}, 0, false},
+		"premultiplied":      {lgd, color.RGBA{0, 0, 128, 128}, 0, true},
+		"legend has no stop": {&legend.Legend{}, color.NRGBA{0, 0, 255, 255}, 0, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, ok := tt.legend.Lookup(tt.color, 40)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestLegend_UnmarshalJSON(t *testing.T) {
+	lgd := &legend.Legend{
+		Title: "Test",
+		Units: "m/s",
+		Stops: []legend.Stop{
+			{Value: 0, Color: color.NRGBA{0, 88, 36, 255}, Label: "calm"},
+			{Value: 5, Color: color.NRGBA{255, 255, 255, 128}, Label: "breeze"},
+		},
+	}
+	data, err := json.Marshal(lgd)
+	require.NoError(t, err)
+
+	var decoded legend.Legend
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *lgd, decoded)
+
+	for _, data := range []string{
+		`{"title": "Empty", "stops": []}`,
+		`{"stops": [{"value": 0, "color": "blue"}]}`,
+		`{"stops": [`,
+	} {
+		assert.Error(t, json.Unmarshal([]byte(data), &decoded), data)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legend.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"title": "Wind", "units": "m/s", "stops": [{"value": 2, "color": "#0a0b0c", "label": "2"}]}`), 0644))
+
+	lgd, err := legend.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, &legend.Legend{
+		Title: "Wind",
+		Units: "m/s",
+		Stops: []legend.Stop{{Value: 2, Color: color.NRGBA{10, 11, 12, 255}, Label: "2"}},
+	}, lgd)
+
+	_, err = legend.Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestParseHex(t *testing.T) {
+	tests := map[string]color.NRGBA{
+		"#005824":   {0, 88, 36, 255},
+		"#FFFFFF80": {255, 255, 255, 128},
+		"#00000000": {0, 0, 0, 0},
+	}
+	for s, expected := range tests {
+		c, err := legend.ParseHex(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, c, s)
+	}
+
+	for _, s := range []string{"", "005824", "#0058", "#00582g", "#0058240"} {
+		_, err := legend.ParseHex(s)
+		assert.Error(t, err, s)
+	}
+}
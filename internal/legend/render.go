@@ -0,0 +1,139 @@
+package legend
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	padding    = 8
+	swatchSize = 14
+	fontSize   = 12
+)
+
+var (
+	// Light colours (e.g. the white cloud layer) are invisible against a white
+	// page, so legends are drawn on a neutral background
+	background = color.NRGBA{96, 96, 96, 255}
+	foreground = color.White
+
+	faceOnce sync.Once
+	face     font.Face
+	faceErr  error
+	faceMu   sync.Mutex // font faces are not safe for concurrent use
+)
+
+// NewFace returns a regular Go font face at the given size
+func NewFace(size float64) (font.Face, error) {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Size returns the dimensions needed to draw the legend entries with the given face
+func (l *Legend) Size(face font.Face) image.Point {
+	width := 0
+	for _, stop := range l.Stops {
+		width = max(width, font.MeasureString(face, stop.Label).Ceil())
+	}
+	return image.Pt(swatchSize+padding+width, len(l.Stops)*lineHeight(face))
+}
+
+// Draw renders one swatch and label per stop, top to bottom, starting at the given point
+func (l *Legend) Draw(dst draw.Image, at image.Point, face font.Face, text color.Color) {
+	lh := lineHeight(face)
+	ascent := face.Metrics().Ascent.Ceil()
+	for i, stop := range l.Stops {
+		y := at.Y + i*lh
+		swatch := image.Rect(0, 0, swatchSize, swatchSize).Add(image.Pt(at.X, y+(lh-swatchSize)/2))
+		draw.Draw(dst, swatch, image.NewUniform(stop.Color), image.Point{}, draw.Over)
+
+		d := &font.Drawer{
+			Dst:  dst,
+			Src:  image.NewUniform(text),
+			Face: face,
+			Dot:  fixed.P(swatch.Max.X+padding, y+(lh+ascent)/2-1),
+		}
+		d.DrawString(stop.Label)
+	}
+}
+
+// WritePNG renders the legend (title, units and stops) as a standalone PNG image
+func (l *Legend) WritePNG(w io.Writer) error {
+	faceOnce.Do(func() {
+		face, faceErr = NewFace(fontSize)
+	})
+	if faceErr != nil {
+		return faceErr
+	}
+
+	faceMu.Lock()
+	defer faceMu.Unlock()
+
+	title := l.heading()
+	lh := lineHeight(face)
+	size := l.Size(face)
+	width := max(size.X, font.MeasureString(face, title).Ceil()) + 2*padding
+	height := lh + size.Y + 2*padding
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(foreground),
+		Face: face,
+		Dot:  fixed.P(padding, padding+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(title)
+	l.Draw(img, image.Pt(padding, padding+lh), face, foreground)
+
+	return png.Encode(w, img)
+}
+
+// WriteSVG renders the legend as a standalone SVG document
+func (l *Legend) WriteSVG(w io.Writer) error {
+	const rowHeight = swatchSize + 6
+	width := 220
+	height := 2*padding + rowHeight*(len(l.Stops)+1)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="%d">`+"\n", width, height, fontSize)
+	fmt.Fprintf(&sb, `  <rect width="100%%" height="100%%" fill="%s"/>`+"\n", Hex(background))
+	fmt.Fprintf(&sb, `  <text x="%d" y="%d" fill="#ffffff">%s</text>`+"\n", padding, padding+fontSize, html.EscapeString(l.heading()))
+	for i, stop := range l.Stops {
+		y := padding + rowHeight*(i+1)
+		fmt.Fprintf(&sb, `  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="%.3f"/>`+"\n",
+			padding, y, swatchSize, swatchSize, Hex(color.NRGBA{stop.Color.R, stop.Color.G, stop.Color.B, 255}), float64(stop.Color.A)/255)
+		fmt.Fprintf(&sb, `  <text x="%d" y="%d" fill="#ffffff">%s</text>`+"\n", 2*padding+swatchSize, y+fontSize, html.EscapeString(stop.Label))
+	}
+	sb.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (l *Legend) heading() string {
+	if l.Units == "" {
+		return l.Title
+	}
+	return fmt.Sprintf("%s (%s)", l.Title, l.Units)
+}
+
+func lineHeight(f font.Face) int {
+	return max(f.Metrics().Height.Ceil(), swatchSize+4)
+}
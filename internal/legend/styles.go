@@ -0,0 +1,101 @@
+package legend
+
+import (
+	"image/color"
+	"math"
+)
+
+// PrecipitationRate matches the default DataHub style for total_precipitation_rate
+var PrecipitationRate = &Legend{
+	Title: "Precipitation rate",
+	Units: "mm/h",
+	Stops: []Stop{
+		{Value: 0.5, Color: color.NRGBA{0, 0, 254, 255}, Label: "0.5 - 1"},
+		{Value: 1, Color: color.NRGBA{50, 101, 254, 255}, Label: "1 - 2"},
+		{Value: 2, Color: color.NRGBA{0, 127, 0, 255}, Label: "2 - 4"},
+		{Value: 4, Color: color.NRGBA{254, 203, 0, 255}, Label: "4 - 8"},
+		{Value: 8, Color: color.NRGBA{254, 152, 0, 255}, Label: "8 - 16"},
+		{Value: 16, Color: color.NRGBA{254, 0, 0, 255}, Label: "16 - 32"},
+		{Value: 32, Color: color.NRGBA{254, 0, 254, 255}, Label: "> 32"},
+	},
+}
+
+// CloudAmountBuGn matches the iso_fill_bu_gn_30_100_pc DataHub style for cloud_amount_total
+var CloudAmountBuGn = &Legend{
+	Title: "Total cloud cover",
+	Units: "%",
+	Stops: []Stop{
+		{Value: 30, Color: color.NRGBA{247, 252, 253, 255}, Label: "30 - 40"},
+		{Value: 40, Color: color.NRGBA{229, 245, 249, 255}, Label: "40 - 50"},
+		{Value: 50, Color: color.NRGBA{204, 236, 230, 255}, Label: "50 - 60"},
+		{Value: 60, Color: color.NRGBA{153, 216, 201, 255}, Label: "60 - 70"},
+		{Value: 70, Color: color.NRGBA{102, 194, 164, 255}, Label: "70 - 80"},
+		{Value: 80, Color: color.NRGBA{65, 174, 118, 255}, Label: "80 - 90"},
+		{Value: 90, Color: color.NRGBA{35, 139, 69, 255}, Label: "90 - 95"},
+		{Value: 95, Color: color.NRGBA{0, 88, 36, 255}, Label: "95 - 100"},
+	},
+}
+
+// WindSpeed is a provisional palette for wind_speed_at_10m, which has not been checked
+// against the DataHub style. Wind speeds are decoded from the colours, so the style's
+// own colours should be supplied with the download.legends config. Each stop's value is
+// the middle of its band, so decoded speeds are unbiased
+var WindSpeed = &Legend{
+	Title: "Wind speed",
+	Units: "m/s",
+	Stops: []Stop{
+		{Value: 1, Color: color.NRGBA{222, 235, 247, 255}, Label: "0 - 2"},
+		{Value: 3, Color: color.NRGBA{158, 202, 225, 255}, Label: "2 - 4"},
+		{Value: 5, Color: color.NRGBA{107, 174, 214, 255}, Label: "4 - 6"},
+		{Value: 7, Color: color.NRGBA{65, 171, 93, 255}, Label: "6 - 8"},
+		{Value: 9.5, Color: color.NRGBA{173, 221, 142, 255}, Label: "8 - 11"},
+		{Value: 12.5, Color: color.NRGBA{254, 227, 145, 255}, Label: "11 - 14"},
+		{Value: 15.5, Color: color.NRGBA{254, 153, 41, 255}, Label: "14 - 17"},
+		{Value: 19, Color: color.NRGBA{236, 112, 20, 255}, Label: "17 - 21"},
+		{Value: 23, Color: color.NRGBA{204, 76, 2, 255}, Label: "21 - 25"},
+		{Value: 28, Color: color.NRGBA{153, 52, 4, 255}, Label: "> 25"},
+	},
+}
+
+// WindDirection is a provisional cyclic palette for wind_direction_at_10m, with one
+// stop per 16-point compass sector, which has not been checked against the DataHub
+// style either. Values are the direction the wind blows from, in degrees clockwise
+// from north
+var WindDirection = &Legend{
+	Title: "Wind direction",
+	Units: "°",
+	Stops: compassStops(),
+}
+
+func compassStops() []Stop {
+	points := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	stops := make([]Stop, len(points))
+	for i, point := range points {
+		stops[i] = Stop{
+			Value: float64(i) * 22.5,
+			Color: hueColor(float64(i) * 22.5),
+			Label: point,
+		}
+	}
+	return stops
+}
+
+// hueColor returns a fully saturated colour for the hue angle (degrees)
+func hueColor(hue float64) color.NRGBA {
+	h := hue / 60
+	x := uint8(255 * (1 - math.Abs(math.Mod(h, 2)-1)))
+	switch int(h) % 6 {
+	case 0:
+		return color.NRGBA{255, x, 0, 255}
+	case 1:
+		return color.NRGBA{x, 255, 0, 255}
+	case 2:
+		return color.NRGBA{0, 255, x, 255}
+	case 3:
+		return color.NRGBA{0, x, 255, 255}
+	case 4:
+		return color.NRGBA{x, 0, 255, 255}
+	default:
+		return color.NRGBA{255, 0, x, 255}
+	}
+}
@@ -0,0 +1,68 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileLock is an exclusive advisory lock on a file, which works across the replicas
+// sharing a volume. The operating system releases it when the process exits, so it
+// needs no expiry
+type FileLock struct {
+	mu    sync.Mutex
+	path  string
+	owner string
+	f     *os.File
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path, owner: newOwner()}
+}
+
+// Acquire takes the lock without waiting. The ttl is ignored, as the lock is held
+// until released or the process exits
+func (l *FileLock) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err := tryLock(f); err != nil {
+		_ = f.Close()
+		if errors.Is(err, errLocked) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to lock %s: %w", l.path, err)
+	}
+
+	// Record the holder, for the benefit of anyone wondering which replica it is
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(l.owner+"\n"), 0)
+	}
+	l.f = f
+	return true, nil
+}
+
+func (l *FileLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := errors.Join(unlock(l.f), l.f.Close())
+	l.f = nil
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+var errLocked = errors.New("locked by another process")
+
+func tryLock(*os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
+
+func unlock(*os.File) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// Locker is a lease held by at most one instance at a time, so that when the API
+// server is scaled out only one replica runs the scheduled jobs
+type Locker interface {
+	// Acquire takes the lock, or renews it when already held, until ttl from now, and
+	// reports whether this instance holds it
+	Acquire(ctx context.Context, ttl time.Duration) (bool, error)
+	// Release gives up the lock, if held, so another instance can take it at once
+	Release(ctx context.Context) error
+}
+
+const (
+	BackendNone  = "none"
+	BackendFile  = "file"
+	BackendRedis = "redis"
+)
+
+// defaultFilename is the lock file kept in the root directory. As a dotfile, it is not
+// listed as a frame
+const defaultFilename = ".cron.lock"
+
+// New returns the lock selected by the config, or nil when every instance runs the
+// jobs. The file lock defaults to a file in rootDir
+func New(cfg config.LockConfig, rootDir string) (Locker, error) {
+	switch cfg.Backend {
+	case "", BackendNone:
+		return nil, nil
+	case BackendFile:
+		path := cfg.File
+		if path == "" {
+			path = filepath.Join(rootDir, defaultFilename)
+		}
+		return NewFileLock(path), nil
+	case BackendRedis:
+		return NewRedisLock(cfg.Redis)
+	default:
+		return nil, fmt.Errorf("unknown lock backend: %s (expected %s, %s or %s)", cfg.Backend, BackendNone, BackendFile, BackendRedis)
+	}
+}
+
+// newOwner identifies this instance as the holder of a lock
+func newOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
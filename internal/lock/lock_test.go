@@ -0,0 +1,115 @@
+package lock_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisLock(t *testing.T, server *miniredis.Miniredis) *lock.RedisLock {
+	l, err := lock.NewRedisLock(config.RedisConfig{URL: "redis://" + server.Addr() + "?max_retries=-1"})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return l
+}
+
+func TestLockers(t *testing.T) {
+	lockers := map[string]func(t *testing.T) (lock.Locker, lock.Locker){
+		"file": func(t *testing.T) (lock.Locker, lock.Locker) {
+			path := filepath.Join(t.TempDir(), "cron.lock")
+			return lock.NewFileLock(path), lock.NewFileLock(path)
+		},
+		"redis": func(t *testing.T) (lock.Locker, lock.Locker) {
+			server := miniredis.RunT(t)
+			return newRedisLock(t, server), newRedisLock(t, server)
+		},
+	}
+
+	for name, newLockers := range lockers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			a, b := newLockers(t)
+
+			acquired, err := a.Acquire(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+
+			acquired, err = b.Acquire(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.False(t, acquired, "held by another instance")
+
+			acquired, err = a.Acquire(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, acquired, "renewed by its holder")
+
+			// Only the holder can release the lock
+			require.NoError(t, b.Release(ctx))
+			acquired, err = b.Acquire(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.False(t, acquired)
+
+			require.NoError(t, a.Release(ctx))
+			acquired, err = b.Acquire(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+		})
+	}
+}
+
+func TestRedisLock_Expiry(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	a, b := newRedisLock(t, server), newRedisLock(t, server)
+
+	acquired, err := a.Acquire(ctx, 30*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Renewing extends the lease
+	server.FastForward(20 * time.Second)
+	acquired, err = a.Acquire(ctx, 30*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	server.FastForward(20 * time.Second)
+	acquired, err = b.Acquire(ctx, 30*time.Second)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	// A lease that is not renewed is taken over
+	server.FastForward(time.Minute)
+	acquired, err = b.Acquire(ctx, 30*time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = a.Acquire(ctx, 30*time.Second)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	server.Close()
+	_, err = b.Acquire(ctx, 30*time.Second)
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	l, err := lock.New(config.LockConfig{}, t.TempDir())
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	l, err = lock.New(config.LockConfig{Backend: lock.BackendFile}, t.TempDir())
+	require.NoError(t, err)
+	assert.IsType(t, &lock.FileLock{}, l)
+
+	_, err = lock.New(config.LockConfig{Backend: lock.BackendRedis}, t.TempDir())
+	assert.ErrorContains(t, err, "requires a URL")
+
+	_, err = lock.New(config.LockConfig{Backend: "etcd"}, t.TempDir())
+	assert.ErrorContains(t, err, "unknown lock backend")
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// defaultRedisKey is the key of the lock when none is configured
+const defaultRedisKey = "metoffice-uk-weather-overlays:cron"
+
+// The lock is only renewed or released by its owner
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisLock is a lease on a Redis key holding the name of its owner, which expires
+// unless renewed
+type RedisLock struct {
+	client *redis.Client
+	key    string
+	owner  string
+}
+
+func NewRedisLock(cfg config.RedisConfig) (*RedisLock, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("redis lock requires a URL")
+	}
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	key := cfg.Key
+	if key == "" {
+		key = defaultRedisKey
+	}
+	return &RedisLock{client: redis.NewClient(opts), key: key, owner: newOwner()}, nil
+}
+
+func (l *RedisLock) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(ctx, l.client, []string{l.key}, l.owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock %s: %w", l.key, err)
+	}
+	if renewed == 1 {
+		return true, nil
+	}
+
+	acquired, err := l.client.SetNX(ctx, l.key, l.owner, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %s: %w", l.key, err)
+	}
+	return acquired, nil
+}
+
+func (l *RedisLock) Release(ctx context.Context) error {
+	if err := releaseScript.Run(ctx, l.client, []string{l.key}, l.owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.key, err)
+	}
+	return nil
+}
+
+// Close releases the lock and disconnects from Redis
+func (l *RedisLock) Close() error {
+	return errors.Join(l.Release(context.Background()), l.client.Close())
+}
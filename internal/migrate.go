@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// MigrationReport summarises the conversion of a tree to the valid time layout
+type MigrationReport struct {
+	Frames     int `json:"frames"`
+	Files      int `json:"files"`
+	Superseded int `json:"superseded"`
+	Removed    int `json:"removed"`
+}
+
+// legacyFrame is a frame stored in the run layout, with the suffixes of all its files
+type legacyFrame struct {
+	id       metoffice.FileID
+	dir      string
+	suffixes []string
+}
+
+// MigrateToValidTimeLayout copies every frame stored in the run layout of a local
+// frame store to the valid time layout, hardlinking files where possible. Where several
+// runs cover the same valid time, the most recent run is kept. When removeSource is
+// set, the run layout files are deleted once migrated, and when dryRun is set nothing
+// is changed
+func MigrateToValidTimeLayout(ctx context.Context, frames *store.FileStore, removeSource, dryRun bool) (MigrationReport, error) {
+	legacy, err := findLegacyFrames(frames.Root())
+	if err != nil {
+		return MigrationReport{}, err
+	}
+
+	// Oldest runs first, so that newer runs replace them
+	sort.SliceStable(legacy, func(i, j int) bool {
+		return legacy[i].id.RunTime().Before(legacy[j].id.RunTime())
+	})
+
+	var report MigrationReport
+	for _, frame := range legacy {
+		dir, hour := ValidTimeLayout.Dir(frame.id)
+		cmp, err := ValidTimeLayout.compareStoredRun(ctx, frames, dir, hour, frame.id.RunTime())
+		if err != nil {
+			return report, err
+		}
+
+		if cmp > 0 {
+			report.Superseded++
+		} else {
+			report.Frames++
+			report.Files += len(frame.suffixes)
+			if !dryRun {
+				if err := migrateFrame(ctx, frames, frame, dir, hour); err != nil {
+					return report, fmt.Errorf("failed to migrate %s: %w", frame.id.Path(), err)
+				}
+			}
+		}
+
+		if removeSource {
+			for _, suffix := range frame.suffixes {
+				report.Removed++
+				if dryRun {
+					continue
+				}
+				if err := os.Remove(legacyFilename(frame, suffix)); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+func findLegacyFrames(rootDir string) ([]*legacyFrame, error) {
+	frames := make(map[string]*legacyFrame)
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == validTimePrefix {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		id, suffix, err := metoffice.ParsePath(filepath.ToSlash(rel))
+		if err != nil || !frameSuffixRegexp.MatchString(suffix) {
+			return nil
+		}
+
+		key := id.Path()
+		frame, ok := frames[key]
+		if !ok {
+			frame = &legacyFrame{id: id, dir: filepath.Dir(path)}
+			frames[key] = frame
+		}
+		frame.suffixes = append(frame.suffixes, suffix)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", rootDir, err)
+	}
+
+	result := make([]*legacyFrame, 0, len(frames))
+	for _, frame := range frames {
+		result = append(result, frame)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id.Path() < result[j].id.Path()
+	})
+	return result, nil
+}
+
+func legacyFilename(frame *legacyFrame, suffix string) string {
+	return fmt.Sprintf("%s/%02d%s", frame.dir, frame.id.Timestep, suffix)
+}
+
+func migrateFrame(ctx context.Context, frames *store.FileStore, frame *legacyFrame, dir string, hour int) error {
+	if err := os.MkdirAll(frames.Path(dir), 0755); err != nil {
+		return err
+	}
+	for _, suffix := range frame.suffixes {
+		key := fmt.Sprintf("%s/%02d%s", dir, hour, suffix)
+		if err := linkOrCopy(ctx, frames, legacyFilename(frame, suffix), key); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrated %s to %s", frame.id.Path(), ValidTimePath(frame.id.Kind, frame.id.ValidTime()))
+	return writeFrameMetadata(ctx, frames, dir, hour, newFrameMetadata(frame.id))
+}
+
+// linkOrCopy replaces the key with a hardlink to src, falling back to a copy when the
+// two are on different filesystems
+func linkOrCopy(ctx context.Context, frames *store.FileStore, src, key string) error {
+	dst := frames.Path(key)
+	tmp := dst + ".migrate.tmp"
+	_ = os.Remove(tmp)
+	if err := os.Link(src, tmp); err == nil {
+		return os.Rename(tmp, dst)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return frames.Put(ctx, key, f, info.Size(), store.PutOptions{})
+}
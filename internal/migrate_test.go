@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestMigrateToValidTimeLayout(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	frames := store.NewFileStore(root)
+
+	// 2025-09-15 02:00 is covered by both the 14th (ts26) and the 15th (ts2) runs
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/14/26.webp"), "old")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/14/26.thumb.webp"), "old thumb")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/15/02.webp"), "new")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/15/02.thumb.webp"), "new thumb")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/15/notes.txt"), "ignored")
+
+	report, err := MigrateToValidTimeLayout(ctx, frames, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Frames: 2, Files: 4}, report)
+	assert.NoDirExists(t, filepath.Join(root, "valid"))
+
+	report, err = MigrateToValidTimeLayout(ctx, frames, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Frames: 2, Files: 4, Removed: 4}, report)
+
+	dir := filepath.Join(root, "valid/cloud_amount_total/2025/09/15")
+	data, err := os.ReadFile(filepath.Join(dir, "02.webp"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	data, err = os.ReadFile(filepath.Join(dir, "02.thumb.webp"))
+	require.NoError(t, err)
+	assert.Equal(t, "new thumb", string(data))
+
+	meta, err := ReadFrameMetadata(ctx, frames, "valid/cloud_amount_total/2025/09/15", 2)
+	require.NoError(t, err)
+	require.NotNil(t, meta)
+	assert.Equal(t, time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC), meta.RunTime)
+	assert.Equal(t, 2, meta.Timestep)
+
+	assert.NoFileExists(t, filepath.Join(root, "cloud_amount_total/2025/09/14/26.webp"))
+	assert.FileExists(t, filepath.Join(root, "cloud_amount_total/2025/09/15/notes.txt"))
+
+	// Migrating an older run afterwards leaves the newer frame in place
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/13/50.webp"), "older")
+	report, err = MigrateToValidTimeLayout(ctx, frames, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationReport{Superseded: 1}, report)
+	data, err = os.ReadFile(filepath.Join(dir, "02.webp"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+}
+
+func TestParseValidTimePath(t *testing.T) {
+	kind, validTime, suffix, err := ParseValidTimePath("valid/wind_barbs/2025/09/15/02.medium.webp")
+	require.NoError(t, err)
+	assert.Equal(t, "wind_barbs", kind)
+	assert.Equal(t, time.Date(2025, 9, 15, 2, 0, 0, 0, time.UTC), validTime)
+	assert.Equal(t, ".medium.webp", suffix)
+	assert.Equal(t, "valid/wind_barbs/2025/09/15/02", ValidTimePath(kind, validTime))
+
+	_, _, _, err = ParseValidTimePath("valid/wind_barbs/2025/09/15/26.webp")
+	assert.Error(t, err)
+	_, _, _, err = ParseValidTimePath("wind_barbs/2025/09/15/02.webp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package metoffice
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxTimestep is the furthest ahead, in hours from the run time, that frames are published
+const MaxTimestep = 72
+
+var ErrInvalidFileID = errors.New("invalid fileId")
+
+var ErrInvalidPath = errors.New("invalid frame path")
+
+// FileID identifies a single frame in an order, e.g. total_precipitation_rate_ts6_2025091400
+// is the precipitation rate 6 hours after the 00Z run on 2025-09-14. The relative form,
+// e.g. total_precipitation_rate_ts6_+00, names the latest 00Z run without giving its date
+type FileID struct {
+	Kind     string
+	Timestep int
+	// RunDate is midnight UTC on the day of the model run, which is zero for a relative
+	// fileId until resolved against the runDateTime of its file
+	RunDate  time.Time
+	RunHour  int
+	Relative bool
+}
+
+func invalidFileID(s, format string, args ...any) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidFileID, s, fmt.Sprintf(format, args...))
+}
+
+// ParseFileID parses a fileId of the form {kind}_ts{N}_{YYYYMMDDHH} or {kind}_ts{N}_+{HH}
+func ParseFileID(s string) (FileID, error) {
+	idx := strings.LastIndex(s, "_ts")
+	if idx < 0 {
+		return FileID{}, invalidFileID(s, "missing _ts timestep")
+	}
+	if idx == 0 {
+		return FileID{}, invalidFileID(s, "missing kind")
+	}
+
+	timestep, runTime, ok := strings.Cut(s[idx+len("_ts"):], "_")
+	if !ok {
+		return FileID{}, invalidFileID(s, "missing run time")
+	}
+
+	id := FileID{Kind: s[:idx]}
+	if !isDigits(timestep) || len(timestep) > 2 {
+		return FileID{}, invalidFileID(s, "timestep %q is not a number between 0 and 99", timestep)
+	}
+	id.Timestep, _ = strconv.Atoi(timestep)
+
+	switch {
+	case strings.HasPrefix(runTime, "+"):
+		hour := runTime[1:]
+		if len(hour) != 2 || !isDigits(hour) {
+			return FileID{}, invalidFileID(s, "run hour %q is not two digits", hour)
+		}
+		id.RunHour, _ = strconv.Atoi(hour)
+		if id.RunHour > 23 {
+			return FileID{}, invalidFileID(s, "run hour %d out of range (0-23)", id.RunHour)
+		}
+		id.Relative = true
+
+	case len(runTime) == 10 && isDigits(runTime):
+		t, err := time.Parse("2006010215", runTime)
+		if err != nil {
+			return FileID{}, invalidFileID(s, "run time %q is not a valid date and hour", runTime)
+		}
+		id.RunDate = t.Truncate(24 * time.Hour)
+		id.RunHour = t.Hour()
+
+	default:
+		return FileID{}, invalidFileID(s, "run time %q is neither YYYYMMDDHH nor +HH", runTime)
+	}
+
+	return id, nil
+}
+
+// ID parses the fileId of the file, taking the run date of a relative fileId from the
+// file's runDateTime
+func (f File) ID() (FileID, error) {
+	id, err := ParseFileID(f.FileId)
+	if err != nil || !id.Relative {
+		return id, err
+	}
+	if f.RunDateTime.IsZero() {
+		return FileID{}, invalidFileID(f.FileId, "relative fileId without a runDateTime")
+	}
+
+	runDateTime := f.RunDateTime.UTC()
+	if runDateTime.Hour() != id.RunHour {
+		return FileID{}, invalidFileID(f.FileId, "run hour does not match runDateTime %s", f.RunDateTime.Format(time.RFC3339))
+	}
+	id.RunDate = runDateTime.Truncate(24 * time.Hour)
+	return id, nil
+}
+
+// RunTime is the time of the model run that produced the frame
+func (id FileID) RunTime() time.Time {
+	return id.RunDate.Add(time.Duration(id.RunHour) * time.Hour)
+}
+
+// ValidTime is the time the frame forecasts
+func (id FileID) ValidTime() time.Time {
+	return id.RunTime().Add(time.Duration(id.Timestep) * time.Hour)
+}
+
+// String formats the fileId in the same form it was parsed from
+func (id FileID) String() string {
+	if id.Relative {
+		return fmt.Sprintf("%s_ts%d_+%02d", id.Kind, id.Timestep, id.RunHour)
+	}
+	return fmt.Sprintf("%s_ts%d_%s", id.Kind, id.Timestep, id.RunTime().Format("2006010215"))
+}
+
+// Dir is the directory, relative to the storage root, holding the frames of the kind
+// produced by the run: {kind}/YYYY/MM/DD for the 00Z run, which keeps the paths used
+// before other runs were stored, and {kind}/YYYY/MM/DD/{HH}Z for the others
+func (id FileID) Dir() string {
+	dir := path.Join(id.Kind, id.RunDate.Format("2006/01/02"))
+	if id.RunHour != 0 {
+		dir = fmt.Sprintf("%s/%02dZ", dir, id.RunHour)
+	}
+	return dir
+}
+
+// Path is the location of the frame relative to the storage root, without any variant
+// or extension suffix: {kind}/YYYY/MM/DD/{timestep}, or {kind}/YYYY/MM/DD/{HH}Z/{timestep}
+// for runs other than 00Z
+func (id FileID) Path() string {
+	return fmt.Sprintf("%s/%02d", id.Dir(), id.Timestep)
+}
+
+// ParsePath is the inverse of Path, returning the frame's id along with the remainder
+// of the filename after the timestep, e.g. ".medium.webp"
+func ParsePath(p string) (FileID, string, error) {
+	parts := strings.Split(p, "/")
+	runHour := 0
+	if len(parts) == 6 {
+		hour, ok := strings.CutSuffix(parts[4], "Z")
+		if !ok || len(hour) != 2 || !isDigits(hour) {
+			return FileID{}, "", fmt.Errorf("%w %q: invalid run hour", ErrInvalidPath, p)
+		}
+		runHour, _ = strconv.Atoi(hour)
+		if runHour == 0 || runHour > 23 {
+			return FileID{}, "", fmt.Errorf("%w %q: run hour out of range (1-23)", ErrInvalidPath, p)
+		}
+		parts = append(parts[:4], parts[5])
+	}
+	if len(parts) != 5 || parts[0] == "" {
+		return FileID{}, "", fmt.Errorf("%w %q: expected {kind}/YYYY/MM/DD/HH or {kind}/YYYY/MM/DD/{HH}Z/HH", ErrInvalidPath, p)
+	}
+
+	date, err := time.Parse("2006/01/02", strings.Join(parts[1:4], "/"))
+	if err != nil || len(parts[1]) != 4 || len(parts[2]) != 2 || len(parts[3]) != 2 {
+		return FileID{}, "", fmt.Errorf("%w %q: invalid date", ErrInvalidPath, p)
+	}
+
+	timestep, suffix := parts[4], ""
+	if idx := strings.Index(timestep, "."); idx >= 0 {
+		timestep, suffix = timestep[:idx], timestep[idx:]
+	}
+	if len(timestep) != 2 || !isDigits(timestep) {
+		return FileID{}, "", fmt.Errorf("%w %q: invalid timestep", ErrInvalidPath, p)
+	}
+
+	id := FileID{Kind: parts[0], RunDate: date, RunHour: runHour}
+	id.Timestep, _ = strconv.Atoi(timestep)
+	return id, suffix, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package metoffice_test
+
+import (
+	"testing"
+	"time"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFileID(t *testing.T) {
+	tests := []struct {
+		fileId    string
+		expected  metoffice.FileID
+		validTime time.Time
+	}{
+		{
+			fileId: "total_precipitation_rate_ts6_2025091400",
+			expected: metoffice.FileID{
+				Kind:     "total_precipitation_rate",
+				Timestep: 6,
+				RunDate:  time.Date(2025, 9, 14, 0, 0, 0, 0, time.UTC),
+			},
+			validTime: time.Date(2025, 9, 14, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			fileId: "cloud_amount_total_ts23_2025123112",
+			expected: metoffice.FileID{
+				Kind:     "cloud_amount_total",
+				Timestep: 23,
+				RunDate:  time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+				RunHour:  12,
+			},
+			validTime: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			fileId: "wind_ts_ts0_+00",
+			expected: metoffice.FileID{
+				Kind:     "wind_ts",
+				Timestep: 0,
+				Relative: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fileId, func(t *testing.T) {
+			id, err := metoffice.ParseFileID(tt.fileId)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, id)
+			assert.Equal(t, tt.fileId, id.String())
+			if !tt.validTime.IsZero() {
+				assert.Equal(t, tt.validTime, id.ValidTime())
+			}
+		})
+	}
+}
+
+func TestParseFileID_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"total_precipitation_rate",
+		"_ts6_2025091400",
+		"total_precipitation_rate_ts6",
+		"total_precipitation_rate_tsX_2025091400",
+		"total_precipitation_rate_ts100_2025091400",
+		"total_precipitation_rate_ts6_20250914",
+		"total_precipitation_rate_ts6_2025023000",
+		"total_precipitation_rate_ts6_2025091424",
+		"total_precipitation_rate_ts6_+0",
+		"total_precipitation_rate_ts6_+24",
+		"total_precipitation_rate_ts6_-00",
+	}
+
+	for _, fileId := range tests {
+		t.Run(fileId, func(t *testing.T) {
+			_, err := metoffice.ParseFileID(fileId)
+			assert.ErrorIs(t, err, metoffice.ErrInvalidFileID)
+		})
+	}
+}
+
+func TestFile_ID(t *testing.T) {
+	file := metoffice.File{
+		FileId:      "cloud_amount_total_ts3_+00",
+		RunDateTime: time.Date(2025, 9, 14, 0, 0, 0, 0, time.UTC),
+	}
+	id, err := file.ID()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 9, 14, 3, 0, 0, 0, time.UTC), id.ValidTime())
+	assert.Equal(t, "cloud_amount_total/2025/09/14/03", id.Path())
+
+	file.RunDateTime = time.Date(2025, 9, 14, 6, 0, 0, 0, time.UTC)
+	_, err = file.ID()
+	assert.ErrorIs(t, err, metoffice.ErrInvalidFileID)
+}
+
+func TestParsePath(t *testing.T) {
+	id, suffix, err := metoffice.ParsePath("total_precipitation_rate/2025/09/14/06.medium.webp")
+	require.NoError(t, err)
+	assert.Equal(t, "total_precipitation_rate", id.Kind)
+	assert.Equal(t, 6, id.Timestep)
+	assert.Equal(t, time.Date(2025, 9, 14, 0, 0, 0, 0, time.UTC), id.RunDate)
+	assert.Equal(t, ".medium.webp", suffix)
+
+	for _, p := range []string{
+		"", "kind/2025/09/14", "kind/2025/9/14/06.webp", "kind/2025/09/14/6.webp", "/2025/09/14/06.webp",
+		"kind/2025/09/14/12/06.webp", "kind/2025/09/14/00Z/06.webp", "kind/2025/09/14/24Z/06.webp", "kind/2025/09/14/12Z/06/extra",
+	} {
+		_, _, err := metoffice.ParsePath(p)
+		assert.ErrorIs(t, err, metoffice.ErrInvalidPath, p)
+	}
+}
+
+func TestPath_RunHour(t *testing.T) {
+	// Frames from runs other than 00Z must not overwrite those of the 00Z run
+	midnight, err := metoffice.ParseFileID("total_precipitation_rate_ts6_2025091400")
+	require.NoError(t, err)
+	noon, err := metoffice.ParseFileID("total_precipitation_rate_ts6_2025091412")
+	require.NoError(t, err)
+
+	assert.Equal(t, "total_precipitation_rate/2025/09/14", midnight.Dir())
+	assert.Equal(t, "total_precipitation_rate/2025/09/14/06", midnight.Path())
+	assert.Equal(t, "total_precipitation_rate/2025/09/14/12Z", noon.Dir())
+	assert.Equal(t, "total_precipitation_rate/2025/09/14/12Z/06", noon.Path())
+
+	for _, id := range []metoffice.FileID{midnight, noon} {
+		parsed, suffix, err := metoffice.ParsePath(id.Path() + ".thumb.webp")
+		require.NoError(t, err, id)
+		assert.Equal(t, id, parsed)
+		assert.Equal(t, ".thumb.webp", suffix)
+		assert.Equal(t, id.ValidTime(), parsed.ValidTime())
+	}
+}
+
+func FuzzParseFileID(f *testing.F) {
+	f.Add("total_precipitation_rate_ts6_2025091400")
+	f.Add("cloud_amount_total_ts0_+00")
+	f.Add("temperature_at_surface_ts72_2024022912")
+	f.Add("_ts_")
+
+	f.Fuzz(func(t *testing.T, fileId string) {
+		id, err := metoffice.ParseFileID(fileId)
+		if err != nil {
+			return
+		}
+		if id.Kind == "" || id.Timestep < 0 || id.RunHour < 0 || id.RunHour > 23 {
+			t.Fatalf("%q parsed to invalid %+v", fileId, id)
+		}
+
+		reparsed, err := metoffice.ParseFileID(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, id, reparsed)
+
+		if !id.Relative {
+			parsed, suffix, err := metoffice.ParsePath(id.Path() + ".webp")
+			if err == nil {
+				assert.Equal(t, ".webp", suffix)
+				assert.Equal(t, id.Timestep, parsed.Timestep)
+				assert.Equal(t, id.RunDate, parsed.RunDate)
+				assert.Equal(t, id.RunHour, parsed.RunHour)
+			}
+		}
+	})
+}
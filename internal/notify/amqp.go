@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// AMQP publishes each event to an exchange, with the event type as the routing key.
+// As events are rare, each one is published over a connection of its own, waiting
+// for the broker to confirm it
+type AMQP struct {
+	url      string
+	exchange string
+}
+
+func NewAMQP(cfg config.AMQPConfig) *AMQP {
+	return &AMQP{url: cfg.URL, exchange: cfg.Exchange}
+}
+
+func (a *AMQP) Name() string {
+	return fmt.Sprintf("AMQP exchange %q", a.exchange)
+}
+
+func (a *AMQP) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return permanent(err)
+	}
+
+	conn, err := amqp.Dial(a.url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to broker: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, a.exchange, string(event.Type), false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    event.ID,
+		Timestamp:    event.Time,
+		Type:         string(event.Type),
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("broker did not accept the event")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAMQP publishes to a local broker, such as one started with
+// docker run -p 5672:5672 rabbitmq, given by AMQP_TEST_URL
+func TestAMQP(t *testing.T) {
+	url := os.Getenv("AMQP_TEST_URL")
+	if url == "" {
+		t.Skip("AMQP_TEST_URL not set")
+	}
+
+	conn, err := amqp.Dial(url)
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	require.NoError(t, ch.ExchangeDeclare("metoffice-test", "topic", false, true, false, false, nil))
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, ch.QueueBind(queue.Name, "run.*", "metoffice-test", false, nil))
+	deliveries, err := ch.Consume(queue.Name, "", true, true, false, false, nil)
+	require.NoError(t, err)
+
+	sink := NewAMQP(config.AMQPConfig{URL: url, Exchange: "metoffice-test"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, sink.Send(ctx, testEvent()))
+
+	select {
+	case delivery := <-deliveries:
+		assert.Equal(t, "run.published", delivery.RoutingKey)
+		assert.Equal(t, testEvent().ID, delivery.MessageId)
+		var event Event
+		require.NoError(t, json.Unmarshal(delivery.Body, &event))
+		assert.Equal(t, testEvent(), event)
+	case <-ctx.Done():
+		t.Fatal("event not delivered")
+	}
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+type EventType string
+
+const (
+	// RunPublished is sent when a run published new frames without any failing
+	RunPublished EventType = "run.published"
+	// RunPartial is sent when a run published new frames, but others failed or the
+	// run was cancelled
+	RunPartial EventType = "run.partial"
+	// RunFailed is sent when a run failed without publishing any new frames, including
+	// one that failed to start
+	RunFailed EventType = "run.failed"
+)
+
+// Event describes the outcome of a download run for downstream services
+type Event struct {
+	// ID is unique to the event, so that receivers can ignore a retried delivery
+	ID          string     `json:"id"`
+	Type        EventType  `json:"type"`
+	Time        time.Time  `json:"time"`
+	RunID       string     `json:"runId"`
+	Trigger     string     `json:"trigger"`
+	Status      string     `json:"status"`
+	RunDateTime *time.Time `json:"runDateTime,omitempty"`
+	// Error is why the run failed, if it did
+	Error   string  `json:"error,omitempty"`
+	Summary Summary `json:"summary"`
+}
+
+// Summary is the manifest of what a run published
+type Summary struct {
+	// Published is the number of new frames of each kind
+	Published map[string]int `json:"published"`
+	Succeeded int            `json:"succeeded"`
+	Skipped   int            `json:"skipped"`
+	Failed    int            `json:"failed"`
+	Bytes     int64          `json:"bytes"`
+	// Failures maps the fileId of each failed file to the reason
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// Sink delivers events to downstream services
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// permanentError marks a failure that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// sendTimeout bounds each attempt to send an event
+const sendTimeout = 30 * time.Second
+
+// Notifier sends each event to every sink in the background, retrying failed
+// attempts with an exponential backoff
+type Notifier struct {
+	sinks       []Sink
+	maxAttempts int
+	delay       time.Duration
+	wg          sync.WaitGroup
+}
+
+// New returns a notifier for the sinks in the config, or nil when there are none
+func New(cfg config.NotifyConfig) (*Notifier, error) {
+	sinks := make([]Sink, 0, len(cfg.Webhooks)+1)
+	for _, webhook := range cfg.Webhooks {
+		if webhook.Secret == "" {
+			webhook.Secret = os.Getenv("NOTIFY_WEBHOOK_SECRET")
+		}
+		sink, err := NewWebhook(webhook)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	amqp := cfg.AMQP
+	if amqp.URL == "" {
+		amqp.URL = os.Getenv("NOTIFY_AMQP_URL")
+	}
+	if amqp.URL != "" {
+		sinks = append(sinks, NewAMQP(amqp))
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return NewNotifier(cfg.MaxAttempts, sinks...), nil
+}
+
+func NewNotifier(maxAttempts int, sinks ...Sink) *Notifier {
+	return &Notifier{sinks: sinks, maxAttempts: max(maxAttempts, 1), delay: time.Second}
+}
+
+// Notify sends the event to every sink without waiting for it to be delivered
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	for _, sink := range n.sinks {
+		n.wg.Go(func() {
+			if err := n.send(sink, event); err != nil {
+				log.Printf("Failed to send %s event %s to %s: %v", event.Type, event.ID, sink.Name(), err)
+			}
+		})
+	}
+}
+
+// Wait blocks until every event has been delivered, or given up on
+func (n *Notifier) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+func (n *Notifier) send(sink Sink, event Event) error {
+	delay := n.delay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := sink.Send(ctx, event)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= n.maxAttempts {
+			return fmt.Errorf("attempt %d: %w", attempt, err)
+		}
+		log.Printf("Attempt %d to send %s event %s to %s failed, retrying in %s: %v",
+			attempt, event.Type, event.ID, sink.Name(), delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing/encoder"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing/stage"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/legend"
+)
+
+// Overlay describes how the files for one kind of DataHub map image are requested,
+// processed and encoded
+type Overlay struct {
+	StyleName string
+	Pipeline  []imageprocessing.PipelineStage
+	Encoder   imageprocessing.Encoder
+	Composite *stage.CompositeStage
+	Legend    *legend.Legend
+	// LegendLoaded is set when the legend was read from a file by LoadLegends, rather
+	// than being built in
+	LegendLoaded bool
+	// Derived overlays are rendered from other overlays rather than downloaded
+	Derived bool
+}
+
+// Composites are written alongside the overlay under {kind}_composite
+const compositeSuffix = "_composite"
+
+// The basemap (land/sea) and optional coastline PNGs used to produce composite images,
+// found in the configured basemap directory. They must share the projection and extent
+// of the DataHub map images
+const (
+	basemapFile   = "basemap.png"
+	coastlineFile = "coastline.png"
+)
+
+var (
+	// Frames were always lossy WebP, which the other downloaded overlays keep
+	defaultEncoder = &encoder.WebPEncoder{Quality: 80}
+	// Lossy WebP leaves halos around the soft transparent edges of cloud, which lossless
+	// WebP avoids while keeping the .webp extension, and so the URLs, of its frames
+	cloudEncoder = &encoder.WebPEncoder{Lossless: true, Exact: true}
+	// Composites are opaque, so there is no alpha to preserve
+	compositeEncoder = &encoder.WebPEncoder{Quality: 85}
+)
+
+// Overlays maps each supported data type (the kind prefix of a DataHub fileId) to
+// its overlay definition
+var Overlays = map[string]Overlay{
+	"total_precipitation_rate": {
+		Pipeline: []imageprocessing.PipelineStage{
+			&stage.ReplaceColorStage{Tolerance: 50, Replace: color.White},
+			// Drop single-pixel speckles before the blur turns them into faint smudges
+			&stage.ConnectedComponentFilterStage{MinArea: 4, EightConnected: true},
+			&stage.GaussianBlurStage{Sigma: 1.0},
+			&stage.ResampleStage{},
+		},
+		Encoder: defaultEncoder,
+		Legend:  legend.PrecipitationRate,
+		Composite: &stage.CompositeStage{
+			Basemap:   basemapFile,
+			Coastline: coastlineFile,
+			Title:     "Precipitation rate",
+		},
+	},
+	"cloud_amount_total": {
+		StyleName: "iso_fill_bu_gn_30_100_pc",
+		Pipeline: []imageprocessing.PipelineStage{
+			&stage.ReplaceColorStage{Tolerance: 50, Replace: color.White},
+			&stage.GreyscaleStage{},
+			&stage.GaussianBlurStage{Sigma: 1.0},
+			&stage.ResampleStage{},
+		},
+		Encoder: cloudEncoder,
+		Legend:  legend.CloudAmountBuGn,
+		Composite: &stage.CompositeStage{
+			Basemap:   basemapFile,
+			Coastline: coastlineFile,
+			Title:     "Total cloud cover",
+		},
+	},
+	// NoOp's
+	"mean_sea_level_pressure": {
+		Encoder: defaultEncoder,
+	},
+	"temperature_at_surface": {
+		Encoder: defaultEncoder,
+	},
+	windSpeedKind: {
+		Encoder: defaultEncoder,
+		Legend:  legend.WindSpeed,
+	},
+	windDirectionKind: {
+		Encoder: defaultEncoder,
+		Legend:  legend.WindDirection,
+	},
+	// Rendered from the wind speed and direction vector field rather than downloaded,
+	// see wind.go
+	"wind_arrows": {
+		Derived: true,
+		Pipeline: []imageprocessing.PipelineStage{
+			&stage.WindArrowStage{Color: color.NRGBA{32, 32, 32, 220}, Scale: 1.5, MaxLength: 22, Width: 1.5},
+		},
+		Encoder: &encoder.WebPEncoder{Lossless: true, Exact: true},
+	},
+	"wind_barbs": {
+		Derived: true,
+		Pipeline: []imageprocessing.PipelineStage{
+			&stage.WindBarbStage{Color: color.NRGBA{32, 32, 32, 220}, Length: 18, Width: 1.25},
+		},
+		Encoder: &encoder.WebPEncoder{Lossless: true, Exact: true},
+	},
+}
+
+func init() {
+	// Composites show the legend as it appears on the processed overlay
+	for _, overlay := range Overlays {
+		if overlay.Composite != nil && overlay.Composite.Legend == nil {
+			overlay.Composite.Legend = overlay.DisplayLegend()
+		}
+	}
+}
+
+// LoadLegends replaces the legends of the given overlays with those read from the
+// JSON files, so the colours match the DataHub styles. It must be called before any
+// downloads start
+func LoadLegends(paths map[string]string) error {
+	for kind, path := range paths {
+		overlay, ok := Overlays[kind]
+		if !ok {
+			return fmt.Errorf("legend given for unknown overlay: %s", kind)
+		}
+		l, err := legend.Load(path)
+		if err != nil {
+			return err
+		}
+		overlay.Legend = l
+		overlay.LegendLoaded = true
+		if overlay.Composite != nil {
+			overlay.Composite.Legend = overlay.DisplayLegend()
+		}
+		Overlays[kind] = overlay
+	}
+	return nil
+}
+
+// DisplayLegend returns the overlay's legend with its colours remapped by the
+// pipeline, so it matches the published images, or nil when it has no legend
+func (o Overlay) DisplayLegend() *legend.Legend {
+	if o.Legend == nil {
+		return nil
+	}
+	return o.Legend.Remap(o.Pipeline...)
+}
+
+// Encoders returns the distinct encoders used across all overlays
+func Encoders() []imageprocessing.Encoder {
+	seen := make(map[imageprocessing.Encoder]bool)
+	encoders := make([]imageprocessing.Encoder, 0, len(Overlays))
+	for _, overlay := range Overlays {
+		if overlay.Encoder == nil || seen[overlay.Encoder] {
+			continue
+		}
+		seen[overlay.Encoder] = true
+		encoders = append(encoders, overlay.Encoder)
+	}
+	return encoders
+}
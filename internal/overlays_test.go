@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/webp"
+)
+
+func TestCloudEncoder_KeepsEdgeAlpha(t *testing.T) {
+	// A soft cloud edge, fading from opaque grey to fully transparent
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 16))
+	for y := range 16 {
+		for x := range 64 {
+			grey := uint8(160 + x)
+			img.SetNRGBA(x, y, color.NRGBA{grey, grey, grey, uint8(255 - x*4)})
+		}
+	}
+
+	enc := Overlays["cloud_amount_total"].Encoder
+	assert.Equal(t, ".webp", enc.Extension(), "the frame URLs are unchanged")
+	var buf bytes.Buffer
+	require.NoError(t, enc.Encode(&buf, img))
+	decoded, err := webp.Decode(&buf)
+	require.NoError(t, err)
+
+	for y := range 16 {
+		for x := range 64 {
+			expected := img.NRGBAAt(x, y)
+			actual := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			require.Equal(t, expected, actual, "pixel (%d, %d)", x, y)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+)
+
+// What a download run would do with a file
+const (
+	PlanDownload = "download"
+	PlanSkip     = "skip"
+	PlanError    = "error"
+)
+
+// PlannedFile is what a download run would do with a file in the order
+type PlannedFile struct {
+	FileId    string    `json:"fileId"`
+	Kind      string    `json:"kind,omitempty"`
+	Timestep  int       `json:"timestep"`
+	ValidTime time.Time `json:"validTime,omitzero"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason,omitempty"`
+	Style     string    `json:"style,omitempty"`
+	// Paths are the store keys of the full size frames written, including any
+	// composite
+	Paths []string `json:"paths,omitempty"`
+	// Pipeline names the stages applied to the image, in order
+	Pipeline []string `json:"pipeline,omitempty"`
+}
+
+// Plan reports what a run would do with every file in the order, checking which
+// frames exist but without fetching any image data
+func (p *Processor) Plan() []PlannedFile {
+	p.applyLimit()
+	planned := make([]PlannedFile, 0, len(p.files))
+	for _, file := range p.files {
+		entry := PlannedFile{FileId: file.FileId, Action: PlanDownload}
+		plan, err := p.planFile(file)
+		if plan != nil {
+			entry.Kind = plan.id.Kind
+			entry.Timestep = plan.id.Timestep
+			entry.ValidTime = plan.id.ValidTime()
+			entry.Style = plan.overlay.StyleName
+			for _, out := range plan.outputs {
+				full := imageprocessing.Variant{Name: imageprocessing.FullVariant}
+				entry.Paths = append(entry.Paths, VariantFilename(out.dir, plan.hour, full, out.encoder.Extension()))
+			}
+			for _, stage := range plan.overlay.Pipeline {
+				entry.Pipeline = append(entry.Pipeline, imageprocessing.StageName(stage))
+			}
+		} else if id, idErr := file.ID(); idErr == nil {
+			entry.Kind = id.Kind
+			entry.Timestep = id.Timestep
+		}
+
+		var skip *skipError
+		switch {
+		case err == nil:
+		case errors.As(err, &skip):
+			entry.Action = PlanSkip
+			entry.Reason = skip.reason
+		default:
+			entry.Action = PlanError
+			entry.Reason = err.Error()
+		}
+		planned = append(planned, entry)
+	}
+	return planned
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_Plan(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500", "not-a-file-id")
+	client.started = make(chan string, 3)
+	runs, frames := testRunManager(t, client)
+
+	_, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+	require.Len(t, client.started, 2)
+	for range 2 {
+		<-client.started
+	}
+
+	client.files = append(client.files, newFakeDataHub("rain_ts2_2025091500").files...)
+	p, err := newDownloader(frames, 1, client, "order-id")
+	require.NoError(t, err)
+	p.SetLayout(RunLayout)
+
+	planned := p.Plan()
+	assert.Empty(t, client.started, "no image data is fetched")
+	require.Len(t, planned, 4)
+
+	assert.Equal(t, "rain_ts0_2025091500", planned[0].FileId)
+	assert.Equal(t, PlanSkip, planned[0].Action)
+	assert.Equal(t, "already exists", planned[0].Reason)
+
+	assert.Equal(t, PlanSkip, planned[2].Action)
+	assert.Empty(t, planned[2].Paths)
+
+	assert.Equal(t, "rain_ts2_2025091500", planned[3].FileId)
+	assert.Equal(t, PlanDownload, planned[3].Action)
+	assert.Equal(t, "rain", planned[3].Kind)
+	assert.Equal(t, 2, planned[3].Timestep)
+	assert.Len(t, planned[3].Paths, 1)
+	assert.False(t, planned[3].ValidTime.IsZero())
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// The outcome of polling the order, as counted by the metoffice_poll_checks_total metric
+const (
+	PollNewRun    = "new_run"
+	PollUnchanged = "unchanged"
+	PollBusy      = "busy"
+	PollCapped    = "capped"
+	PollFailed    = "failed"
+)
+
+var (
+	pollChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metoffice_poll_checks_total",
+		Help: "Checks of the order for a new run, by outcome",
+	}, []string{"result"})
+	pollCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "metoffice_poll_calls_today",
+		Help: "Calls made to the DataHub API today, including the files downloaded, counted against the daily cap",
+	})
+	latestRunTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "metoffice_latest_run_timestamp_seconds",
+		Help: "The runDateTime of the most recent run downloaded in full",
+	})
+	publicationLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "metoffice_run_publication_lag_seconds",
+		Help:    "Time from a run's runDateTime until polling found it published in the order",
+		Buckets: prometheus.ExponentialBuckets(60, 2, 10),
+	})
+	availabilityLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "metoffice_run_availability_lag_seconds",
+		Help:    "Time from polling finding a run published until its frames were available",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	})
+)
+
+// Poller downloads each new run as soon as it is published, by checking the newest
+// runDateTime in the order on an interval. Checks back off exponentially after a
+// failure, and stop for the rest of the day once the daily cap of calls is reached.
+// Every call made to the DataHub API counts against the cap, including those
+// downloading the files of a run
+type Poller struct {
+	cfg  config.PollConfig
+	loc  *time.Location
+	runs *RunManager
+
+	day string
+	// dayCalls is the number of calls the run manager had made when the day began
+	dayCalls int64
+	failures int
+	// checked is set once the order has been retrieved, as the publication time of a
+	// run already in the order when polling starts is unknown
+	checked bool
+	// detected is when a run not yet downloaded was first found in the order
+	detected map[time.Time]time.Time
+}
+
+func NewPoller(cfg config.PollConfig, loc *time.Location, runs *RunManager) (*Poller, error) {
+	if cfg.Interval <= 0 {
+		return nil, errors.New("poll interval must be positive")
+	}
+	if cfg.MaxInterval < cfg.Interval {
+		cfg.MaxInterval = cfg.Interval
+	}
+	if cfg.MaxCallsPerDay < 0 {
+		return nil, errors.New("poll max calls per day must not be negative")
+	}
+	return &Poller{
+		cfg:      cfg,
+		loc:      loc,
+		runs:     runs,
+		detected: make(map[time.Time]time.Time),
+	}, nil
+}
+
+// Poll checks the order once, downloading the newest run when it has not been already,
+// and returns the outcome along with how long to wait before polling again
+func (p *Poller) Poll(now time.Time) (string, time.Duration) {
+	result, err := p.check(now)
+	pollChecks.WithLabelValues(result).Inc()
+	pollCalls.Set(float64(p.calls()))
+
+	switch result {
+	case PollFailed:
+		log.Printf("Polling for a new run failed: %v", err)
+		p.failures++
+	case PollCapped:
+		tomorrow := p.tomorrow(now)
+		log.Printf("Reached the cap of %d DataHub calls today, polling again at %s", p.cfg.MaxCallsPerDay, tomorrow.Format(time.RFC3339))
+		return result, tomorrow.Sub(now)
+	default:
+		p.failures = 0
+	}
+	return result, p.backoff()
+}
+
+func (p *Poller) check(now time.Time) (string, error) {
+	if day := now.In(p.loc).Format(time.DateOnly); day != p.day {
+		p.day = day
+		p.dayCalls = p.runs.Calls()
+	}
+	if !p.allows(1) {
+		return PollCapped, nil
+	}
+
+	resp, err := p.runs.client.GetLatest(url.QueryEscape(p.runs.orderId), NewQueryParams("dataSpec", "1.1.0"))
+	if err != nil {
+		return PollFailed, err
+	}
+	var newest time.Time
+	for _, file := range resp.OrderDetails.Files {
+		if file.RunDateTime.After(newest) {
+			newest = file.RunDateTime
+		}
+	}
+	if newest.IsZero() {
+		return PollFailed, errors.New("order contains no files with a runDateTime")
+	}
+
+	downloaded, err := p.runs.LastDownloaded()
+	if err != nil {
+		return PollFailed, err
+	}
+	if !downloaded.IsZero() {
+		latestRunTime.Set(float64(downloaded.Unix()))
+	}
+	if !newest.After(downloaded) {
+		p.checked = true
+		return PollUnchanged, nil
+	}
+
+	detected, seen := p.detected[newest]
+	if !seen && p.checked {
+		for runTime := range p.detected {
+			delete(p.detected, runTime)
+		}
+		detected, seen = now, true
+		p.detected[newest] = now
+		publicationLag.Observe(now.Sub(newest).Seconds())
+		log.Printf("Found new run %s in the order, %s after its runDateTime", newest.Format(time.RFC3339), now.Sub(newest).Round(time.Second))
+	}
+	p.checked = true
+
+	// Starting a run retrieves the order again, then downloads up to every file in it
+	if !p.allows(1 + len(resp.OrderDetails.Files)) {
+		return PollCapped, nil
+	}
+	started, err := p.runs.Start(TriggerPoll, nil)
+	if errors.Is(err, ErrRunInProgress) || errors.Is(err, ErrCollecting) || errors.Is(err, ErrNotLeader) {
+		return PollBusy, nil
+	}
+	if err != nil {
+		return PollFailed, err
+	}
+	p.runs.Wait()
+
+	run, err := p.runs.Get(started.ID)
+	if err != nil {
+		return PollFailed, err
+	}
+	if run.Status != RunCompleted {
+		return PollFailed, fmt.Errorf("download run %s %s", run.ID, run.Status)
+	}
+	if seen && run.EndTime != nil {
+		availabilityLag.Observe(run.EndTime.Sub(detected).Seconds())
+	}
+	latestRunTime.Set(float64(newest.Unix()))
+	delete(p.detected, newest)
+	return PollNewRun, nil
+}
+
+// calls returns the number of calls made to the DataHub API today
+func (p *Poller) calls() int {
+	return int(p.runs.Calls() - p.dayCalls)
+}
+
+// allows reports whether the given number of calls can be made without exceeding the
+// daily cap
+func (p *Poller) allows(calls int) bool {
+	return p.cfg.MaxCallsPerDay == 0 || p.calls()+calls <= p.cfg.MaxCallsPerDay
+}
+
+// backoff doubles the interval for every consecutive failure, up to the max interval
+func (p *Poller) backoff() time.Duration {
+	wait := p.cfg.Interval
+	for range p.failures {
+		if wait >= p.cfg.MaxInterval/2 {
+			return p.cfg.MaxInterval
+		}
+		wait *= 2
+	}
+	return wait
+}
+
+// tomorrow returns the start of the next day, when the daily cap is reset
+func (p *Poller) tomorrow(now time.Time) time.Time {
+	local := now.In(p.loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, p.loc)
+}
+
+// Watch polls the order forever, waiting between polls as Poll directs. Only the
+// leader polls, while other instances check the lock every interval
+func (p *Poller) Watch(leader *Leader) {
+	for {
+		wait := p.cfg.Interval
+		if leader.IsLeader() {
+			_, wait = p.Poll(time.Now())
+		}
+		time.Sleep(wait)
+	}
+}
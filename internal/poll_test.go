@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller_Poll(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500")
+	runs, _ := testRunManager(t, client)
+	poller, err := NewPoller(config.PollConfig{
+		Interval:       5 * time.Minute,
+		MaxInterval:    20 * time.Minute,
+		MaxCallsPerDay: 7,
+	}, time.UTC, runs)
+	require.NoError(t, err)
+
+	// The run in the order has not been downloaded yet
+	now := time.Date(2025, 9, 15, 2, 0, 0, 0, time.UTC)
+	result, wait := poller.Poll(now)
+	assert.Equal(t, PollNewRun, result)
+	assert.Equal(t, 5*time.Minute, wait)
+	assert.Equal(t, 2, client.calls)
+	// Downloading the file counts against the cap, as well as retrieving the order
+	assert.Equal(t, 3, poller.calls())
+
+	result, _ = poller.Poll(now.Add(wait))
+	assert.Equal(t, PollUnchanged, result)
+	assert.Equal(t, 3, client.calls)
+	assert.Equal(t, 4, poller.calls())
+
+	// A new run is published
+	runTime := time.Date(2025, 9, 15, 1, 0, 0, 0, time.UTC)
+	client.files = []metoffice.File{{FileId: "rain_ts0_2025091501", RunDateTime: runTime, Run: "01"}}
+	result, _ = poller.Poll(now.Add(10 * time.Minute))
+	assert.Equal(t, PollNewRun, result)
+	downloaded, err := runs.LastDownloaded()
+	require.NoError(t, err)
+	assert.Equal(t, runTime, downloaded)
+	latest := runs.Runs()[0]
+	assert.Equal(t, TriggerPoll, latest.Trigger)
+	assert.Equal(t, runTime, *latest.RunDateTime)
+
+	// The daily cap of calls is reached, so polling resumes tomorrow
+	result, wait = poller.Poll(now.Add(15 * time.Minute))
+	assert.Equal(t, PollCapped, result)
+	assert.Equal(t, 21*time.Hour+45*time.Minute, wait)
+	assert.Equal(t, 5, client.calls)
+	assert.Equal(t, 7, poller.calls())
+
+	// Failures back off exponentially, up to the max interval
+	now = time.Date(2025, 9, 16, 0, 0, 0, 0, time.UTC)
+	client.err = errors.New("service unavailable")
+	for _, expected := range []time.Duration{10 * time.Minute, 20 * time.Minute, 20 * time.Minute} {
+		result, wait = poller.Poll(now)
+		assert.Equal(t, PollFailed, result)
+		assert.Equal(t, expected, wait)
+	}
+	client.err = nil
+	result, wait = poller.Poll(now)
+	assert.Equal(t, PollUnchanged, result)
+	assert.Equal(t, 5*time.Minute, wait)
+
+	// The most recent run downloaded is remembered in the history
+	restarted := NewRunManager(runs.cfg, runs.frames, client, "order-id")
+	restarted.SetHistory(runs.history)
+	downloaded, err = restarted.LastDownloaded()
+	require.NoError(t, err)
+	assert.Equal(t, runTime, downloaded)
+}
+
+func TestPoller_CapsDownloads(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500", "rain_ts2_2025091500")
+	runs, _ := testRunManager(t, client)
+	poller, err := NewPoller(config.PollConfig{Interval: 5 * time.Minute, MaxCallsPerDay: 4}, time.UTC, runs)
+	require.NoError(t, err)
+
+	// Retrieving the order again and downloading its three files would exceed the cap
+	result, _ := poller.Poll(time.Date(2025, 9, 15, 2, 0, 0, 0, time.UTC))
+	assert.Equal(t, PollCapped, result)
+	assert.Empty(t, runs.Runs())
+	assert.Equal(t, 1, poller.calls())
+
+	// With one more call allowed, the run starts the next day, when the cap is reset
+	poller.cfg.MaxCallsPerDay = 5
+	result, _ = poller.Poll(time.Date(2025, 9, 16, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, PollNewRun, result)
+	assert.Equal(t, 5, poller.calls())
+}
+
+func TestNewPoller_Invalid(t *testing.T) {
+	runs, _ := testRunManager(t, newFakeDataHub())
+	_, err := NewPoller(config.PollConfig{}, time.UTC, runs)
+	assert.Error(t, err)
+	_, err = NewPoller(config.PollConfig{Interval: time.Minute, MaxCallsPerDay: -1}, time.UTC, runs)
+	assert.Error(t, err)
+
+	poller, err := NewPoller(config.PollConfig{Interval: time.Minute}, time.UTC, runs)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, poller.cfg.MaxInterval)
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"time"
+)
+
+// Progress is how far a download run has got through the files in the order
+type Progress struct {
+	Total     int `json:"total"`
+	Done      int `json:"done"`
+	Succeeded int `json:"succeeded"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	// Bytes is the number downloaded so far, including files still in progress
+	Bytes          int64   `json:"bytes"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	// ETASeconds estimates the time remaining from the rate files have been done at,
+	// once there is one
+	ETASeconds *float64 `json:"etaSeconds,omitempty"`
+}
+
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+func (p Progress) Elapsed() time.Duration {
+	return time.Duration(p.ElapsedSeconds * float64(time.Second))
+}
+
+// ETA returns the estimated time remaining, if known
+func (p Progress) ETA() (time.Duration, bool) {
+	if p.ETASeconds == nil {
+		return 0, false
+	}
+	return time.Duration(*p.ETASeconds * float64(time.Second)), true
+}
+
+func (p *Progress) add(result FileResult) {
+	p.Done++
+	switch result.Status {
+	case StatusSucceeded:
+		p.Succeeded++
+	case StatusSkipped:
+		p.Skipped++
+	case StatusFailed:
+		p.Failed++
+	}
+}
+
+// at completes the progress with the bytes downloaded and timings as of now
+func (p Progress) at(startTime, now time.Time, bytes int64) Progress {
+	p.Bytes = bytes
+	elapsed := now.Sub(startTime)
+	p.ElapsedSeconds = elapsed.Seconds()
+	if p.Done > 0 && p.Done < p.Total {
+		eta := (elapsed.Seconds() / float64(p.Done)) * float64(p.Total-p.Done)
+		p.ETASeconds = &eta
+	}
+	return p
+}
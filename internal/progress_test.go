@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	startTime := time.Date(2025, 9, 15, 4, 30, 0, 0, time.UTC)
+	p := Progress{Total: 4}
+	p.add(FileResult{Status: StatusSucceeded})
+
+	at := p.at(startTime, startTime.Add(10*time.Second), 1024)
+	assert.Equal(t, 25.0, at.Percent())
+	assert.Equal(t, int64(1024), at.Bytes)
+	assert.Equal(t, 10*time.Second, at.Elapsed())
+	eta, ok := at.ETA()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, eta)
+
+	p.add(FileResult{Status: StatusSkipped})
+	p.add(FileResult{Status: StatusFailed})
+	p.add(FileResult{Status: StatusSucceeded})
+	at = p.at(startTime, startTime.Add(time.Minute), 2048)
+	assert.Equal(t, Progress{Total: 4, Done: 4, Succeeded: 2, Skipped: 1, Failed: 1, Bytes: 2048, ElapsedSeconds: 60}, at)
+	_, ok = at.ETA()
+	assert.False(t, ok, "no ETA once done")
+}
+
+func TestRunManager_Progress(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500", "rain_ts2_2025091500")
+	client.started = make(chan string, 3)
+	client.release = make(chan struct{})
+	runs, _ := testRunManager(t, client)
+
+	var mu sync.Mutex
+	updates := make([]Progress, 0)
+	runs.OnProgress(func(id string, progress Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, progress)
+	})
+
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	require.NotNil(t, started.Progress)
+	assert.Equal(t, 3, started.Progress.Total)
+
+	<-client.started
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, run.Progress.Done)
+	close(client.release)
+	runs.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, updates, 3)
+	assert.Equal(t, 3, updates[2].Done)
+	assert.Equal(t, 3, updates[2].Succeeded)
+	assert.Positive(t, updates[2].Bytes)
+
+	run, err = runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Nil(t, run.Progress, "only reported while running")
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// defaultRedisEventsKey prefixes the channel and id counter of the events when the
+// Redis config has no key
+const defaultRedisEventsKey = "metoffice-uk-weather-overlays"
+
+// publishScript numbers each event and publishes it in one step, so that every
+// replica receives the events in the order of their ids
+var publishScript = redis.NewScript(`
+local id = redis.call("INCR", KEYS[1])
+redis.call("PUBLISH", KEYS[2], id .. "\n" .. ARGV[1] .. "\n" .. ARGV[2])
+return id`)
+
+// RedisEventRelay shares catalog events between replicas through Redis pub/sub. Events
+// published while Redis cannot be reached are lost
+type RedisEventRelay struct {
+	client  *redis.Client
+	counter string
+	channel string
+	pubsub  *redis.PubSub
+}
+
+func NewRedisEventRelay(cfg config.RedisConfig) (*RedisEventRelay, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("redis event relay requires a URL")
+	}
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	key := cfg.Key
+	if key == "" {
+		key = defaultRedisEventsKey
+	}
+	return &RedisEventRelay{
+		client:  redis.NewClient(opts),
+		counter: key + ":events:id",
+		channel: key + ":events",
+	}, nil
+}
+
+func (r *RedisEventRelay) Publish(ctx context.Context, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	if err := publishScript.Run(ctx, r.client, []string{r.counter, r.channel}, eventType, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+func (r *RedisEventRelay) Subscribe(ctx context.Context) (<-chan CatalogEvent, error) {
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	// Wait for the subscription, so that no event published from now on is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", r.channel, err)
+	}
+	r.pubsub = pubsub
+
+	events := make(chan CatalogEvent)
+	go func() {
+		defer close(events)
+		for msg := range pubsub.Channel() {
+			event, err := parseRelayedEvent(msg.Payload)
+			if err != nil {
+				log.Printf("Ignoring event relayed on %s: %v", r.channel, err)
+				continue
+			}
+			events <- event
+		}
+	}()
+	return events, nil
+}
+
+// Close unsubscribes and disconnects from Redis
+func (r *RedisEventRelay) Close() error {
+	var err error
+	if r.pubsub != nil {
+		err = r.pubsub.Close()
+	}
+	return errors.Join(err, r.client.Close())
+}
+
+// parseRelayedEvent decodes an event published as its id, type and JSON data on
+// separate lines. The data is kept as JSON, to be sent on as it is
+func parseRelayedEvent(payload string) (CatalogEvent, error) {
+	parts := strings.SplitN(payload, "\n", 3)
+	if len(parts) != 3 {
+		return CatalogEvent{}, errors.New("malformed event")
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return CatalogEvent{}, fmt.Errorf("invalid event id: %w", err)
+	}
+	if !json.Valid([]byte(parts[2])) {
+		return CatalogEvent{}, errors.New("invalid event data")
+	}
+	return CatalogEvent{ID: id, Type: parts[1], Data: json.RawMessage(parts[2])}, nil
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRelayedHub(t *testing.T, server *miniredis.Miniredis) *EventHub {
+	relay, err := NewRedisEventRelay(config.RedisConfig{URL: "redis://" + server.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = relay.Close()
+	})
+	hub := NewEventHub()
+	require.NoError(t, hub.SetRelay(relay))
+	return hub
+}
+
+func receiveEvent(t *testing.T, ch <-chan CatalogEvent) CatalogEvent {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event received")
+		return CatalogEvent{}
+	}
+}
+
+func TestRedisEventRelay(t *testing.T) {
+	server := miniredis.RunT(t)
+	leader, follower := newRelayedHub(t, server), newRelayedHub(t, server)
+
+	// With a relay, instances not holding the lock stream every event too
+	notLeading, err := NewLeader(lock.NewFileLock(filepath.Join(t.TempDir(), ".cron.lock")), time.Minute)
+	require.NoError(t, err)
+	follower.SetLeader(notLeading)
+	assert.True(t, follower.Streaming())
+
+	_, fromLeader, unsubscribe := leader.Subscribe(0)
+	defer unsubscribe()
+	_, fromFollower, unsubscribeFollower := follower.Subscribe(0)
+	defer unsubscribeFollower()
+
+	// Events published by one replica reach the clients of both, with the same ids
+	leader.Publish(EventRun, RunEvent{ID: "20250915T040000.000Z", Status: RunCompleted})
+	leader.Publish(EventFrame, FrameEvent{Kind: "rain", Path: "rain/2025/09/15/00.webp"})
+	for _, ch := range []<-chan CatalogEvent{fromLeader, fromFollower} {
+		event := receiveEvent(t, ch)
+		assert.Equal(t, uint64(1), event.ID)
+		assert.Equal(t, EventRun, event.Type)
+		var run RunEvent
+		require.NoError(t, json.Unmarshal(event.Data.(json.RawMessage), &run))
+		assert.Equal(t, RunCompleted, run.Status)
+
+		event = receiveEvent(t, ch)
+		assert.Equal(t, uint64(2), event.ID)
+		assert.Equal(t, EventFrame, event.Type)
+	}
+
+	// A client reconnecting to the other replica is sent the events it missed
+	missed, _, unsubscribeAgain := follower.Subscribe(1)
+	defer unsubscribeAgain()
+	require.Len(t, missed, 1)
+	assert.Equal(t, uint64(2), missed[0].ID)
+	assert.JSONEq(t, `{"runId":"","kind":"rain","path":"rain/2025/09/15/00.webp","validTime":"0001-01-01T00:00:00Z","runTime":"0001-01-01T00:00:00Z","timestep":0}`, string(missed[0].Data.(json.RawMessage)))
+}
+
+// blockedRelay never finishes publishing until it is released
+type blockedRelay struct {
+	release   chan struct{}
+	published chan string
+}
+
+func (r *blockedRelay) Publish(_ context.Context, eventType string, _ any) error {
+	<-r.release
+	r.published <- eventType
+	return nil
+}
+
+func (r *blockedRelay) Subscribe(context.Context) (<-chan CatalogEvent, error) {
+	return make(chan CatalogEvent), nil
+}
+
+func TestEventHub_RelayDoesNotBlock(t *testing.T) {
+	relay := &blockedRelay{release: make(chan struct{}), published: make(chan string, relayBuffer+1)}
+	hub := NewEventHub()
+	require.NoError(t, hub.SetRelay(relay))
+
+	// The buffer fills, as may the relay itself, and the rest are dropped
+	done := make(chan struct{})
+	go func() {
+		for range relayBuffer + 10 {
+			hub.Publish(EventFrame, nil)
+		}
+		hub.Publish(EventRun, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "publishing waited on the relay")
+	}
+
+	close(relay.release)
+	relayed := 0
+	for {
+		select {
+		case eventType := <-relay.published:
+			assert.Equal(t, EventFrame, eventType)
+			relayed++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	assert.GreaterOrEqual(t, relayed, relayBuffer)
+	assert.LessOrEqual(t, relayed, relayBuffer+1)
+}
+
+func TestParseRelayedEvent(t *testing.T) {
+	event, err := parseRelayedEvent("7\ncleanup\n{\"frames\":1}")
+	require.NoError(t, err)
+	assert.Equal(t, CatalogEvent{ID: 7, Type: EventCleanup, Data: json.RawMessage(`{"frames":1}`)}, event)
+
+	for _, payload := range []string{"", "7\ncleanup", "x\ncleanup\n{}", "7\ncleanup\n{"} {
+		_, err := parseRelayedEvent(payload)
+		assert.Error(t, err, payload)
+	}
+}
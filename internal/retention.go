@@ -0,0 +1,329 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+// DefaultOverflowDays is how long frames beyond the first 24 hours of a run are kept
+// when no retention policy is configured. Earlier frames are kept indefinitely
+const DefaultOverflowDays = 7
+
+// Reasons that a frame is deleted by the retention policy
+const (
+	ExpiredAnalysis = "analysis"
+	ExpiredOverflow = "overflow"
+	ExpiredSize     = "size"
+)
+
+// retentionPolicy is a config.RetentionPolicy resolved against the default policy
+type retentionPolicy struct {
+	analysisDays int
+	overflowDays int
+	maxSize      int64
+}
+
+// ExpiredFrame is a frame, along with all its variants, deleted by the retention policy
+type ExpiredFrame struct {
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path"`
+	RunTime  time.Time `json:"runTime"`
+	Timestep int       `json:"timestep"`
+	Reason   string    `json:"reason"`
+	Files    int       `json:"files"`
+	// Bytes is the space freed by deleting the frame, which excludes deduplicated
+	// content that frames still kept refer to
+	Bytes int64 `json:"bytes"`
+}
+
+// RetentionTotals counts the frames deleted from a kind
+type RetentionTotals struct {
+	Frames int   `json:"frames"`
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// RetentionReport lists the frames deleted by the retention policy or, in a dry run,
+// those that would be. When frames are deduplicated, content shared between frames is
+// counted once, in the frame whose deletion frees it
+type RetentionReport struct {
+	DryRun         bool                        `json:"dryRun"`
+	Expired        []ExpiredFrame              `json:"expired"`
+	Kinds          map[string]*RetentionTotals `json:"kinds"`
+	Total          RetentionTotals             `json:"total"`
+	RemainingBytes int64                       `json:"remainingBytes"`
+}
+
+func (r *RetentionReport) add(frame *storedFrame, reason string, freed int64) {
+	r.Expired = append(r.Expired, ExpiredFrame{
+		Kind:     frame.kind,
+		Path:     frame.path,
+		RunTime:  frame.runTime,
+		Timestep: frame.timestep,
+		Reason:   reason,
+		Files:    len(frame.keys),
+		Bytes:    freed,
+	})
+
+	totals, ok := r.Kinds[frame.kind]
+	if !ok {
+		totals = &RetentionTotals{}
+		r.Kinds[frame.kind] = totals
+	}
+	for _, t := range []*RetentionTotals{totals, &r.Total} {
+		t.Frames++
+		t.Files += len(frame.keys)
+		t.Bytes += freed
+	}
+}
+
+// storedFrame groups the files of a frame in either layout
+type storedFrame struct {
+	kind      string
+	path      string
+	runTime   time.Time
+	validTime time.Time
+	timestep  int
+	keys      []string
+	// contents identifies the content of each key, which deduplicated keys share
+	contents []string
+}
+
+// contentUsage totals the size of the content referred to by a set of frames,
+// counting content shared by several keys once
+type contentUsage struct {
+	sizes map[string]int64
+	refs  map[string]int
+	bytes int64
+}
+
+func newContentUsage(sizes map[string]int64) *contentUsage {
+	return &contentUsage{sizes: sizes, refs: make(map[string]int)}
+}
+
+func (u *contentUsage) add(frame *storedFrame) {
+	for _, content := range frame.contents {
+		u.refs[content]++
+		if u.refs[content] == 1 {
+			u.bytes += u.sizes[content]
+		}
+	}
+}
+
+// remove takes the frame out of the set, returning the size of the content that no
+// other frame refers to
+func (u *contentUsage) remove(frame *storedFrame) int64 {
+	var freed int64
+	for _, content := range frame.contents {
+		u.refs[content]--
+		if u.refs[content] == 0 {
+			freed += u.sizes[content]
+		}
+	}
+	u.bytes -= freed
+	return freed
+}
+
+func (f *storedFrame) runDate() time.Time {
+	t := f.runTime.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// retentionPolicyFor resolves the kind's policy, falling back field by field to the
+// default policy and then to keeping overflow frames for DefaultOverflowDays
+func retentionPolicyFor(cfg config.RetentionConfig, kind string) retentionPolicy {
+	policy := retentionPolicy{overflowDays: DefaultOverflowDays}
+	for _, p := range []config.RetentionPolicy{cfg.Default, cfg.Overlays[kind]} {
+		if p.AnalysisDays != nil {
+			policy.analysisDays = *p.AnalysisDays
+		}
+		if p.OverflowDays != nil {
+			policy.overflowDays = *p.OverflowDays
+		}
+		if p.MaxSize != nil {
+			policy.maxSize = int64(*p.MaxSize)
+		}
+	}
+	return policy
+}
+
+// ApplyRetention deletes the frames that the retention config no longer keeps: those
+// of runs older than the analysis or overflow days of their kind, then the oldest runs
+// of any kind over its maximum size, then the oldest runs of all kinds until the total
+// is within the maximum. When dryRun is set, nothing is deleted
+func ApplyRetention(ctx context.Context, frames store.FrameStore, cfg config.RetentionConfig, now time.Time, dryRun bool) (RetentionReport, error) {
+	stored, sizes, err := findStoredFrames(ctx, frames)
+	if err != nil {
+		return RetentionReport{}, err
+	}
+
+	// Oldest runs first, so that they are evicted before newer ones
+	sort.SliceStable(stored, func(i, j int) bool {
+		a, b := stored[i], stored[j]
+		if !a.runTime.Equal(b.runTime) {
+			return a.runTime.Before(b.runTime)
+		}
+		if !a.validTime.Equal(b.validTime) {
+			return a.validTime.Before(b.validTime)
+		}
+		return a.path < b.path
+	})
+
+	report := RetentionReport{DryRun: dryRun, Expired: make([]ExpiredFrame, 0), Kinds: make(map[string]*RetentionTotals)}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	total := newContentUsage(sizes)
+	kinds := make(map[string]*contentUsage)
+	for _, frame := range stored {
+		total.add(frame)
+		if _, ok := kinds[frame.kind]; !ok {
+			kinds[frame.kind] = newContentUsage(sizes)
+		}
+		kinds[frame.kind].add(frame)
+	}
+
+	expired := make([]*storedFrame, 0)
+	expire := func(frame *storedFrame, reason string) {
+		kinds[frame.kind].remove(frame)
+		report.add(frame, reason, total.remove(frame))
+		expired = append(expired, frame)
+	}
+
+	kept := make([]*storedFrame, 0, len(stored))
+	for _, frame := range stored {
+		policy := retentionPolicyFor(cfg, frame.kind)
+		days, reason := policy.analysisDays, ExpiredAnalysis
+		if frame.timestep >= 24 {
+			days, reason = policy.overflowDays, ExpiredOverflow
+		}
+		if days > 0 && frame.runDate().Before(today.AddDate(0, 0, -days)) {
+			expire(frame, reason)
+			continue
+		}
+		kept = append(kept, frame)
+	}
+
+	remaining := make([]*storedFrame, 0, len(kept))
+	for _, frame := range kept {
+		policy := retentionPolicyFor(cfg, frame.kind)
+		if policy.maxSize > 0 && kinds[frame.kind].bytes > policy.maxSize {
+			expire(frame, ExpiredSize)
+			continue
+		}
+		remaining = append(remaining, frame)
+	}
+
+	for _, frame := range remaining {
+		if cfg.MaxTotalSize <= 0 || total.bytes <= int64(cfg.MaxTotalSize) {
+			break
+		}
+		expire(frame, ExpiredSize)
+	}
+	report.RemainingBytes = total.bytes
+
+	if dryRun {
+		return report, nil
+	}
+	for i, frame := range expired {
+		log.Printf("Deleting expired frame (%s): %s", report.Expired[i].Reason, frame.path)
+		for _, key := range frame.keys {
+			if err := frames.Delete(ctx, key); err != nil {
+				return report, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// findStoredFrames groups the files in the store by frame, in both the run and valid
+// time layouts, along with the size of each distinct content they refer to. The run
+// of a frame in the valid time layout comes from its metadata
+func findStoredFrames(ctx context.Context, frames store.FrameStore) ([]*storedFrame, map[string]int64, error) {
+	dedup, _ := frames.(*store.DedupStore)
+	byPath := make(map[string]*storedFrame)
+	sizes := make(map[string]int64)
+	err := frames.List(ctx, "", func(info store.ObjectInfo) error {
+		frame, suffix, ok := parseStoredFrame(info.Key)
+		if !ok || !frameSuffixRegexp.MatchString(suffix) {
+			return nil
+		}
+
+		// Listed sizes are those of pointers or links, not the content they share
+		content, size := info.Key, info.Size
+		if dedup != nil {
+			var err error
+			content, size, err = dedup.Content(ctx, info.Key)
+			if errors.Is(err, store.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to find content of %s: %w", info.Key, err)
+			}
+		}
+
+		if existing, ok := byPath[frame.path]; ok {
+			frame = existing
+		} else {
+			byPath[frame.path] = frame
+		}
+		frame.keys = append(frame.keys, info.Key)
+		frame.contents = append(frame.contents, content)
+		sizes[content] = size
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list frames: %w", err)
+	}
+
+	result := make([]*storedFrame, 0, len(byPath))
+	for _, frame := range byPath {
+		if strings.HasPrefix(frame.path, validTimePrefix+"/") {
+			meta, err := ReadFrameMetadata(ctx, frames, path.Dir(frame.path), frame.validTime.Hour())
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read metadata of %s: %w", frame.path, err)
+			}
+			if meta != nil {
+				frame.runTime = meta.RunTime
+				frame.timestep = meta.Timestep
+			}
+		}
+		result = append(result, frame)
+	}
+	return result, sizes, nil
+}
+
+// parseStoredFrame identifies the frame that a key belongs to. Frames in the valid time
+// layout without metadata are taken to be from the run on the day they are valid for
+func parseStoredFrame(key string) (*storedFrame, string, bool) {
+	if kind, validTime, suffix, err := ParseValidTimePath(key); err == nil {
+		runTime := time.Date(validTime.Year(), validTime.Month(), validTime.Day(), 0, 0, 0, 0, time.UTC)
+		return &storedFrame{
+			kind:      kind,
+			path:      ValidTimePath(kind, validTime),
+			runTime:   runTime,
+			validTime: validTime,
+			timestep:  validTime.Hour(),
+		}, suffix, true
+	}
+
+	id, suffix, err := metoffice.ParsePath(key)
+	if err != nil {
+		return nil, "", false
+	}
+	return &storedFrame{
+		kind:      id.Kind,
+		path:      id.Path(),
+		runTime:   id.RunTime(),
+		validTime: id.ValidTime(),
+		timestep:  id.Timestep,
+	}, suffix, true
+}
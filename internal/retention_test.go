@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func expiredPaths(report RetentionReport) []string {
+	paths := make([]string, 0, len(report.Expired))
+	for _, frame := range report.Expired {
+		paths = append(paths, frame.Path+" "+frame.Reason)
+	}
+	return paths
+}
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	frames := store.NewFileStore(root)
+	now := time.Date(2025, 9, 20, 1, 0, 0, 0, time.UTC)
+
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/10/06.webp"), "analysis")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/10/06.thumb.webp"), "thumb")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/10/30.webp"), "overflow")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/18/30.webp"), "recent")
+	writeTestFile(t, filepath.Join(root, "cloud_amount_total/2025/09/18/notes.txt"), "ignored")
+	writeTestFile(t, filepath.Join(root, "wind_barbs/2025/09/16/06.webp"), "wind")
+	writeTestFile(t, filepath.Join(root, "wind_barbs/2025/09/19/06.webp"), "wind")
+
+	// Without a policy, only overflow frames over a week old are deleted
+	report, err := ApplyRetention(ctx, frames, config.RetentionConfig{}, now, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cloud_amount_total/2025/09/10/30 overflow"}, expiredPaths(report))
+	assert.Equal(t, RetentionTotals{Frames: 1, Files: 1, Bytes: 8}, report.Total)
+	assert.Equal(t, int64(27), report.RemainingBytes)
+	assert.FileExists(t, filepath.Join(root, "cloud_amount_total/2025/09/10/30.webp"))
+
+	var cfg config.RetentionConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+default:
+  analysisDays: 5
+overlays:
+  wind_barbs:
+    analysisDays: 0
+    maxSize: 4B
+`), &cfg))
+
+	report, err = ApplyRetention(ctx, frames, cfg, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"cloud_amount_total/2025/09/10/06 analysis",
+		"cloud_amount_total/2025/09/10/30 overflow",
+		"wind_barbs/2025/09/16/06 size",
+	}, expiredPaths(report))
+	assert.Equal(t, RetentionTotals{Frames: 2, Files: 3, Bytes: 21}, *report.Kinds["cloud_amount_total"])
+	assert.Equal(t, int64(10), report.RemainingBytes)
+
+	assert.NoFileExists(t, filepath.Join(root, "cloud_amount_total/2025/09/10/06.thumb.webp"))
+	assert.NoFileExists(t, filepath.Join(root, "wind_barbs/2025/09/16/06.webp"))
+	assert.FileExists(t, filepath.Join(root, "wind_barbs/2025/09/19/06.webp"))
+	assert.FileExists(t, filepath.Join(root, "cloud_amount_total/2025/09/18/notes.txt"))
+
+	// The total size cap evicts the oldest runs of any kind
+	report, err = ApplyRetention(ctx, frames, config.RetentionConfig{MaxTotalSize: 5}, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cloud_amount_total/2025/09/18/30 size"}, expiredPaths(report))
+	assert.Equal(t, int64(4), report.RemainingBytes)
+}
+
+func TestApplyRetention_ValidTimeLayout(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	frames := store.NewFileStore(root)
+	now := time.Date(2025, 9, 20, 1, 0, 0, 0, time.UTC)
+
+	// A frame published by a run two days before the day it is valid for
+	writeTestFile(t, filepath.Join(root, "valid/cloud_amount_total/2025/09/12/02.webp"), "frame")
+	require.NoError(t, writeFrameMetadata(ctx, frames, "valid/cloud_amount_total/2025/09/12", 2, FrameMetadata{
+		Kind:      "cloud_amount_total",
+		ValidTime: time.Date(2025, 9, 12, 2, 0, 0, 0, time.UTC),
+		RunTime:   time.Date(2025, 9, 10, 0, 0, 0, 0, time.UTC),
+		Timestep:  50,
+	}))
+	writeTestFile(t, filepath.Join(root, "valid/cloud_amount_total/2025/09/12/03.webp"), "no metadata")
+
+	report, err := ApplyRetention(ctx, frames, config.RetentionConfig{}, now, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"valid/cloud_amount_total/2025/09/12/02 overflow"}, expiredPaths(report))
+	assert.Equal(t, 2, report.Total.Files)
+	assert.NoFileExists(t, filepath.Join(root, "valid/cloud_amount_total/2025/09/12/02.meta.json"))
+	assert.FileExists(t, filepath.Join(root, "valid/cloud_amount_total/2025/09/12/03.webp"))
+}
+
+func newFakeS3Store(t *testing.T) store.FrameStore {
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("frames"))
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	s, err := store.NewS3Store(config.S3Config{
+		Endpoint:  endpoint.Host,
+		Bucket:    "frames",
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestApplyRetention_Dedup(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.FrameStore{
+		"fs": func(t *testing.T) store.FrameStore {
+			return store.NewFileStore(t.TempDir())
+		},
+		"s3": newFakeS3Store,
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			frames := store.NewDedupStore(newStore(t))
+			now := time.Date(2025, 9, 20, 1, 0, 0, 0, time.UTC)
+			for key, content := range map[string]string{
+				"cloud_amount_total/2025/09/10/06.webp":       "shared content",
+				"cloud_amount_total/2025/09/10/06.thumb.webp": "thumb",
+				"cloud_amount_total/2025/09/18/06.webp":       "shared content",
+				"cloud_amount_total/2025/09/19/06.webp":       "newest",
+			} {
+				require.NoError(t, frames.Put(ctx, key, strings.NewReader(content), int64(len(content)), store.PutOptions{}))
+			}
+
+			// Shared content is counted once, and only freed with the last frame using it
+			report, err := ApplyRetention(ctx, frames, config.RetentionConfig{MaxTotalSize: 20}, now, true)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"cloud_amount_total/2025/09/10/06 size"}, expiredPaths(report))
+			assert.Equal(t, RetentionTotals{Frames: 1, Files: 2, Bytes: 5}, report.Total)
+			assert.Equal(t, int64(20), report.RemainingBytes)
+
+			report, err = ApplyRetention(ctx, frames, config.RetentionConfig{MaxTotalSize: 10}, now, false)
+			require.NoError(t, err)
+			assert.Equal(t, []string{
+				"cloud_amount_total/2025/09/10/06 size",
+				"cloud_amount_total/2025/09/18/06 size",
+			}, expiredPaths(report))
+			assert.Equal(t, int64(5), report.Expired[0].Bytes)
+			assert.Equal(t, int64(14), report.Expired[1].Bytes)
+			assert.Equal(t, int64(6), report.RemainingBytes)
+
+			_, err = frames.Stat(ctx, "cloud_amount_total/2025/09/19/06.webp")
+			assert.NoError(t, err)
+		})
+	}
+}
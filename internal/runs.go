@@ -0,0 +1,526 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/notify"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunCompleted RunStatus = "completed"
+	RunFailed    RunStatus = "failed"
+	RunCancelled RunStatus = "cancelled"
+)
+
+// What started a run
+const (
+	TriggerCron    = "cron"
+	TriggerAdmin   = "admin"
+	TriggerCLI     = "cli"
+	TriggerCatchUp = "catch-up"
+	TriggerPoll    = "poll"
+)
+
+// maxRecentRuns is how many finished runs are kept in memory
+const maxRecentRuns = 20
+
+var (
+	ErrRunInProgress = errors.New("a download run is already in progress")
+	// ErrCollecting is returned while unreferenced content is being deleted, which
+	// could otherwise remove content a new run is about to reuse
+	ErrCollecting = errors.New("unreferenced content is being collected")
+	// ErrNotLeader is returned when another instance holds the cron lock, and so runs
+	// the downloads and cleanups
+	ErrNotLeader   = errors.New("another instance holds the cron lock")
+	ErrRunNotFound = errors.New("run not found")
+	ErrRunFinished = errors.New("run has already finished")
+)
+
+// Run is a download run, along with the status of every file in the order
+type Run struct {
+	ID       string   `json:"id"`
+	Trigger  string   `json:"trigger"`
+	Overlays []string `json:"overlays,omitempty"`
+	// Filter is how the run was restricted beyond its overlays, if at all
+	Filter *RunFilter `json:"filter,omitempty"`
+	Status RunStatus  `json:"status"`
+	// RunDateTime is the most recent model run in the order
+	RunDateTime *time.Time  `json:"runDateTime,omitempty"`
+	StartTime   time.Time   `json:"startTime"`
+	EndTime     *time.Time  `json:"endTime,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Summary     *RunSummary `json:"summary,omitempty"`
+	// Progress is how far the run has got, while it is running
+	Progress *Progress    `json:"progress,omitempty"`
+	Files    []FileResult `json:"files"`
+
+	cancel   context.CancelFunc
+	index    map[string]int
+	progress func() Progress
+}
+
+// snapshot copies the run, so it can be read while the run continues
+func (r *Run) snapshot() Run {
+	run := *r
+	run.Files = slices.Clone(r.Files)
+	run.cancel = nil
+	run.index = nil
+	run.progress = nil
+	if r.progress != nil {
+		progress := r.progress()
+		run.Progress = &progress
+	}
+	return run
+}
+
+// full reports whether the run was of every file in the order
+func (r *Run) full() bool {
+	return len(r.Overlays) == 0 && r.Filter == nil
+}
+
+// RunManager starts download runs for both the cron and admin API, allowing only one
+// at a time, and keeps track of the current and recent runs
+type RunManager struct {
+	mu         sync.Mutex
+	cfg        *config.Config
+	frames     store.FrameStore
+	client     *countingClient
+	orderId    string
+	runs       []*Run
+	active     *Run
+	done       chan struct{}
+	collecting bool
+	history    *History
+	notifier   *notify.Notifier
+	events     *EventHub
+	leader     *Leader
+	onProgress func(id string, progress Progress)
+
+	// catchUpAttempts counts the attempts to catch up a missed download since a run
+	// last completed, which are not retried before catchUpRetry
+	catchUpAttempts int
+	catchUpRetry    time.Time
+
+	// downloaded is the runDateTime of the most recent run downloaded in full, loaded
+	// from the history when first needed
+	downloaded       time.Time
+	downloadedLoaded bool
+}
+
+func NewRunManager(cfg *config.Config, frames store.FrameStore, client DataHubClient, orderId string) *RunManager {
+	return &RunManager{
+		cfg:     cfg,
+		frames:  frames,
+		client:  &countingClient{DataHubClient: client},
+		orderId: orderId,
+		runs:    make([]*Run, 0),
+	}
+}
+
+// Calls returns the number of calls made to the DataHub API by the manager, both to
+// retrieve the order and to download its files
+func (m *RunManager) Calls() int64 {
+	return m.client.calls.Load()
+}
+
+// SetHistory records every finished run in the history
+func (m *RunManager) SetHistory(history *History) {
+	m.history = history
+}
+
+// SetNotifier sends an event for every finished run that published new frames or
+// failed
+func (m *RunManager) SetNotifier(notifier *notify.Notifier) {
+	m.notifier = notifier
+}
+
+// SetEvents publishes the frames written by runs, finished runs and the frames
+// deleted by cleanups to the hub
+func (m *RunManager) SetEvents(events *EventHub) {
+	m.events = events
+}
+
+// SetLeader only starts runs and cleanups while the instance holds the cron lock, and
+// cancels the active run when the lock is lost
+func (m *RunManager) SetLeader(leader *Leader) {
+	m.leader = leader
+	leader.OnStepDown(m.cancelActive)
+}
+
+// OnProgress registers a function called with the progress of a run whenever one of
+// its files is done
+func (m *RunManager) OnProgress(fn func(id string, progress Progress)) {
+	m.onProgress = fn
+}
+
+// Start begins a download run in the background, processing only the given kinds when
+// any are given. It fails when a run is already in progress, or the order cannot be
+// retrieved
+func (m *RunManager) Start(trigger string, overlays []string) (Run, error) {
+	return m.StartFiltered(trigger, RunFilter{Overlays: overlays})
+}
+
+// StartFiltered begins a download run in the background, processing only the files
+// matching the filter
+func (m *RunManager) StartFiltered(trigger string, filter RunFilter) (Run, error) {
+	// Retrieving the order takes a while, so is done without holding the lock, which
+	// is checked again before the run becomes active
+	m.mu.Lock()
+	err := m.busy()
+	m.mu.Unlock()
+	if err != nil {
+		return Run{}, err
+	}
+
+	startTime := time.Now()
+	layout, err := ParseLayout(m.cfg.Storage.Layout)
+	if err != nil {
+		return Run{}, m.failed(trigger, filter, startTime, err)
+	}
+	downloader, err := newDownloader(m.frames, m.cfg.Cron.Download.PoolSize, m.client, m.orderId)
+	if err != nil {
+		return Run{}, m.failed(trigger, filter, startTime, fmt.Errorf("failed to create downloader: %w", err))
+	}
+	downloader.SetLayout(layout)
+	downloader.SetTraceDir(m.cfg.Debug.TraceDir)
+	downloader.SetBasemapDir(m.cfg.Download.BasemapDir)
+	if err := downloader.SetDefaultOverlay(m.cfg.Download.DefaultOverlay); err != nil {
+		return Run{}, m.failed(trigger, filter, startTime, err)
+	}
+	// A filter the order cannot satisfy is a bad request rather than a failed run
+	if err := downloader.SetFilter(filter); err != nil {
+		return Run{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	downloader.SetContext(ctx)
+
+	run := newRun(trigger, filter, downloader.startTime)
+	run.Status = RunRunning
+	run.Files = make([]FileResult, 0, len(downloader.files))
+	run.cancel = cancel
+	run.index = make(map[string]int, len(downloader.files))
+	run.progress = downloader.Progress
+	for _, file := range downloader.files {
+		if run.RunDateTime == nil || file.RunDateTime.After(*run.RunDateTime) {
+			runDateTime := file.RunDateTime
+			run.RunDateTime = &runDateTime
+		}
+		run.index[file.FileId] = len(run.Files)
+		run.Files = append(run.Files, FileResult{FileId: file.FileId, Status: StatusPending})
+	}
+	if m.onProgress != nil {
+		downloader.OnProgress(func(progress Progress) {
+			m.onProgress(run.ID, progress)
+		})
+	}
+	downloader.OnFrame(func(frame FrameEvent) {
+		frame.RunID = run.ID
+		m.events.Publish(EventFrame, frame)
+	})
+	downloader.OnResult(func(result FileResult) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if i, ok := run.index[result.FileId]; ok {
+			run.Files[i] = result
+		}
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.busy(); err != nil {
+		cancel()
+		return Run{}, err
+	}
+	m.active = run
+	m.done = make(chan struct{})
+	m.addRun(run)
+
+	log.Printf("Starting %s download run %s", trigger, run.ID)
+	go m.wait(run, downloader, ctx, m.done)
+	return run.snapshot(), nil
+}
+
+func newRun(trigger string, filter RunFilter, startTime time.Time) *Run {
+	run := &Run{
+		ID:        startTime.UTC().Format("20060102T150405.000Z"),
+		Trigger:   trigger,
+		Overlays:  filter.Overlays,
+		StartTime: startTime,
+	}
+	if filter.Restricted() {
+		filter.Overlays = nil
+		run.Filter = &filter
+	}
+	return run
+}
+
+// addRun keeps the run among the recent runs. It must be called with m.mu held
+func (m *RunManager) addRun(run *Run) {
+	m.runs = append(m.runs, run)
+	if len(m.runs) > maxRecentRuns {
+		m.runs = m.runs[len(m.runs)-maxRecentRuns:]
+	}
+}
+
+// failed records a run that could not start, e.g. because the order could not be
+// retrieved, returning the error
+func (m *RunManager) failed(trigger string, filter RunFilter, startTime time.Time, err error) error {
+	run := newRun(trigger, filter, startTime)
+	endTime := time.Now()
+	run.Status = RunFailed
+	run.EndTime = &endTime
+	run.Error = err.Error()
+	run.Files = make([]FileResult, 0)
+
+	m.mu.Lock()
+	m.addRun(run)
+	finished := run.snapshot()
+	m.mu.Unlock()
+
+	log.Printf("Download run %s failed to start: %v", run.ID, err)
+	m.finished(finished)
+	return err
+}
+
+// finished records a finished run in the history, publishes it and notifies
+// downstream services of its outcome
+func (m *RunManager) finished(run Run) {
+	if m.history != nil {
+		if err := m.history.Append(run); err != nil {
+			log.Printf("Failed to record download run %s in the history: %v", run.ID, err)
+		}
+	}
+	m.events.Publish(EventRun, newRunEvent(run))
+	if event, ok := runEvent(run); ok {
+		m.notifier.Notify(event)
+	}
+}
+
+// busy reports why a run cannot start now, if it cannot. It must be called with m.mu held
+func (m *RunManager) busy() error {
+	if !m.leader.IsLeader() {
+		return ErrNotLeader
+	}
+	if m.active != nil {
+		return ErrRunInProgress
+	}
+	if m.collecting {
+		return ErrCollecting
+	}
+	return nil
+}
+
+func (m *RunManager) wait(run *Run, downloader *Processor, ctx context.Context, done chan struct{}) {
+	defer close(done)
+	downloader.StartWorkers()
+	downloader.DispatchJobs()
+	errs := downloader.Wait()
+	summary := downloader.Summary()
+
+	m.mu.Lock()
+	run.Summary = &summary
+	run.EndTime = &summary.EndTime
+	switch {
+	case ctx.Err() != nil:
+		run.Status = RunCancelled
+	case len(errs) > 0:
+		run.Status = RunFailed
+		run.Error = fmt.Sprintf("%d file(s) failed", len(errs))
+	default:
+		run.Status = RunCompleted
+		if run.full() && run.RunDateTime != nil && run.RunDateTime.After(m.downloaded) {
+			m.downloaded = *run.RunDateTime
+		}
+	}
+	run.cancel()
+	run.progress = nil
+	m.active = nil
+	finished := run.snapshot()
+	m.mu.Unlock()
+
+	log.Printf("Download run %s %s", run.ID, run.Status)
+	m.finished(finished)
+}
+
+// runEvent describes the outcome of a finished run for downstream services. A run
+// that neither published new frames nor failed has nothing to report
+func runEvent(run Run) (notify.Event, bool) {
+	summary := notify.Summary{Published: make(map[string]int)}
+	for _, file := range run.Files {
+		summary.Bytes += file.Bytes
+		switch file.Status {
+		case StatusSucceeded:
+			summary.Succeeded++
+			summary.Published[file.Kind]++
+		case StatusSkipped:
+			summary.Skipped++
+		case StatusFailed:
+			summary.Failed++
+			if summary.Failures == nil {
+				summary.Failures = make(map[string]string)
+			}
+			summary.Failures[file.FileId] = file.Reason
+		}
+	}
+
+	var eventType notify.EventType
+	switch {
+	case run.Status == RunCompleted && summary.Succeeded > 0:
+		eventType = notify.RunPublished
+	case run.Status == RunCompleted:
+		return notify.Event{}, false
+	case summary.Succeeded > 0:
+		eventType = notify.RunPartial
+	case run.Status == RunFailed:
+		eventType = notify.RunFailed
+	default:
+		// A cancelled run that published nothing
+		return notify.Event{}, false
+	}
+
+	eventTime := run.StartTime
+	if run.EndTime != nil {
+		eventTime = *run.EndTime
+	}
+	return notify.Event{
+		ID:          string(eventType) + "/" + run.ID,
+		Type:        eventType,
+		Time:        eventTime,
+		RunID:       run.ID,
+		Trigger:     run.Trigger,
+		Status:      string(run.Status),
+		RunDateTime: run.RunDateTime,
+		Error:       run.Error,
+		Summary:     summary,
+	}, true
+}
+
+// LastDownloaded returns the runDateTime of the most recent run downloaded in full, by
+// a run of every kind that completed without errors
+func (m *RunManager) LastDownloaded() (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.downloadedLoaded || m.history == nil {
+		return m.downloaded, nil
+	}
+
+	runs, err := m.history.Query(HistoryQuery{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, run := range runs {
+		if run.Status == RunCompleted && run.full() && run.RunDateTime != nil && run.RunDateTime.After(m.downloaded) {
+			m.downloaded = *run.RunDateTime
+		}
+	}
+	m.downloadedLoaded = true
+	return m.downloaded, nil
+}
+
+// Runs returns the current and recent runs, most recent first
+func (m *RunManager) Runs() []Run {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]Run, 0, len(m.runs))
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		runs = append(runs, m.runs[i].snapshot())
+	}
+	return runs
+}
+
+func (m *RunManager) Get(id string) (Run, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, run := range m.runs {
+		if run.ID == id {
+			return run.snapshot(), nil
+		}
+	}
+	return Run{}, ErrRunNotFound
+}
+
+// Cancel stops the run: files not yet started are skipped, and those in progress are
+// completed
+func (m *RunManager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active != nil && m.active.ID == id {
+		log.Printf("Cancelling download run %s", id)
+		m.active.cancel()
+		return nil
+	}
+	for _, run := range m.runs {
+		if run.ID == id {
+			return ErrRunFinished
+		}
+	}
+	return ErrRunNotFound
+}
+
+// cancelActive cancels the active run, if any, once another instance may start one
+func (m *RunManager) cancelActive() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active != nil {
+		log.Printf("Cancelling download run %s, as another instance may now download", m.active.ID)
+		m.active.cancel()
+	}
+}
+
+// Wait blocks until the active run, if any, has finished
+func (m *RunManager) Wait() {
+	m.mu.Lock()
+	done := m.done
+	m.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// Cleanup applies the retention policy and, unless dryRun is set, then deletes any
+// deduplicated content that no frame refers to any more. Content is only collected
+// when no download run is active, and no run can start until it is done. Only the
+// instance holding the cron lock, if there is one, cleans up
+func (m *RunManager) Cleanup(ctx context.Context, dryRun bool) (RetentionReport, error) {
+	if !dryRun && !m.leader.IsLeader() {
+		return RetentionReport{}, ErrNotLeader
+	}
+	report, err := ApplyRetention(ctx, m.frames, m.cfg.Retention, time.Now().UTC(), dryRun)
+	if err != nil {
+		return report, err
+	}
+	if dryRun {
+		return report, nil
+	}
+	if report.Total.Frames > 0 {
+		m.events.Publish(EventCleanup, report)
+	}
+
+	m.mu.Lock()
+	if err := m.busy(); err != nil {
+		m.mu.Unlock()
+		log.Printf("Not collecting unreferenced blobs: %v", err)
+		return report, nil
+	}
+	m.collecting = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.collecting = false
+		m.mu.Unlock()
+	}()
+	collectUnreferencedBlobs(ctx, m.frames)
+	return report, nil
+}
@@ -0,0 +1,457 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/lock"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/notify"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDataHub serves an order of blank images, optionally signalling each download
+// and waiting for it to be released. Retrieving the order fails while err is set
+type fakeDataHub struct {
+	files   []metoffice.File
+	started chan string
+	release chan struct{}
+	err     error
+	mu      sync.Mutex
+	calls   int
+
+	// ordering and orderRelease likewise signal and hold retrieving the order
+	ordering     chan struct{}
+	orderRelease chan struct{}
+}
+
+func newFakeDataHub(fileIds ...string) *fakeDataHub {
+	runTime := time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC)
+	files := make([]metoffice.File, 0, len(fileIds))
+	for _, fileId := range fileIds {
+		files = append(files, metoffice.File{FileId: fileId, RunDateTime: runTime, Run: "00"})
+	}
+	return &fakeDataHub{files: files}
+}
+
+func (f *fakeDataHub) GetLatest(orderId string, params QueryParams) (*metoffice.Response, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	if f.ordering != nil {
+		f.ordering <- struct{}{}
+	}
+	if f.orderRelease != nil {
+		<-f.orderRelease
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &metoffice.Response{OrderDetails: metoffice.OrderDetails{Files: f.files}}, nil
+}
+
+func (f *fakeDataHub) GetLatestDataFile(orderId, fileId string, params QueryParams) (io.ReadCloser, error) {
+	if f.started != nil {
+		f.started <- fileId
+	}
+	if f.release != nil {
+		<-f.release
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// recordingSink keeps the events sent to it
+type recordingSink struct {
+	mu     sync.Mutex
+	events []notify.Event
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Send(ctx context.Context, event notify.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func testRunManager(t *testing.T, client DataHubClient) (*RunManager, store.FrameStore) {
+	root := t.TempDir()
+	frames := store.NewFileStore(root)
+	cfg := &config.Config{Cron: config.CronConfig{Download: config.DownloadJobConfig{PoolSize: 1}}}
+	runs := NewRunManager(cfg, frames, client, "order-id")
+	runs.SetHistory(NewHistory(root))
+	return runs, frames
+}
+
+func fileStatuses(run Run) map[string]FileStatus {
+	statuses := make(map[string]FileStatus, len(run.Files))
+	for _, file := range run.Files {
+		statuses[file.FileId] = file.Status
+	}
+	return statuses
+}
+
+func TestRunManager_Start(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500", "snow_ts0_2025091500")
+	runs, frames := testRunManager(t, client)
+	sink := &recordingSink{}
+	notifier := notify.NewNotifier(1, sink)
+	runs.SetNotifier(notifier)
+
+	_, err := runs.Start(TriggerAdmin, []string{"hail"})
+	assert.ErrorContains(t, err, "order contains no files of kind hail")
+
+	started, err := runs.Start(TriggerAdmin, []string{"rain"})
+	require.NoError(t, err)
+	assert.Equal(t, RunRunning, started.Status)
+	assert.Len(t, started.Files, 3)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCompleted, run.Status)
+	assert.Equal(t, TriggerAdmin, run.Trigger)
+	assert.NotNil(t, run.EndTime)
+	assert.Equal(t, map[string]FileStatus{
+		"rain_ts0_2025091500": StatusSucceeded,
+		"rain_ts1_2025091500": StatusSucceeded,
+		"snow_ts0_2025091500": StatusSkipped,
+	}, fileStatuses(run))
+	assert.Equal(t, 2, run.Summary.Succeeded)
+	assert.Positive(t, run.Files[0].Bytes)
+	assert.Equal(t, 2*run.Files[0].Bytes, run.Summary.Bytes)
+
+	recorded, err := runs.history.Query(HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, run.ID, recorded[0].ID)
+	assert.Equal(t, RunCompleted, recorded[0].Status)
+	assert.Equal(t, run.Files, recorded[0].Files)
+
+	notifier.Wait()
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, notify.RunPublished, sink.events[0].Type)
+	assert.Equal(t, run.ID, sink.events[0].RunID)
+	assert.Equal(t, map[string]int{"rain": 2}, sink.events[0].Summary.Published)
+
+	_, err = frames.Stat(context.Background(), "rain/2025/09/15/01.webp")
+	assert.NoError(t, err)
+	_, err = frames.Stat(context.Background(), "snow/2025/09/15/00.webp")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+
+	assert.ErrorIs(t, runs.Cancel(started.ID), ErrRunFinished)
+	assert.ErrorIs(t, runs.Cancel("unknown"), ErrRunNotFound)
+	_, err = runs.Get("unknown")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+}
+
+func TestRunManager_Composite(t *testing.T) {
+	client := newFakeDataHub("total_precipitation_rate_ts0_2025091500")
+	runs, frames := testRunManager(t, client)
+	composite := "total_precipitation_rate_composite/2025/09/15/00.webp"
+
+	// Without a basemap there is no composite
+	runs.cfg.Download.BasemapDir = t.TempDir()
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCompleted, run.Status)
+	_, err = frames.Stat(context.Background(), composite)
+	assert.ErrorIs(t, err, store.ErrNotExist)
+
+	// A basemap without the optional coastline is enough
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8))))
+	require.NoError(t, os.WriteFile(filepath.Join(runs.cfg.Download.BasemapDir, "basemap.png"), buf.Bytes(), 0644))
+	started, err = runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+	run, err = runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCompleted, run.Status)
+	assert.Equal(t, 1, run.Summary.Succeeded)
+	_, err = frames.Stat(context.Background(), composite)
+	assert.NoError(t, err)
+}
+
+func TestRunManager_Cancel(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500", "rain_ts2_2025091500")
+	client.started = make(chan string, 3)
+	client.release = make(chan struct{})
+	runs, _ := testRunManager(t, client)
+
+	started, err := runs.Start(TriggerCron, nil)
+	require.NoError(t, err)
+
+	_, err = runs.Start(TriggerAdmin, nil)
+	assert.ErrorIs(t, err, ErrRunInProgress)
+
+	// The file being downloaded when the run is cancelled is completed
+	assert.Equal(t, "rain_ts0_2025091500", <-client.started)
+	require.NoError(t, runs.Cancel(started.ID))
+	close(client.release)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCancelled, run.Status)
+	assert.Equal(t, map[string]FileStatus{
+		"rain_ts0_2025091500": StatusSucceeded,
+		"rain_ts1_2025091500": StatusSkipped,
+		"rain_ts2_2025091500": StatusSkipped,
+	}, fileStatuses(run))
+
+	listed := runs.Runs()
+	require.Len(t, listed, 1)
+	assert.Equal(t, started.ID, listed[0].ID)
+
+	// A new run can start once the previous one has finished
+	_, err = runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+	assert.Len(t, runs.Runs(), 2)
+}
+
+func TestRunEvent(t *testing.T) {
+	run := func(status RunStatus, files ...FileResult) Run {
+		return Run{ID: "20250915T040000.000Z", Trigger: TriggerCron, Status: status, Files: files}
+	}
+	succeeded := FileResult{FileId: "rain_ts0_2025091500", Kind: "rain", Status: StatusSucceeded, Bytes: 72}
+	skipped := FileResult{FileId: "rain_ts1_2025091500", Kind: "rain", Status: StatusSkipped, Reason: "already exists"}
+	failed := FileResult{FileId: "snow_ts0_2025091500", Kind: "snow", Status: StatusFailed, Reason: "boom"}
+
+	event, ok := runEvent(run(RunCompleted, succeeded, skipped))
+	require.True(t, ok)
+	assert.Equal(t, notify.RunPublished, event.Type)
+	assert.Equal(t, "run.published/20250915T040000.000Z", event.ID)
+	assert.Equal(t, notify.Summary{Published: map[string]int{"rain": 1}, Succeeded: 1, Skipped: 1, Bytes: 72}, event.Summary)
+
+	event, ok = runEvent(run(RunFailed, succeeded, failed))
+	require.True(t, ok)
+	assert.Equal(t, notify.RunPartial, event.Type)
+	assert.Equal(t, map[string]string{"snow_ts0_2025091500": "boom"}, event.Summary.Failures)
+
+	event, ok = runEvent(run(RunCancelled, succeeded, skipped))
+	require.True(t, ok)
+	assert.Equal(t, notify.RunPartial, event.Type)
+
+	event, ok = runEvent(run(RunFailed, skipped, failed))
+	require.True(t, ok)
+	assert.Equal(t, notify.RunFailed, event.Type)
+
+	// Nothing new was published
+	_, ok = runEvent(run(RunCompleted, skipped))
+	assert.False(t, ok)
+	_, ok = runEvent(run(RunCancelled, skipped))
+	assert.False(t, ok)
+}
+
+func TestRunManager_RunHours(t *testing.T) {
+	client := newFakeDataHub("rain_ts6_2025091500", "rain_ts6_2025091512")
+	runs, frames := testRunManager(t, client)
+
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]FileStatus{
+		"rain_ts6_2025091500": StatusSucceeded,
+		"rain_ts6_2025091512": StatusSucceeded,
+	}, fileStatuses(run))
+
+	for _, key := range []string{"rain/2025/09/15/06.webp", "rain/2025/09/15/12Z/06.webp"} {
+		_, err = frames.Stat(context.Background(), key)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestRunManager_CleanupWaitsForRuns(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	frames := store.NewDedupStore(store.NewFileStore(root))
+	client := newFakeDataHub("rain_ts0_2025091500")
+	client.started = make(chan string, 1)
+	client.release = make(chan struct{})
+	runs := NewRunManager(&config.Config{Cron: config.CronConfig{Download: config.DownloadJobConfig{PoolSize: 1}}}, frames, client, "order-id")
+
+	// An unreferenced blob, old enough to be collected
+	require.NoError(t, frames.Put(ctx, "rain/2025/09/14/00.webp", strings.NewReader("stale"), 5, store.PutOptions{}))
+	require.NoError(t, frames.Delete(ctx, "rain/2025/09/14/00.webp"))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, filepath.WalkDir(filepath.Join(root, ".blobs"), func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}))
+	unreferenced := func() int {
+		report, err := frames.Report(ctx)
+		require.NoError(t, err)
+		return report.Unreferenced
+	}
+
+	_, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	<-client.started
+	_, err = runs.Cleanup(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, unreferenced(), "not collected during a run")
+
+	close(client.release)
+	runs.Wait()
+	_, err = runs.Cleanup(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, unreferenced())
+
+	runs.collecting = true
+	_, err = runs.Start(TriggerAdmin, nil)
+	assert.ErrorIs(t, err, ErrCollecting)
+}
+
+func TestRunManager_StartWithoutLock(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500")
+	client.ordering = make(chan struct{}, 2)
+	client.orderRelease = make(chan struct{})
+	runs, _ := testRunManager(t, client)
+
+	type result struct {
+		run Run
+		err error
+	}
+	results := make(chan result, 2)
+	for range 2 {
+		go func() {
+			run, err := runs.Start(TriggerAdmin, nil)
+			results <- result{run, err}
+		}()
+	}
+	<-client.ordering
+	<-client.ordering
+
+	// The runs can be listed while both orders are being retrieved
+	assert.Empty(t, runs.Runs())
+
+	close(client.orderRelease)
+	first, second := <-results, <-results
+	runs.Wait()
+	if first.err != nil {
+		first, second = second, first
+	}
+	require.NoError(t, first.err)
+	assert.ErrorIs(t, second.err, ErrRunInProgress, "only one of the runs starts")
+	assert.Len(t, runs.Runs(), 1)
+}
+
+func TestRunManager_StartFailure(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500")
+	client.err = errors.New("service unavailable")
+	runs, _ := testRunManager(t, client)
+	sink := &recordingSink{}
+	notifier := notify.NewNotifier(1, sink)
+	runs.SetNotifier(notifier)
+	events := NewEventHub()
+	runs.SetEvents(events)
+	_, published, unsubscribe := events.Subscribe(0)
+	defer unsubscribe()
+
+	_, err := runs.Start(TriggerCron, []string{"rain"})
+	assert.ErrorContains(t, err, "service unavailable")
+
+	listed := runs.Runs()
+	require.Len(t, listed, 1)
+	assert.Equal(t, RunFailed, listed[0].Status)
+	assert.Equal(t, TriggerCron, listed[0].Trigger)
+	assert.Equal(t, []string{"rain"}, listed[0].Overlays)
+	assert.Contains(t, listed[0].Error, "service unavailable")
+	assert.NotNil(t, listed[0].EndTime)
+
+	recorded, err := runs.history.Query(HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, listed[0].ID, recorded[0].ID)
+	assert.Equal(t, listed[0].Error, recorded[0].Error)
+	assert.Empty(t, recorded[0].Files)
+
+	// Downstream services are told of the failure, and so are clients of the events stream
+	notifier.Wait()
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, notify.RunFailed, sink.events[0].Type)
+	assert.Equal(t, listed[0].ID, sink.events[0].RunID)
+	assert.Equal(t, listed[0].Error, sink.events[0].Error)
+	event := <-published
+	assert.Equal(t, EventRun, event.Type)
+	assert.Equal(t, RunFailed, event.Data.(RunEvent).Status)
+	assert.Equal(t, listed[0].Error, event.Data.(RunEvent).Error)
+
+	// A filter that the order cannot satisfy is not a failed run
+	client.err = nil
+	_, err = runs.Start(TriggerAdmin, []string{"hail"})
+	assert.Error(t, err)
+	assert.Len(t, runs.Runs(), 1)
+}
+
+func TestRunManager_Leader(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDataHub("rain_ts0_2025091500", "rain_ts1_2025091500")
+	client.started = make(chan string, 2)
+	client.release = make(chan struct{})
+	runs, _ := testRunManager(t, client)
+
+	path := filepath.Join(t.TempDir(), ".cron.lock")
+	leader, err := NewLeader(lock.NewFileLock(path), time.Minute)
+	require.NoError(t, err)
+	other, err := NewLeader(lock.NewFileLock(path), time.Minute)
+	require.NoError(t, err)
+	runs.SetLeader(leader)
+
+	// Runs and cleanups are left to the instance holding the lock
+	_, err = runs.Start(TriggerAdmin, nil)
+	assert.ErrorIs(t, err, ErrNotLeader)
+	_, err = runs.Cleanup(ctx, false)
+	assert.ErrorIs(t, err, ErrNotLeader)
+	_, err = runs.Cleanup(ctx, true)
+	assert.NoError(t, err, "a dry run changes nothing")
+	assert.Empty(t, runs.Runs())
+
+	require.True(t, leader.Campaign(ctx))
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "rain_ts0_2025091500", <-client.started)
+
+	// Losing the lock cancels the active run, as another instance may start one
+	require.NoError(t, leader.locker.Release(ctx))
+	require.True(t, other.Campaign(ctx))
+	assert.False(t, leader.Campaign(ctx))
+	close(client.release)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RunCancelled, run.Status)
+	assert.Equal(t, StatusSkipped, fileStatuses(run)["rain_ts1_2025091500"])
+}
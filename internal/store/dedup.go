@@ -0,0 +1,338 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// blobPrefix is where content is stored by hash, hidden from the frame keys
+	blobPrefix = ".blobs/sha256/"
+
+	// blobMetadata and sizeMetadata are kept on pointer objects, naming the blob that
+	// holds their content and its size
+	blobMetadata = "blob"
+	sizeMetadata = "blob-size"
+
+	// blobGracePeriod protects newly written blobs from garbage collection, as they
+	// are written before the key referring to them
+	blobGracePeriod = time.Hour
+	// blobRefreshAge is how old a blob may be before a Put reusing it writes it again,
+	// renewing its grace period, so a concurrent CollectGarbage that has not seen the
+	// new pointer yet leaves it alone
+	blobRefreshAge = blobGracePeriod / 2
+)
+
+// Linker is implemented by stores that can make a key refer to the content of another
+// without copying it, as hardlinks do on a local filesystem
+type Linker interface {
+	Link(ctx context.Context, existing, key string, opts PutOptions) error
+	// Links returns the number of keys referring to the content of the key, including
+	// the key itself
+	Links(ctx context.Context, key string) (int, error)
+	// ContentID identifies the content of the key, which is the same for every key
+	// linked to it
+	ContentID(ctx context.Context, key string) (string, error)
+}
+
+// DedupReport describes how much space content-addressed storage saves
+type DedupReport struct {
+	// References is the number of keys referring to a blob
+	References int `json:"references"`
+	// LogicalBytes is the total size of the content of those keys
+	LogicalBytes int64 `json:"logicalBytes"`
+	Blobs        int   `json:"blobs"`
+	// StoredBytes is the total size of the blobs actually stored
+	StoredBytes       int64 `json:"storedBytes"`
+	Unreferenced      int   `json:"unreferenced"`
+	UnreferencedBytes int64 `json:"unreferencedBytes"`
+	// Collected counts the unreferenced blobs deleted by CollectGarbage
+	Collected      int   `json:"collected"`
+	CollectedBytes int64 `json:"collectedBytes"`
+}
+
+// SavedBytes is the space saved by storing identical content only once
+func (r DedupReport) SavedBytes() int64 {
+	return r.LogicalBytes - (r.StoredBytes - r.UnreferencedBytes)
+}
+
+// DedupStore stores each distinct content once, as a blob named by its SHA-256 hash,
+// with every key referring to it. On stores that implement Linker (the local
+// filesystem) keys are hardlinks to the blob; elsewhere they are small pointer objects
+// whose metadata names the blob. Blobs are only deleted by CollectGarbage, once no key
+// refers to them any more
+type DedupStore struct {
+	base   FrameStore
+	linker Linker
+}
+
+func NewDedupStore(base FrameStore) *DedupStore {
+	linker, _ := base.(Linker)
+	return &DedupStore{base: base, linker: linker}
+}
+
+func blobKey(hash string) string {
+	return blobPrefix + hash[:2] + "/" + hash
+}
+
+func isBlob(key string) bool {
+	return strings.HasPrefix(key, blobPrefix)
+}
+
+func (s *DedupStore) Put(ctx context.Context, key string, r io.Reader, _ int64, opts PutOptions) error {
+	if isBlob(key) {
+		return fmt.Errorf("%s: reserved key", key)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	blob := blobKey(hex.EncodeToString(sum[:]))
+	putBlob := func() error {
+		return s.base.Put(ctx, blob, bytes.NewReader(data), int64(len(data)), PutOptions{ContentType: opts.ContentType})
+	}
+
+	info, err := s.base.Stat(ctx, blob)
+	switch {
+	case errors.Is(err, ErrNotExist):
+		err = putBlob()
+	case err != nil:
+	case s.linker == nil && time.Since(info.ModTime) > blobRefreshAge:
+		err = putBlob()
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.linker != nil {
+		// A hardlink keeps the content even if the blob is collected afterwards, but
+		// the blob may already have gone
+		err := s.linker.Link(ctx, blob, key, opts)
+		if errors.Is(err, ErrNotExist) {
+			if err := putBlob(); err != nil {
+				return err
+			}
+			err = s.linker.Link(ctx, blob, key, opts)
+		}
+		return err
+	}
+
+	metadata := make(map[string]string, len(opts.Metadata)+2)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[blobMetadata] = blob
+	metadata[sizeMetadata] = strconv.Itoa(len(data))
+	// The pointer also holds the blob key as its content, as some S3 implementations
+	// reject empty objects without a content length
+	if err := s.base.Put(ctx, key, strings.NewReader(blob), int64(len(blob)), PutOptions{ContentType: opts.ContentType, Metadata: metadata}); err != nil {
+		return err
+	}
+
+	// CollectGarbage may have deleted the blob between the Stat and the pointer being
+	// written, in which case it is written again
+	if _, err := s.base.Stat(ctx, blob); errors.Is(err, ErrNotExist) {
+		return putBlob()
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *DedupStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	if isBlob(key) {
+		return nil, ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	r, info, err := s.base.Get(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	blob, ok := info.Metadata[blobMetadata]
+	if !ok {
+		return r, info, nil
+	}
+	_ = r.Close()
+
+	r, blobInfo, err := s.base.Get(ctx, blob)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("failed to read blob of %s: %w", key, err)
+	}
+	return r, resolvePointer(info, blobInfo.Size), nil
+}
+
+func (s *DedupStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if isBlob(key) {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	info, err := s.base.Stat(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if size, ok := info.Metadata[sizeMetadata]; ok {
+		n, _ := strconv.ParseInt(size, 10, 64)
+		return resolvePointer(info, n), nil
+	}
+	return info, nil
+}
+
+// Content identifies the content that the key refers to, shared by every key with the
+// same content, and returns its size. Keys written before dedup was enabled are their
+// own content
+func (s *DedupStore) Content(ctx context.Context, key string) (string, int64, error) {
+	if isBlob(key) {
+		return "", 0, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	info, err := s.base.Stat(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	if s.linker != nil {
+		id, err := s.linker.ContentID(ctx, key)
+		if err != nil {
+			return "", 0, err
+		}
+		return id, info.Size, nil
+	}
+	if blob, ok := info.Metadata[blobMetadata]; ok {
+		size, _ := strconv.ParseInt(info.Metadata[sizeMetadata], 10, 64)
+		return blob, size, nil
+	}
+	return key, info.Size, nil
+}
+
+// resolvePointer describes a pointer object as if it held the content of its blob
+func resolvePointer(info ObjectInfo, size int64) ObjectInfo {
+	info.Size = size
+	metadata := make(map[string]string, len(info.Metadata))
+	for k, v := range info.Metadata {
+		if k != blobMetadata && k != sizeMetadata {
+			metadata[k] = v
+		}
+	}
+	info.Metadata = metadata
+	return info
+}
+
+// List calls fn for every key with the prefix, excluding blobs. Pointer objects are
+// listed with their own size, not that of their blob
+func (s *DedupStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return s.base.List(ctx, prefix, func(info ObjectInfo) error {
+		if isBlob(info.Key) {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// Delete removes the key. The blob it referred to is left for CollectGarbage
+func (s *DedupStore) Delete(ctx context.Context, key string) error {
+	if isBlob(key) {
+		return fmt.Errorf("%s: reserved key", key)
+	}
+	return s.base.Delete(ctx, key)
+}
+
+// Report counts the references to every blob, and how much space they save
+func (s *DedupStore) Report(ctx context.Context) (DedupReport, error) {
+	report, _, err := s.scan(ctx)
+	return report, err
+}
+
+// CollectGarbage deletes the blobs that no key refers to any more, other than those
+// written within the last hour which may be about to be referenced. When dryRun is
+// set, the blobs are only counted. It should not run while frames are being written,
+// as a Put reusing a blob can still race with its deletion
+func (s *DedupStore) CollectGarbage(ctx context.Context, dryRun bool) (DedupReport, error) {
+	report, unreferenced, err := s.scan(ctx)
+	if err != nil {
+		return report, err
+	}
+	cutoff := time.Now().Add(-blobGracePeriod)
+	for _, blob := range unreferenced {
+		if !dryRun {
+			// Skip blobs a Put has rewritten since the scan
+			info, err := s.base.Stat(ctx, blob.Key)
+			if errors.Is(err, ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+			if !info.ModTime.Before(cutoff) {
+				continue
+			}
+			if err := s.base.Delete(ctx, blob.Key); err != nil {
+				return report, err
+			}
+		}
+		report.Collected++
+		report.CollectedBytes += blob.Size
+	}
+	return report, nil
+}
+
+// scan builds the dedup report, also returning the unreferenced blobs that are old
+// enough to be collected
+func (s *DedupStore) scan(ctx context.Context) (DedupReport, []ObjectInfo, error) {
+	refs := make(map[string]int)
+	if s.linker == nil {
+		err := s.List(ctx, "", func(info ObjectInfo) error {
+			stat, err := s.base.Stat(ctx, info.Key)
+			if errors.Is(err, ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if blob, ok := stat.Metadata[blobMetadata]; ok {
+				refs[blob]++
+			}
+			return nil
+		})
+		if err != nil {
+			return DedupReport{}, nil, err
+		}
+	}
+
+	var report DedupReport
+	unreferenced := make([]ObjectInfo, 0)
+	cutoff := time.Now().Add(-blobGracePeriod)
+	err := s.base.List(ctx, blobPrefix, func(info ObjectInfo) error {
+		n := refs[info.Key]
+		if s.linker != nil {
+			links, err := s.linker.Links(ctx, info.Key)
+			if errors.Is(err, ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			n = links - 1
+		}
+
+		report.Blobs++
+		report.StoredBytes += info.Size
+		report.References += n
+		report.LogicalBytes += info.Size * int64(n)
+		if n == 0 {
+			report.Unreferenced++
+			report.UnreferencedBytes += info.Size
+			if info.ModTime.Before(cutoff) {
+				unreferenced = append(unreferenced, info)
+			}
+		}
+		return nil
+	})
+	return report, unreferenced, err
+}
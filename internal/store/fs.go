@@ -0,0 +1,283 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tmpPattern names the temporary files that objects are written to before being
+// renamed into place
+const tmpPattern = "download-*.tmp"
+
+// FileStore keeps frames in a directory tree on the local filesystem. Any user
+// metadata is kept in a hidden .{name}.attrs file alongside the object
+type FileStore struct {
+	root string
+}
+
+type fileAttrs struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+// Root is the directory the store is rooted at
+func (s *FileStore) Root() string {
+	return s.root
+}
+
+// Path returns the location of the key on the local filesystem
+func (s *FileStore) Path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *FileStore) attrsPath(key string) string {
+	dir, name := path.Split(key)
+	return s.Path(dir + "." + name + ".attrs")
+}
+
+func (s *FileStore) Put(_ context.Context, key string, r io.Reader, _ int64, opts PutOptions) error {
+	filename := s.Path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := s.writeAttrs(key, opts); err != nil {
+		return err
+	}
+	return writeAtomically(filename, r)
+}
+
+// Link makes the key a hardlink to the file of an existing key, replacing any object
+// already stored under it, so that the content is only stored once
+func (s *FileStore) Link(_ context.Context, existing, key string, opts PutOptions) error {
+	filename := s.Path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := s.writeAttrs(key, opts); err != nil {
+		return err
+	}
+
+	// Link to a temporary name, then rename over the key so readers never see it missing
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), tmpPattern)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp := tmpFile.Name()
+	_ = tmpFile.Close()
+	_ = os.Remove(tmp)
+
+	if err := os.Link(s.Path(existing), tmp); err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filename)
+	// When the key is already a link to the same file, rename does nothing and leaves tmp
+	_ = os.Remove(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to rename temporary link: %w", err)
+	}
+	return nil
+}
+
+// Links returns the number of keys hardlinked to the same file as the key, including
+// the key itself
+func (s *FileStore) Links(_ context.Context, key string) (int, error) {
+	fi, err := os.Stat(s.Path(key))
+	if err != nil {
+		return 0, err
+	}
+	return linkCount(fi)
+}
+
+// ContentID identifies the file the key is hardlinked to
+func (s *FileStore) ContentID(_ context.Context, key string) (string, error) {
+	fi, err := os.Stat(s.Path(key))
+	if err != nil {
+		return "", err
+	}
+	return fileID(fi)
+}
+
+func (s *FileStore) writeAttrs(key string, opts PutOptions) error {
+	if len(opts.Metadata) == 0 {
+		if err := os.Remove(s.attrsPath(key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	attrs := fileAttrs{ContentType: opts.ContentType, Metadata: make(map[string]string, len(opts.Metadata))}
+	for k, v := range opts.Metadata {
+		attrs.Metadata[strings.ToLower(k)] = v
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return writeAtomically(s.attrsPath(key), bytes.NewReader(data))
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	if IsHidden(key) && !isBlob(key) {
+		return nil, ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	f, err := os.Open(s.Path(key))
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		_ = f.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return f, info, nil
+}
+
+func (s *FileStore) Stat(_ context.Context, key string) (ObjectInfo, error) {
+	if IsHidden(key) && !isBlob(key) {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+	fi, err := os.Stat(s.Path(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotExist)
+	}
+
+	info := s.info(key, fi)
+	data, err := os.ReadFile(s.attrsPath(key))
+	if err != nil && !os.IsNotExist(err) {
+		return ObjectInfo{}, err
+	}
+	if err == nil {
+		var attrs fileAttrs
+		if err := json.Unmarshal(data, &attrs); err != nil {
+			return ObjectInfo{}, fmt.Errorf("failed to parse attributes of %s: %w", key, err)
+		}
+		if attrs.ContentType != "" {
+			info.ContentType = attrs.ContentType
+		}
+		info.Metadata = attrs.Metadata
+	}
+	return info, nil
+}
+
+func (s *FileStore) info(key string, fi fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}
+}
+
+func (s *FileStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	// Only walk the deepest directory that can contain the prefix
+	start := prefix
+	if !strings.HasSuffix(start, "/") {
+		start = path.Dir(start)
+	}
+
+	err := filepath.WalkDir(s.Path(start), func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, filename)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || isTemporary(d.Name()) || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted since the directory was read
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(s.info(key, fi))
+	})
+	return err
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	for _, filename := range []string{s.Path(key), s.attrsPath(key)} {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsHidden reports whether any segment of the key starts with a dot. Such files, like
+// the run history, the lock file and attribute sidecars, are not frames, so are
+// neither listed nor read. Only the blobs of a DedupStore are read through the store
+func IsHidden(key string) bool {
+	for segment := range strings.SplitSeq(key, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func isTemporary(name string) bool {
+	matched, _ := filepath.Match(tmpPattern, name)
+	return matched
+}
+
+// writeAtomically writes to a temporary file in the same directory and renames it to
+// filename once complete, so readers never observe a partially written file
+func writeAtomically(filename string, r io.Reader) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), tmpPattern)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	cleanupTemp := true
+	defer func() {
+		_ = tmpFile.Close()
+		if cleanupTemp {
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file before rename: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	cleanupTemp = false // Successfully renamed, don't delete
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package store
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func linkCount(fs.FileInfo) (int, error) {
+	return 0, errors.New("hardlink counts are not supported on this platform")
+}
+
+func fileID(fs.FileInfo) (string, error) {
+	return "", errors.New("file ids are not supported on this platform")
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+func linkCount(fi fs.FileInfo) (int, error) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("link count of %s is not available", fi.Name())
+	}
+	return int(stat.Nlink), nil
+}
+
+func fileID(fi fs.FileInfo) (string, error) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("file id of %s is not available", fi.Name())
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), nil
+}
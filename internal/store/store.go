@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+)
+
+// ErrNotExist is returned (wrapped) when a key is not in the store
+var ErrNotExist = fs.ErrNotExist
+
+// ObjectInfo describes a stored frame file. Keys are slash-separated paths relative to
+// the root of the store, e.g. total_precipitation_rate/2025/09/14/06.webp
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// Metadata holds the user metadata given when the object was put. It is only
+	// populated by Get and Stat, not List
+	Metadata map[string]string
+}
+
+type PutOptions struct {
+	ContentType string
+	// Metadata keys are case-insensitive, and are returned in lower case
+	Metadata map[string]string
+}
+
+// FrameStore is where processed frames are kept, so that the downloader, cleanup job
+// and API server can share a local directory or a bucket in an S3-compatible store
+type FrameStore interface {
+	// Put stores size bytes read from r under the key, replacing any existing object.
+	// Readers never observe a partially written object
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List calls fn for every object whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// Delete removes the key, and does not fail when it does not exist
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	BackendFS = "fs"
+	BackendS3 = "s3"
+)
+
+// New returns the frame store selected by the config, which for the filesystem
+// backend is rooted at rootDir
+func New(cfg config.StorageConfig, rootDir string) (FrameStore, error) {
+	var base FrameStore
+	switch cfg.Backend {
+	case "", BackendFS:
+		base = NewFileStore(rootDir)
+	case BackendS3:
+		s3, err := NewS3Store(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		base = s3
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s (expected %s or %s)", cfg.Backend, BackendFS, BackendS3)
+	}
+
+	if cfg.Dedup {
+		return NewDedupStore(base), nil
+	}
+	return base, nil
+}
@@ -0,0 +1,319 @@
+package store_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeS3Store(t *testing.T, prefix string) store.FrameStore {
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("frames"))
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s, err := store.NewS3Store(config.S3Config{
+		Endpoint:  endpoint.Host,
+		Bucket:    "frames",
+		Prefix:    prefix,
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestFrameStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.FrameStore{
+		"fs": func(t *testing.T) store.FrameStore {
+			return store.NewFileStore(t.TempDir())
+		},
+		"s3": func(t *testing.T) store.FrameStore {
+			return newFakeS3Store(t, "")
+		},
+		"s3 with prefix": func(t *testing.T) store.FrameStore {
+			return newFakeS3Store(t, "/overlays/")
+		},
+		"dedup fs": func(t *testing.T) store.FrameStore {
+			return store.NewDedupStore(store.NewFileStore(t.TempDir()))
+		},
+		"dedup s3": func(t *testing.T) store.FrameStore {
+			return store.NewDedupStore(newFakeS3Store(t, ""))
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testFrameStore(t, newStore(t))
+		})
+	}
+}
+
+func put(t *testing.T, s store.FrameStore, key, content string, opts store.PutOptions) {
+	t.Helper()
+	require.NoError(t, s.Put(context.Background(), key, strings.NewReader(content), int64(len(content)), opts))
+}
+
+func testFrameStore(t *testing.T, s store.FrameStore) {
+	ctx := context.Background()
+
+	_, err := s.Stat(ctx, "cloud/2025/09/14/06.webp")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+	_, _, err = s.Get(ctx, "cloud/2025/09/14/06.webp")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+
+	put(t, s, "cloud/2025/09/14/06.webp", "frame", store.PutOptions{
+		ContentType: "image/webp",
+		Metadata:    map[string]string{"Run-Time": "2025-09-14T00:00:00Z"},
+	})
+	put(t, s, "cloud/2025/09/14/07.webp", "next frame", store.PutOptions{ContentType: "image/webp"})
+	put(t, s, "cloud/2025/09/15/00.webp", "tomorrow", store.PutOptions{ContentType: "image/webp"})
+	put(t, s, "rain/2025/09/14/06.webp", "rain", store.PutOptions{ContentType: "image/webp"})
+
+	info, err := s.Stat(ctx, "cloud/2025/09/14/06.webp")
+	require.NoError(t, err)
+	assert.Equal(t, "cloud/2025/09/14/06.webp", info.Key)
+	assert.Equal(t, int64(5), info.Size)
+	assert.Equal(t, "image/webp", info.ContentType)
+	assert.Equal(t, map[string]string{"run-time": "2025-09-14T00:00:00Z"}, info.Metadata)
+
+	r, info, err := s.Get(ctx, "cloud/2025/09/14/07.webp")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "next frame", string(data))
+	assert.Equal(t, int64(10), info.Size)
+	assert.Empty(t, info.Metadata)
+
+	put(t, s, "cloud/2025/09/14/06.webp", "replaced", store.PutOptions{ContentType: "image/webp"})
+	info, err = s.Stat(ctx, "cloud/2025/09/14/06.webp")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), info.Size)
+
+	list := func(prefix string) []string {
+		keys := make([]string, 0)
+		require.NoError(t, s.List(ctx, prefix, func(info store.ObjectInfo) error {
+			keys = append(keys, info.Key)
+			return nil
+		}))
+		return keys
+	}
+	assert.Equal(t, []string{"cloud/2025/09/14/06.webp", "cloud/2025/09/14/07.webp", "cloud/2025/09/15/00.webp"}, list("cloud/"))
+	assert.Equal(t, []string{"cloud/2025/09/14/06.webp", "cloud/2025/09/14/07.webp"}, list("cloud/2025/09/14"))
+	assert.Equal(t, []string{"cloud/2025/09/14/07.webp"}, list("cloud/2025/09/14/07"))
+	assert.Len(t, list(""), 4)
+	assert.Empty(t, list("snow/"))
+
+	require.NoError(t, s.Delete(ctx, "cloud/2025/09/14/06.webp"))
+	require.NoError(t, s.Delete(ctx, "cloud/2025/09/14/06.webp"))
+	_, err = s.Stat(ctx, "cloud/2025/09/14/06.webp")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+	assert.Equal(t, []string{"cloud/2025/09/14/07.webp"}, list("cloud/2025/09/14/"))
+}
+
+func TestDedupStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.FrameStore{
+		"fs": func(t *testing.T) store.FrameStore {
+			return store.NewFileStore(t.TempDir())
+		},
+		"s3": func(t *testing.T) store.FrameStore {
+			return newFakeS3Store(t, "")
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testDedupStore(t, newStore(t))
+		})
+	}
+}
+
+func testDedupStore(t *testing.T, base store.FrameStore) {
+	ctx := context.Background()
+	s := store.NewDedupStore(base)
+
+	put(t, s, "cloud/2025/09/14/06.webp", "frame", store.PutOptions{ContentType: "image/webp"})
+	put(t, s, "cloud/2025/09/14/06.thumb.webp", "frame", store.PutOptions{ContentType: "image/webp"})
+	put(t, s, "cloud/2025/09/14/06.thumb.webp", "frame", store.PutOptions{ContentType: "image/webp"})
+	put(t, s, "cloud/2025/09/14/07.webp", "other", store.PutOptions{ContentType: "image/webp"})
+
+	report, err := s.Report(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, store.DedupReport{References: 3, LogicalBytes: 15, Blobs: 2, StoredBytes: 10}, report)
+	assert.Equal(t, int64(5), report.SavedBytes())
+
+	r, _, err := s.Get(ctx, "cloud/2025/09/14/06.thumb.webp")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "frame", string(data))
+
+	// The blobs are not visible through the dedup store
+	keys := make([]string, 0)
+	require.NoError(t, s.List(ctx, "", func(info store.ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	}))
+	assert.Len(t, keys, 3)
+
+	// A blob only becomes unreferenced once every key referring to it is deleted
+	require.NoError(t, s.Delete(ctx, "cloud/2025/09/14/06.webp"))
+	require.NoError(t, s.Delete(ctx, "cloud/2025/09/14/07.webp"))
+	report, err = s.Report(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, store.DedupReport{References: 1, LogicalBytes: 5, Blobs: 2, StoredBytes: 10, Unreferenced: 1, UnreferencedBytes: 5}, report)
+
+	// Recently written blobs are not collected, in case they are about to be referenced
+	report, err = s.CollectGarbage(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Collected)
+
+	_, err = s.Stat(ctx, "cloud/2025/09/14/06.thumb.webp")
+	require.NoError(t, err)
+}
+
+// racyStore runs a hook after every Stat, to interleave other work with a Put
+type racyStore struct {
+	store.FrameStore
+	afterStat func(key string, info *store.ObjectInfo)
+	puts      map[string]int
+}
+
+func (s *racyStore) Stat(ctx context.Context, key string) (store.ObjectInfo, error) {
+	info, err := s.FrameStore.Stat(ctx, key)
+	if err == nil && s.afterStat != nil {
+		s.afterStat(key, &info)
+	}
+	return info, err
+}
+
+func (s *racyStore) Put(ctx context.Context, key string, r io.Reader, size int64, opts store.PutOptions) error {
+	s.puts[key]++
+	return s.FrameStore.Put(ctx, key, r, size, opts)
+}
+
+type racyLinkStore struct {
+	*racyStore
+	store.Linker
+}
+
+func newRacyStores() map[string]func(t *testing.T) (*racyStore, store.FrameStore) {
+	return map[string]func(t *testing.T) (*racyStore, store.FrameStore){
+		"fs": func(t *testing.T) (*racyStore, store.FrameStore) {
+			fs := store.NewFileStore(t.TempDir())
+			racy := &racyStore{FrameStore: fs, puts: make(map[string]int)}
+			return racy, &racyLinkStore{racyStore: racy, Linker: fs}
+		},
+		"s3": func(t *testing.T) (*racyStore, store.FrameStore) {
+			racy := &racyStore{FrameStore: newFakeS3Store(t, ""), puts: make(map[string]int)}
+			return racy, racy
+		},
+	}
+}
+
+func TestDedupStore_PutRacesCollection(t *testing.T) {
+	for name, newStore := range newRacyStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			racy, base := newStore(t)
+			s := store.NewDedupStore(base)
+			put(t, s, "cloud/2025/09/14/06.webp", "frame", store.PutOptions{ContentType: "image/webp"})
+			require.NoError(t, s.Delete(ctx, "cloud/2025/09/14/06.webp"))
+
+			// Garbage collection deletes the unreferenced blob as soon as a Put has
+			// found it can be reused
+			racy.afterStat = func(key string, _ *store.ObjectInfo) {
+				if strings.HasPrefix(key, ".blobs/") {
+					racy.afterStat = nil
+					require.NoError(t, racy.Delete(ctx, key))
+				}
+			}
+			put(t, s, "cloud/2025/09/14/07.webp", "frame", store.PutOptions{ContentType: "image/webp"})
+
+			r, _, err := s.Get(ctx, "cloud/2025/09/14/07.webp")
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.Equal(t, "frame", string(data))
+
+			report, err := s.Report(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, report.Blobs)
+			assert.Zero(t, report.Unreferenced)
+		})
+	}
+}
+
+func TestDedupStore_RefreshesReusedBlobs(t *testing.T) {
+	racy := &racyStore{FrameStore: newFakeS3Store(t, ""), puts: make(map[string]int)}
+	s := store.NewDedupStore(racy)
+
+	put(t, s, "cloud/2025/09/14/06.webp", "frame", store.PutOptions{})
+	put(t, s, "cloud/2025/09/14/07.webp", "frame", store.PutOptions{})
+	blobs := 0
+	for key, n := range racy.puts {
+		if strings.HasPrefix(key, ".blobs/") {
+			blobs += n
+		}
+	}
+	assert.Equal(t, 1, blobs, "recent blobs are reused as they are")
+
+	// A blob close to the end of its grace period is written again when reused, so
+	// garbage collection running at the same time leaves it alone
+	racy.afterStat = func(key string, info *store.ObjectInfo) {
+		info.ModTime = time.Now().Add(-2 * time.Hour)
+	}
+	put(t, s, "cloud/2025/09/14/08.webp", "frame", store.PutOptions{})
+	blobs = 0
+	for key, n := range racy.puts {
+		if strings.HasPrefix(key, ".blobs/") {
+			blobs += n
+		}
+	}
+	assert.Equal(t, 2, blobs)
+}
+
+func TestFileStore_Hidden(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s := store.NewFileStore(root)
+	for _, key := range []string{".history.jsonl", "rain/.rain.webp.attrs", ".blobs/sha256/ab/abc"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, key)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, key), []byte("hidden"), 0644))
+
+		_, err := s.Stat(ctx, key)
+		if key == ".blobs/sha256/ab/abc" {
+			// Blobs are read through the store by a DedupStore, which hides them itself
+			assert.NoError(t, err)
+			_, err = store.NewDedupStore(s).Stat(ctx, key)
+		}
+		assert.ErrorIs(t, err, store.ErrNotExist, key)
+	}
+	_, _, err := s.Get(ctx, ".history.jsonl")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+
+	assert.True(t, store.IsHidden(".cron.lock"))
+	assert.True(t, store.IsHidden("rain/.tmp/00.webp"))
+	assert.False(t, store.IsHidden("rain/2025/09/15/00.webp"))
+	assert.False(t, store.IsHidden(""))
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+)
+
+type FileStatus string
+
+const (
+	StatusPending   FileStatus = "pending"
+	StatusSucceeded FileStatus = "succeeded"
+	StatusSkipped   FileStatus = "skipped"
+	StatusFailed    FileStatus = "failed"
+)
+
+// FileResult is the outcome of processing a single file from the order
+type FileResult struct {
+	FileId string     `json:"fileId"`
+	Kind   string     `json:"kind,omitempty"`
+	Status FileStatus `json:"status"`
+	Reason string     `json:"reason,omitempty"`
+	// Bytes is the size of the file downloaded from DataHub
+	Bytes int64 `json:"bytes,omitempty"`
+
+	err error
+}
+
+// RunSummary describes what a download run found in the order and what happened to
+// each file that was not processed successfully
+type RunSummary struct {
+	StartTime time.Time      `json:"startTime"`
+	EndTime   time.Time      `json:"endTime"`
+	Kinds     map[string]int `json:"kinds"`
+	Unmatched []string       `json:"unmatched"`
+	Succeeded int            `json:"succeeded"`
+	Bytes     int64          `json:"bytes"`
+	Skipped   []FileResult   `json:"skipped"`
+	Failed    []FileResult   `json:"failed"`
+}
+
+// skipError marks a file that was deliberately not processed, along with the reason
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+func skipped(reason string) error {
+	return &skipError{reason: reason}
+}
+
+func newFileResult(file metoffice.File, kind string, err error) FileResult {
+	result := FileResult{FileId: file.FileId, Kind: kind, Status: StatusSucceeded}
+	var skip *skipError
+	switch {
+	case err == nil:
+	case errors.As(err, &skip):
+		result.Status = StatusSkipped
+		result.Reason = skip.reason
+	default:
+		result.Status = StatusFailed
+		result.Reason = err.Error()
+		result.err = err
+	}
+	return result
+}
+
+func (s *RunSummary) add(result FileResult) {
+	s.Bytes += result.Bytes
+	switch result.Status {
+	case StatusSucceeded:
+		s.Succeeded++
+	case StatusSkipped:
+		s.Skipped = append(s.Skipped, result)
+	case StatusFailed:
+		s.Failed = append(s.Failed, result)
+	}
+}
+
+// sortedKinds returns the kinds found in the order in alphabetical order
+func (s *RunSummary) sortedKinds() []string {
+	kinds := make([]string, 0, len(s.Kinds))
+	for kind := range s.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Err returns the error a failed file was processed with, keeping the original error
+// for errors.Is and errors.As when it is available
+func (r FileResult) Err() error {
+	if r.Status != StatusFailed {
+		return nil
+	}
+	if r.err != nil {
+		return fmt.Errorf("%s: %w", r.FileId, r.err)
+	}
+	return fmt.Errorf("%s: %s", r.FileId, r.Reason)
+}
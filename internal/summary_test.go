@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileResult(t *testing.T) {
+	file := metoffice.File{FileId: "rain_ts0_2025091500"}
+
+	result := newFileResult(file, "rain", nil)
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.Empty(t, result.Reason)
+	assert.NoError(t, result.Err())
+
+	result = newFileResult(file, "rain", skipped("already exists"))
+	assert.Equal(t, StatusSkipped, result.Status)
+	assert.Equal(t, "already exists", result.Reason)
+	assert.NoError(t, result.Err())
+
+	cause := fmt.Errorf("failed to open: %w", fs.ErrPermission)
+	result = newFileResult(file, "rain", cause)
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, "failed to open: permission denied", result.Reason)
+	assert.EqualError(t, result.Err(), "rain_ts0_2025091500: failed to open: permission denied")
+	assert.ErrorIs(t, result.Err(), fs.ErrPermission)
+
+	// Results read back from the history only have the reason
+	result = FileResult{FileId: file.FileId, Status: StatusFailed, Reason: "boom"}
+	assert.EqualError(t, result.Err(), "rain_ts0_2025091500: boom")
+}
+
+func TestRunSummary_Add(t *testing.T) {
+	summary := RunSummary{Kinds: map[string]int{"snow": 1, "rain": 2}}
+	summary.add(FileResult{FileId: "rain_ts0_2025091500", Status: StatusSucceeded, Bytes: 10})
+	summary.add(FileResult{FileId: "rain_ts1_2025091500", Status: StatusSkipped, Reason: "already exists"})
+	summary.add(FileResult{FileId: "snow_ts0_2025091500", Status: StatusFailed, Reason: "boom", Bytes: 5})
+
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, int64(15), summary.Bytes)
+	assert.Equal(t, []FileResult{{FileId: "rain_ts1_2025091500", Status: StatusSkipped, Reason: "already exists"}}, summary.Skipped)
+	assert.Equal(t, []FileResult{{FileId: "snow_ts0_2025091500", Status: StatusFailed, Reason: "boom", Bytes: 5}}, summary.Failed)
+	assert.Equal(t, []string{"rain", "snow"}, summary.sortedKinds())
+}
+
+func TestRunManager_Summary(t *testing.T) {
+	client := newFakeDataHub("rain_ts0_2025091500", "snow_ts0_2025091500", "not-a-file-id")
+	runs, _ := testRunManager(t, client)
+
+	started, err := runs.Start(TriggerAdmin, nil)
+	require.NoError(t, err)
+	runs.Wait()
+
+	run, err := runs.Get(started.ID)
+	require.NoError(t, err)
+	require.NotNil(t, run.Summary)
+	assert.Equal(t, map[string]int{"rain": 1, "snow": 1}, run.Summary.Kinds)
+	assert.Equal(t, []string{"not-a-file-id"}, run.Summary.Unmatched)
+	assert.Equal(t, 2, run.Summary.Succeeded)
+	assert.Empty(t, run.Summary.Failed)
+	assert.False(t, run.Summary.EndTime.Before(run.Summary.StartTime))
+
+}
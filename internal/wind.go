@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/imageprocessing"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/legend"
+	metoffice "github.com/rm-hull/metoffice-uk-weather-overlays/internal/models/met_office"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/store"
+)
+
+const (
+	windSpeedKind     = "wind_speed_at_10m"
+	windDirectionKind = "wind_direction_at_10m"
+	windVectorsKind   = "wind_vectors"
+
+	// windGridStep is the spacing in pixels between sampled wind vectors
+	windGridStep = 24
+	// windLookupTolerance is how far (RGB distance) a pixel may be from a legend colour
+	windLookupTolerance = 40
+	// windMinDecoded is the fraction of the visible samples of a wind image that must
+	// match a legend colour. Coastlines and labels drawn over the data account for a few
+	// misses, while more suggest the legend does not match the DataHub style
+	windMinDecoded = 0.8
+)
+
+// windGlyphKinds are rendered from the combined wind speed and direction vector field
+var windGlyphKinds = []string{"wind_arrows", "wind_barbs"}
+
+type windKey struct {
+	runTime  time.Time
+	timestep int
+}
+
+type windSamples struct {
+	bounds    image.Rectangle
+	speed     []float64
+	direction []float64
+}
+
+// windCollector pairs up the wind speed and direction frames of each timestep, which
+// arrive independently from different workers
+type windCollector struct {
+	speed     *legend.Legend
+	direction *legend.Legend
+	// enabled is only set when both legends were loaded from the config, as the built
+	// in ones are provisional and would not decode real frames
+	enabled bool
+
+	mu      sync.Mutex
+	pending map[windKey]*windSamples
+}
+
+func newWindCollector() *windCollector {
+	return &windCollector{
+		speed:     Overlays[windSpeedKind].Legend,
+		direction: Overlays[windDirectionKind].Legend,
+		enabled:   Overlays[windSpeedKind].LegendLoaded && Overlays[windDirectionKind].LegendLoaded,
+		pending:   make(map[windKey]*windSamples),
+	}
+}
+
+func isWindComponent(kind string) bool {
+	return kind == windSpeedKind || kind == windDirectionKind
+}
+
+// add samples the raw DataHub image of one wind component on a regular grid, decoding
+// pixel colours back to values with the component's legend. Once both components for
+// the timestep have been added, the combined vector field is returned. It fails when
+// too few of the image's colours match the legend to trust the decoded values
+func (w *windCollector) add(kind string, key windKey, img image.Image) (*imageprocessing.VectorField, error) {
+	l := w.speed
+	if kind == windDirectionKind {
+		l = w.direction
+	}
+
+	bounds := img.Bounds()
+	field := imageprocessing.NewVectorField(bounds, windGridStep)
+	values := make([]float64, field.Cols*field.Rows)
+	visible, decoded := 0, 0
+	for row := range field.Rows {
+		for col := range field.Cols {
+			value := math.NaN()
+			if pt := field.Point(col, row); pt.In(bounds) {
+				c := img.At(pt.X, pt.Y)
+				if _, _, _, a := c.RGBA(); a > 0 {
+					visible++
+				}
+				if v, ok := l.Lookup(c, windLookupTolerance); ok {
+					value = v
+					decoded++
+				}
+			}
+			values[row*field.Cols+col] = value
+		}
+	}
+	if visible > 0 && float64(decoded) < windMinDecoded*float64(visible) {
+		return nil, fmt.Errorf("only %d of %d %s samples match the legend colours, check the legend matches the DataHub style", decoded, visible, kind)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	samples, ok := w.pending[key]
+	if !ok {
+		samples = &windSamples{bounds: bounds}
+		w.pending[key] = samples
+	}
+	if kind == windSpeedKind {
+		samples.speed = values
+	} else {
+		samples.direction = values
+	}
+
+	if samples.speed == nil || samples.direction == nil || samples.bounds != bounds {
+		return nil, nil
+	}
+	delete(w.pending, key)
+
+	for i := range field.U {
+		if math.IsNaN(samples.speed[i]) || math.IsNaN(samples.direction[i]) {
+			continue
+		}
+		field.Set(i%field.Cols, i/field.Cols, samples.speed[i], samples.direction[i])
+	}
+	return field, nil
+}
+
+// unpaired clears the timesteps still waiting for their other component, as when it
+// failed or was not fetched, returning a description of each
+func (w *windCollector) unpaired() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	descriptions := make([]string, 0, len(w.pending))
+	for key, samples := range w.pending {
+		missing := windDirectionKind
+		if samples.speed == nil {
+			missing = windSpeedKind
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s T+%d (no %s)", key.runTime.Format(time.RFC3339), key.timestep, missing))
+	}
+	clear(w.pending)
+	slices.Sort(descriptions)
+	return descriptions
+}
+
+// windProductsPending reports whether any of the wind products derived for the
+// timestep are missing, in which case both components need to be fetched again
+func (p *Processor) windProductsPending(id metoffice.FileID) (bool, error) {
+	for _, kind := range windGlyphKinds {
+		id.Kind = kind
+		dir, hour := p.frameDir(id)
+		pending, err := p.pendingVariants(dir, hour, id.RunTime(), p.encoderFor(kind))
+		if err != nil || len(pending) > 0 {
+			return true, err
+		}
+	}
+
+	id.Kind = windVectorsKind
+	dir, hour := p.frameDir(id)
+	switch cmp, err := p.layout.compareStoredRun(p.ctx, p.frames, dir, hour, id.RunTime()); {
+	case err != nil:
+		return false, err
+	case cmp != 0:
+		return cmp < 0, nil
+	}
+	_, err := p.frames.Stat(p.ctx, windVectorsFilename(dir, hour))
+	if errors.Is(err, store.ErrNotExist) {
+		return true, nil
+	}
+	return false, err
+}
+
+// writeWindProducts renders the vector field with each glyph overlay's pipeline and
+// exports it as a JSON U/V grid for front-end particle animation
+func (p *Processor) writeWindProducts(field *imageprocessing.VectorField, id metoffice.FileID) error {
+	validTime := id.ValidTime()
+	for _, kind := range windGlyphKinds {
+		id.Kind = kind
+		dir, hour := p.frameDir(id)
+		frame := &imageprocessing.ProcessedImage{
+			Img:       image.NewNRGBA(field.Bounds),
+			Kind:      kind,
+			ValidTime: validTime,
+			Field:     field,
+		}
+		if err := frame.Pipeline(p.overlays[kind].Pipeline...); err != nil {
+			return fmt.Errorf("failed to process %s pipeline: %w", kind, err)
+		}
+		if err := p.writeVariants(frame, dir, hour, p.variants, p.encoderFor(kind)); err != nil {
+			return err
+		}
+		if err := p.writeMetadata(dir, hour, id); err != nil {
+			return fmt.Errorf("failed to write frame metadata: %w", err)
+		}
+		p.published(id, VariantFilename(dir, hour, imageprocessing.Variant{Name: imageprocessing.FullVariant}, p.encoderFor(kind).Extension()))
+	}
+
+	id.Kind = windVectorsKind
+	dir, hour := p.frameDir(id)
+	data, err := field.JSON(validTime, "m/s")
+	if err != nil {
+		return fmt.Errorf("failed to encode wind vectors: %w", err)
+	}
+	err = p.put(windVectorsFilename(dir, hour), "application/json", func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.writeMetadata(dir, hour, id); err != nil {
+		return err
+	}
+	p.published(id, windVectorsFilename(dir, hour))
+	return nil
+}
+
+func windVectorsFilename(dir string, hour int) string {
+	return fmt.Sprintf("%s/%02d.json", dir, hour)
+}
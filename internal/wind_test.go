@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/legend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSpeedLegend = &legend.Legend{Stops: []legend.Stop{
+		{Value: 5, Color: color.NRGBA{0, 0, 255, 255}},
+		{Value: 15, Color: color.NRGBA{255, 0, 0, 255}},
+	}}
+	testDirectionLegend = &legend.Legend{Stops: []legend.Stop{
+		{Value: 90, Color: color.NRGBA{0, 255, 0, 255}},
+		{Value: 270, Color: color.NRGBA{255, 0, 255, 255}},
+	}}
+)
+
+func testWindCollector() *windCollector {
+	return &windCollector{
+		speed:     testSpeedLegend,
+		direction: testDirectionLegend,
+		enabled:   true,
+		pending:   make(map[windKey]*windSamples),
+	}
+}
+
+// windImage paints the left and right halves of an image in two colours
+func windImage(bounds image.Rectangle, left, right color.Color) *image.NRGBA {
+	img := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if x < bounds.Min.X+bounds.Dx()/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+	return img
+}
+
+func TestWindCollector_Add(t *testing.T) {
+	w := testWindCollector()
+	bounds := image.Rect(0, 0, 2*windGridStep, windGridStep)
+	key := windKey{runTime: time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC), timestep: 1}
+
+	field, err := w.add(windSpeedKind, key, windImage(bounds, color.NRGBA{0, 0, 255, 255}, color.NRGBA{250, 10, 5, 255}))
+	require.NoError(t, err)
+	assert.Nil(t, field, "waits for the direction")
+
+	other := windKey{runTime: key.runTime, timestep: 2}
+	field, err = w.add(windDirectionKind, other, windImage(bounds, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}))
+	require.NoError(t, err)
+	assert.Nil(t, field, "directions are paired by timestep")
+
+	field, err = w.add(windDirectionKind, key, windImage(bounds, color.NRGBA{0, 255, 0, 255}, color.NRGBA{255, 0, 255, 255}))
+	require.NoError(t, err)
+	require.NotNil(t, field)
+	assert.Equal(t, 2, field.Cols)
+	assert.Equal(t, 1, field.Rows)
+
+	// 5 m/s from the east, then 15 m/s from the west
+	u, v, ok := field.At(0, 0)
+	require.True(t, ok)
+	assert.InDelta(t, -5, u, 1e-9)
+	assert.InDelta(t, 0, v, 1e-9)
+	u, v, ok = field.At(1, 0)
+	require.True(t, ok)
+	assert.InDelta(t, 15, u, 1e-9)
+	assert.InDelta(t, 0, v, 1e-9)
+
+	assert.NotContains(t, w.pending, key)
+	assert.Contains(t, w.pending, other)
+}
+
+func TestWindCollector_AddGaps(t *testing.T) {
+	w := testWindCollector()
+	bounds := image.Rect(0, 0, 2*windGridStep, windGridStep)
+	key := windKey{timestep: 1}
+
+	// Transparent samples have no data, and are not counted against the legend
+	_, err := w.add(windSpeedKind, key, windImage(bounds, color.Transparent, color.NRGBA{0, 0, 255, 255}))
+	require.NoError(t, err)
+	field, err := w.add(windDirectionKind, key, windImage(bounds, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}))
+	require.NoError(t, err)
+	require.NotNil(t, field)
+	_, _, ok := field.At(0, 0)
+	assert.False(t, ok)
+	_, _, ok = field.At(1, 0)
+	assert.True(t, ok)
+
+	// Frames of different sizes are not combined
+	_, err = w.add(windSpeedKind, key, windImage(bounds, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 0, 255, 255}))
+	require.NoError(t, err)
+	field, err = w.add(windDirectionKind, key, windImage(image.Rect(0, 0, windGridStep, windGridStep), color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}))
+	require.NoError(t, err)
+	assert.Nil(t, field)
+}
+
+func TestWindCollector_AddUnknownColours(t *testing.T) {
+	w := testWindCollector()
+	bounds := image.Rect(0, 0, 2*windGridStep, windGridStep)
+
+	_, err := w.add(windSpeedKind, windKey{}, windImage(bounds, color.NRGBA{0, 0, 255, 255}, color.NRGBA{128, 128, 128, 255}))
+	assert.ErrorContains(t, err, "only 1 of 2 wind_speed_at_10m samples match the legend colours")
+	assert.Empty(t, w.pending)
+}
+
+func TestWindCollector_Unpaired(t *testing.T) {
+	w := testWindCollector()
+	bounds := image.Rect(0, 0, 2*windGridStep, windGridStep)
+	runTime := time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC)
+
+	_, err := w.add(windSpeedKind, windKey{runTime: runTime, timestep: 2}, windImage(bounds, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 0, 255, 255}))
+	require.NoError(t, err)
+	_, err = w.add(windDirectionKind, windKey{runTime: runTime, timestep: 1}, windImage(bounds, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"2025-09-15T00:00:00Z T+1 (no wind_speed_at_10m)",
+		"2025-09-15T00:00:00Z T+2 (no wind_direction_at_10m)",
+	}, w.unpaired())
+	assert.Empty(t, w.pending)
+	assert.Empty(t, w.unpaired())
+}
+
+func TestLoadLegends(t *testing.T) {
+	original := Overlays[windSpeedKind]
+	t.Cleanup(func() {
+		Overlays[windSpeedKind] = original
+	})
+	assert.False(t, newWindCollector().enabled, "the built-in legends are provisional")
+
+	path := filepath.Join(t.TempDir(), "wind_speed.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"title": "Wind", "units": "m/s", "stops": [{"value": 1, "color": "#0000ff"}]}`), 0644))
+
+	require.NoError(t, LoadLegends(map[string]string{windSpeedKind: path}))
+	assert.Equal(t, "Wind", Overlays[windSpeedKind].Legend.Title)
+	assert.True(t, Overlays[windSpeedKind].LegendLoaded)
+	assert.False(t, newWindCollector().enabled, "both legends must be loaded")
+	assert.Equal(t, original.Pipeline, Overlays[windSpeedKind].Pipeline)
+
+	v, ok := newWindCollector().speed.Lookup(color.NRGBA{0, 0, 255, 255}, windLookupTolerance)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, v)
+
+	assert.Error(t, LoadLegends(map[string]string{"unknown": path}))
+	assert.Error(t, LoadLegends(map[string]string{windSpeedKind: filepath.Join(t.TempDir(), "missing.json")}))
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/rm-hull/metoffice-uk-weather-overlays/cmd"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal"
+	"github.com/rm-hull/metoffice-uk-weather-overlays/internal/config"
 	"github.com/spf13/cobra"
 )
 
@@ -13,7 +15,15 @@ func main() {
 	var rootPath string
 	var port int
 	var debug bool
-	var poolSize int
+	var downloadOpts cmd.DownloadOptions
+	var configPath string
+	var traceDir string
+	var basemapDir string
+	var removeSource bool
+	var dryRun bool
+	var gc bool
+	var cronCfg config.CronConfig
+	var historyOpts cmd.HistoryOptions
 
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
@@ -24,31 +34,167 @@ func main() {
 		Long: `Met Office UK weather overlays`,
 	}
 
+	// loadConfig reads the config file, applying any overrides given on the command line
+	loadConfig := func(c *cobra.Command) (*config.Config, error) {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if c.Flags().Changed("trace-dir") {
+			cfg.Debug.TraceDir = traceDir
+		}
+		if c.Flags().Changed("basemap-dir") {
+			cfg.Download.BasemapDir = basemapDir
+		}
+		if err := internal.LoadLegends(cfg.Download.Legends); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
 	apiServerCmd := &cobra.Command{
 		Use:   "api-server [--port <port>] [--debug]",
 		Short: "Start HTTP API server",
-		RunE: func(_ *cobra.Command, _ []string) error {
-			return cmd.ApiServer(rootPath, port, debug)
+		RunE: func(c *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			if c.Flags().Changed("timezone") {
+				cfg.Cron.Timezone = cronCfg.Timezone
+			}
+			if c.Flags().Changed("jobs") {
+				cfg.Cron.Jobs = cronCfg.Jobs
+			}
+			if c.Flags().Changed("download-schedule") {
+				cfg.Cron.Download.Schedule = cronCfg.Download.Schedule
+			}
+			if c.Flags().Changed("pool-size") {
+				cfg.Cron.Download.PoolSize = cronCfg.Download.PoolSize
+			}
+			if c.Flags().Changed("catch-up") {
+				cfg.Cron.Download.CatchUp = cronCfg.Download.CatchUp
+			}
+			if c.Flags().Changed("download-mode") {
+				cfg.Cron.Download.Mode = cronCfg.Download.Mode
+			}
+			if c.Flags().Changed("poll-interval") {
+				cfg.Cron.Download.Poll.Interval = cronCfg.Download.Poll.Interval
+			}
+			if c.Flags().Changed("poll-max-calls") {
+				cfg.Cron.Download.Poll.MaxCallsPerDay = cronCfg.Download.Poll.MaxCallsPerDay
+			}
+			if c.Flags().Changed("lock") {
+				cfg.Cron.Lock.Backend = cronCfg.Lock.Backend
+			}
+			if c.Flags().Changed("cleanup-schedule") {
+				cfg.Cron.Cleanup.Schedule = cronCfg.Cleanup.Schedule
+			}
+			return cmd.ApiServer(cfg, rootPath, port, debug)
 		},
 	}
 
 	apiServerCmd.Flags().IntVar(&port, "port", 8080, "Port to run HTTP server on")
 	apiServerCmd.Flags().BoolVar(&debug, "debug", false, "Enable debugging (pprof) - WARING: do not enable in production")
+	apiServerCmd.Flags().StringVar(&cronCfg.Timezone, "timezone", "", "Timezone of the job schedules (default: local time)")
+	apiServerCmd.Flags().StringSliceVar(&cronCfg.Jobs, "jobs", nil, "Jobs to run on schedule: download, cleanup or none (default: all)")
+	apiServerCmd.Flags().StringVar(&cronCfg.Download.Schedule, "download-schedule", config.DefaultDownloadSchedule, "Cron schedule of the download job")
+	apiServerCmd.Flags().IntVar(&cronCfg.Download.PoolSize, "pool-size", config.DefaultDownloadPoolSize, "Number of parallel downloads in the download job")
+	apiServerCmd.Flags().BoolVar(&cronCfg.Download.CatchUp, "catch-up", true, "Download immediately when the most recent scheduled download was missed")
+	apiServerCmd.Flags().StringVar(&cronCfg.Download.Mode, "download-mode", config.DownloadModeSchedule, "When to download: schedule, or poll to download each new run as soon as it is published")
+	apiServerCmd.Flags().DurationVar(&cronCfg.Download.Poll.Interval, "poll-interval", config.DefaultPollInterval, "Interval between checks of the order for a new run in poll mode")
+	apiServerCmd.Flags().IntVar(&cronCfg.Download.Poll.MaxCallsPerDay, "poll-max-calls", config.DefaultPollMaxCallsPerDay, "Maximum DataHub calls per day in poll mode, including file downloads (0 for unlimited)")
+	apiServerCmd.Flags().StringVar(&cronCfg.Lock.Backend, "lock", "none", "Lock letting only one replica run the jobs: none, file or redis")
+	apiServerCmd.Flags().StringVar(&cronCfg.Cleanup.Schedule, "cleanup-schedule", config.DefaultCleanupSchedule, "Cron schedule of the cleanup job")
 
 	downloadCmd := &cobra.Command{
-		Use:   "download [--pool-size <num>]",
+		Use:   "download [--pool-size <num>] [--progress <mode>] [--overlay <kind>] [--timesteps <range>] [--force] [--dry-run]",
 		Short: "Initiate download",
-		Run: func(_ *cobra.Command, _ []string) {
-			if err := cmd.Download(rootPath, poolSize); err != nil {
+		Run: func(c *cobra.Command, _ []string) {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				log.Fatalf("failed to load config: %v", err)
+			}
+			if err := cmd.Download(cfg, rootPath, downloadOpts); err != nil {
 				log.Fatalf("failed to download: %v", err)
 			}
 		},
 	}
-	downloadCmd.Flags().IntVar(&poolSize, "pool-size", 4, "Number of parallel downloads")
+	downloadCmd.Flags().IntVar(&downloadOpts.PoolSize, "pool-size", 4, "Number of parallel downloads")
+	downloadCmd.Flags().StringVar(&downloadOpts.Progress, "progress", cmd.ProgressAuto, "How to report progress: auto (a bar on a terminal, otherwise JSON lines), bar, json or none")
+	downloadCmd.Flags().StringSliceVar(&downloadOpts.Overlays, "overlay", nil, "Only download these kinds of data, e.g. cloud_amount_total (repeatable or comma separated)")
+	downloadCmd.Flags().StringVar(&downloadOpts.Timesteps, "timesteps", "", "Only download this range of timesteps, e.g. 0-12 or ts0-ts12")
+	downloadCmd.Flags().StringVar(&downloadOpts.ValidFrom, "valid-from", "", "Only download frames valid at or after this time (RFC 3339)")
+	downloadCmd.Flags().StringVar(&downloadOpts.ValidUntil, "valid-until", "", "Only download frames valid at or before this time (RFC 3339)")
+	downloadCmd.Flags().BoolVar(&downloadOpts.Force, "force", false, "Download files even when their frames already exist")
+	downloadCmd.Flags().IntVar(&downloadOpts.Limit, "limit", 0, "Maximum number of files to download, not counting those filtered out or whose frames exist (0 for all)")
+	downloadCmd.Flags().BoolVar(&downloadOpts.DryRun, "dry-run", false, "Print what would be downloaded, and the DataHub calls needed, without fetching any image data")
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate [--remove-source] [--dry-run]",
+		Short: "Convert downloaded frames to the valid time storage layout",
+		RunE: func(c *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			return cmd.Migrate(cfg, rootPath, removeSource, dryRun)
+		},
+	}
+	migrateCmd.Flags().BoolVar(&removeSource, "remove-source", false, "Delete the run layout files once migrated")
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be migrated without changing anything")
+
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup [--dry-run]",
+		Short: "Delete the frames that the retention policy no longer keeps",
+		RunE: func(c *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			return cmd.Cleanup(cfg, rootPath, dryRun)
+		},
+	}
+	cleanupCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be deleted and the space freed without deleting anything")
+
+	dedupCmd := &cobra.Command{
+		Use:   "dedup [--gc] [--dry-run]",
+		Short: "Report the space saved by deduplicating frames",
+		RunE: func(c *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			return cmd.Dedup(cfg, rootPath, gc, dryRun)
+		},
+	}
+	dedupCmd.Flags().BoolVar(&gc, "gc", false, "Delete blobs that no frame refers to any more (only while no download is running)")
+	dedupCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be collected without deleting anything")
+
+	historyCmd := &cobra.Command{
+		Use:   "history [--since <date>] [--until <date>] [--file <fileId>] [--status <status>]",
+		Short: "Show the recorded download runs and the outcome of their files",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return cmd.History(rootPath, historyOpts)
+		},
+	}
+	historyCmd.Flags().StringVar(&historyOpts.Since, "since", "", "Only show runs started at or after this time (YYYY-MM-DD or RFC 3339)")
+	historyCmd.Flags().StringVar(&historyOpts.Until, "until", "", "Only show runs started before this time (YYYY-MM-DD or RFC 3339)")
+	historyCmd.Flags().StringVar(&historyOpts.FileId, "file", "", "Only show files whose fileId contains this, e.g. _ts6_2025091600")
+	historyCmd.Flags().StringVar(&historyOpts.Status, "status", "", "Only show files with this status: succeeded, skipped or failed")
+	historyCmd.Flags().IntVar(&historyOpts.Limit, "limit", 20, "Maximum number of runs to show")
+	historyCmd.Flags().BoolVar(&historyOpts.JSON, "json", false, "Print each run with the outcome of every file as a JSON line")
 
 	rootCmd.PersistentFlags().StringVar(&rootPath, "root", "./data/datahub", "Path to root folder")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", config.DefaultPath, "Path to YAML config file")
+	rootCmd.PersistentFlags().StringVar(&traceDir, "trace-dir", "", "Write every intermediate pipeline stage to this directory (debugging)")
+	rootCmd.PersistentFlags().StringVar(&basemapDir, "basemap-dir", config.DefaultBasemapDir, "Directory holding the basemap.png, and optional coastline.png, that composite images are drawn with")
 	rootCmd.AddCommand(apiServerCmd)
 	rootCmd.AddCommand(downloadCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(dedupCmd)
+	rootCmd.AddCommand(historyCmd)
 	if err = rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}